@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -15,8 +16,110 @@ type Config struct {
 		Driver string `yaml:"driver"`
 		Source string `yaml:"source"`
 	} `yaml:"database"`
-	ExpectedDateFormat string `yaml:"expected_date_format"`
-	TreasuryAPIBaseURL string `yaml:"treasury_api_base_url"`
+	ExpectedDateFormat string               `yaml:"expected_date_format"`
+	TreasuryAPIBaseURL string               `yaml:"treasury_api_base_url"`
+	TreasuryClient     TreasuryClientConfig `yaml:"treasury_client"`
+	RateProvider       RateProviderConfig   `yaml:"rate_provider"`
+	RateSync           RateSyncConfig       `yaml:"rate_sync"`
+	RateCache          RateCacheConfig      `yaml:"rate_cache"`
+	Conversion         ConversionConfig     `yaml:"conversion"`
+	Idempotency        IdempotencyConfig    `yaml:"idempotency"`
+	Requests           RequestsConfig       `yaml:"requests"`
+	Refunds            RefundsConfig        `yaml:"refunds"`
+}
+
+// RefundsConfig controls how refunds are converted to other currencies.
+type RefundsConfig struct {
+	// RateDate is the date of the exchange rate refunds are converted at:
+	// purchase (default), the date of the refunded transaction, so that a full
+	// refund converts to the amount originally paid, or refund, the refund date.
+	RateDate string `yaml:"rate_date"`
+}
+
+// RequestsConfig controls how JSON request bodies are read.
+type RequestsConfig struct {
+	// MaxBodyBytes bounds the body of a single transaction request (default 64 KiB).
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// MaxBatchBodyBytes bounds the body of POST /transactions/batch (default 16 MiB).
+	MaxBatchBodyBytes int64 `yaml:"max_batch_body_bytes"`
+	// LenientJSON accepts unknown fields and repeated keys (the last one wins)
+	// instead of refusing them, for clients not yet fixed.
+	LenientJSON bool `yaml:"lenient_json"`
+}
+
+// IdempotencyConfig controls the Idempotency-Key support of POST /transactions.
+type IdempotencyConfig struct {
+	// TTL is how long a key and its response are kept for retries (default 24h).
+	// Expired keys are purged at the same interval.
+	TTL time.Duration `yaml:"ttl"`
+	// Lease is how long a request keeps its key while being processed (default 1m).
+	// A key left without a response after it, e.g. by a crash, may be claimed again.
+	Lease time.Duration `yaml:"lease"`
+}
+
+// ConversionConfig controls how transaction amounts are converted to other currencies.
+type ConversionConfig struct {
+	// Rounding is how converted amounts are rounded to the minor unit of the
+	// target currency: half_up (default), half_even or down.
+	Rounding string `yaml:"rounding"`
+	// Lookback is how long before the transaction date a rate may have become
+	// effective, in years, months and days (e.g. "6m", "90d" or "1y2m"). It
+	// defaults to 6 months, as mandated by the Treasury Reporting Rates of Exchange.
+	Lookback string `yaml:"lookback"`
+	// AllowLaterRates allows a rate effective after the transaction date, within
+	// the lookback window following it, when there is none before it.
+	AllowLaterRates bool `yaml:"allow_later_rates"`
+	// ExactDate only accepts a rate effective on the transaction date itself.
+	ExactDate bool `yaml:"exact_date"`
+	// FallbackToLatest uses the latest rate effective before the transaction
+	// date, however old, when there is none within the lookback window.
+	FallbackToLatest bool `yaml:"fallback_to_latest"`
+	// MaxConcurrency bounds the exchange rates looked up in parallel when a
+	// transaction is converted into several currencies at once.
+	MaxConcurrency int `yaml:"max_concurrency"`
+}
+
+// TreasuryClientConfig controls the deadlines, retries and circuit breaker of the calls to the Treasury API.
+type TreasuryClientConfig struct {
+	// Timeout is the deadline of a call, including its retries.
+	Timeout time.Duration `yaml:"timeout"`
+	// AttemptTimeout is the deadline of a single attempt.
+	AttemptTimeout time.Duration `yaml:"attempt_timeout"`
+	// MaxAttempts is the number of attempts of a call failing with a network error, a 5xx or a 429.
+	MaxAttempts int `yaml:"max_attempts"`
+	// BaseBackoff is the wait before the first retry; it doubles at every retry, up to MaxBackoff.
+	BaseBackoff time.Duration `yaml:"base_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	// BreakerThreshold is the number of consecutive failed calls that opens the circuit breaker. Zero disables it.
+	BreakerThreshold int `yaml:"breaker_threshold"`
+	// BreakerCooldown is how long the circuit breaker stays open before a call is tried again.
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+}
+
+// RateCacheConfig controls the in-process cache of exchange rate lookups.
+type RateCacheConfig struct {
+	// TTL is how long a rate is cached. Zero disables the cache.
+	TTL time.Duration `yaml:"ttl"`
+	// NegativeTTL is how long a "no rate available" result is cached. Zero disables negative caching.
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	// MaxEntries bounds the number of cached lookups. Zero means unbounded.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// RateSyncConfig controls the synchronisation of the local copy of the Treasury rates.
+type RateSyncConfig struct {
+	// Interval between background synchronisations while the server runs. Zero disables them.
+	Interval time.Duration `yaml:"interval"`
+	// PageSize is the number of records requested per Treasury API page.
+	PageSize int `yaml:"page_size"`
+}
+
+// RateProviderConfig selects the source of exchange rates.
+type RateProviderConfig struct {
+	// Type is one of treasury (default), local, file or ecb.
+	Type string `yaml:"type"`
+	// Path is the rates file read by the file and ecb providers.
+	Path string `yaml:"path"`
 }
 
 var (
@@ -67,5 +170,24 @@ func LoadDefaultConfig() {
 		},
 		ExpectedDateFormat: "2006-01-02",
 		TreasuryAPIBaseURL: "https://api.fiscaldata.treasury.gov/services/api/test",
+		RateProvider: RateProviderConfig{
+			Type: "treasury",
+		},
+		Conversion: ConversionConfig{
+			Rounding:       "half_up",
+			Lookback:       "6m",
+			MaxConcurrency: 4,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:   24 * time.Hour,
+			Lease: time.Minute,
+		},
+		Requests: RequestsConfig{
+			MaxBodyBytes:      64 << 10,
+			MaxBatchBodyBytes: 16 << 20,
+		},
+		Refunds: RefundsConfig{
+			RateDate: "purchase",
+		},
 	}
 }
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ListCategoriesHandler handles GET /categories.
+// It returns every category of the category tree in `data`, ordered by code.
+// Clients rebuild the tree from the parent of each category.
+func ListCategoriesHandler(categories repository.CategoryRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := categories.List(c.Request.Context())
+		if err != nil {
+			util.ErrorLogger.Println("failed to list categories:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to list categories")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": list})
+	}
+}
+
+// CreateCategoryHandler handles POST /categories.
+// It adds a category to the category tree. The expected body is a JSON object with fields:
+// - code: 1 to 50 lowercase letters, digits and hyphens, e.g. "office-supplies"
+// - name: string of 1 to 100 characters
+// - parent: optional code of the parent category
+//
+// Categories cannot be changed or removed once created, since transactions refer to them.
+//
+// If the request body is invalid, it will return 400 with the error message, or every field refused.
+// If the category is invalid or its parent does not exist, it will return 400 with every invalid field.
+// If a category with the same code exists, it will return 409.
+// If the category is successfully stored, it will return 201 with the stored category.
+func CreateCategoryHandler(categories repository.CategoryRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limitBody(c, config.AppConfig.Requests.MaxBodyBytes, defaultMaxBodyBytes)
+
+		var category model.Category
+		if !bindJSON(c, &category) {
+			return
+		}
+
+		if errs := category.Validate(); len(errs) > 0 {
+			util.InfoLogger.Println(fmt.Sprintf("category refused. StatusCode %d:", http.StatusBadRequest), errs)
+			writeValidationProblem(c, errs)
+			return
+		}
+
+		if err := categories.Create(c.Request.Context(), &category); err != nil {
+			switch {
+			case errors.Is(err, repository.ErrCategoryNotFound):
+				errs := model.ValidationErrors{{Field: "parent", Code: model.CodeUnknownCategory, Message: "Parent category does not exist", RejectedValue: category.Parent}}
+				util.InfoLogger.Println(fmt.Sprintf("category refused. StatusCode %d:", http.StatusBadRequest), errs)
+				writeValidationProblem(c, errs)
+			case errors.Is(err, repository.ErrCategoryExists):
+				util.InfoLogger.Println(fmt.Sprintf("category refused. StatusCode %d:", http.StatusConflict), err)
+				writeProblem(c, http.StatusConflict, problemCategoryExists, fmt.Sprintf("category %q already exists", category.Code))
+			default:
+				util.ErrorLogger.Println(fmt.Sprintf("failed to store category. StatusCode %d:", http.StatusInternalServerError), err)
+				writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store category")
+			}
+			return
+		}
+
+		util.InfoLogger.Println("category successfully stored:", category.Code)
+		c.JSON(http.StatusCreated, category)
+	}
+}
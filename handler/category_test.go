@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestCategoryHandlers(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	categories := repository.NewInMemoryCategoryRepository()
+	require.NoError(t, categories.Create(context.Background(), &model.Category{Code: "travel", Name: "Travel"}))
+
+	router := gin.New()
+	router.GET("/categories", ListCategoriesHandler(categories))
+	router.POST("/categories", CreateCategoryHandler(categories))
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Child category",
+			body:           `{"code": "travel-flights", "name": "Flights", "parent": "travel"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"code":"travel-flights","name":"Flights","parent":"travel"}`,
+		},
+		{
+			name:           "Existing code",
+			body:           `{"code": "travel", "name": "Trips"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"type":"/problems/category-exists","title":"The category already exists","status":409,"detail":"category \"travel\" already exists","instance":"/categories"}`,
+		},
+		{
+			name:           "Unknown parent",
+			body:           `{"code": "pets", "name": "Pets", "parent": "animals"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: `{"type":"/problems/validation-error","title":"The request has invalid fields","status":400,"detail":"Parent category does not exist","instance":"/categories",` +
+				`"errors":[{"field":"parent","code":"unknown_category","message":"Parent category does not exist","rejected_value":"animals"}]}`,
+		},
+		{
+			name:           "Invalid fields",
+			body:           `{"code": "Pets!", "name": ""}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: `{"type":"/problems/validation-error","title":"The request has invalid fields","status":400,` +
+				`"detail":"Code must be 1 to 50 lowercase letters, digits and hyphens; Name must be 1 to 100 characters","instance":"/categories","errors":[` +
+				`{"field":"code","code":"invalid_format","message":"Code must be 1 to 50 lowercase letters, digits and hyphens","rejected_value":"Pets!"},` +
+				`{"field":"name","code":"invalid_length","message":"Name must be 1 to 100 characters","rejected_value":""}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/categories", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/categories", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":[{"code":"travel","name":"Travel"},{"code":"travel-flights","name":"Flights","parent":"travel"}]}`, w.Body.String())
+}
@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ConvertTransactionHandler handles GET /transactions/:id/convert?currency=<currency>.
+// It converts the transaction amount like RetrievePurchaseTransactionHandler, with
+// the target currency given as an ISO 4217 code (e.g. EUR) or, ignoring case, as
+// a Treasury country name, country_currency_desc or common alias of the currency
+// catalogue.
+//
+// If the currency is missing, it will return 400 with the error message.
+// If the currency is unknown, it will return 400 with the error message and the close matches in "suggestions".
+// The configured conversion policy can be overridden with the query parameters
+// described in parseConversionPolicy.
+//
+// If a policy parameter is invalid, it will return 400 with the error message.
+// If the transaction does not exist, it will return 404 with the error message.
+// If the policy finds no exchange rate for the purchase, it will return 404 with the error message.
+// Otherwise it will return 200 with the converted amount, rounded to the minor
+// unit of the currency with the configured rounding mode, the currency code and
+// its scale (number of decimals), the provenance of the rate applied, the policy
+// used and how the rate was found under it ("rate_match"). Every conversion is
+// recorded in the audit trail.
+func ConvertTransactionHandler(repo repository.TransactionRepository, provider service.ExchangeRateProvider, defaultPolicy service.ConversionPolicy, rounding util.RoundingMode, conversions repository.ConversionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currency, ok := resolveCurrency(c, "currency")
+		if !ok {
+			return
+		}
+
+		policy, ok := conversionPolicy(c, defaultPolicy)
+		if !ok {
+			return
+		}
+
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		conversion, ok := convertTransaction(c, provider, rounding, conversions, policy, transaction, currency.Country, currency.Code)
+		if !ok {
+			return
+		}
+
+		response := conversionResponse(transaction, conversion)
+		response["country"] = currency.Country
+		util.InfoLogger.Println("successfully converted transaction:", response)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// resolveCurrency finds the currency of the catalogue named by the query
+// parameter. When there is none it writes the error response, with the close
+// matches, and returns false.
+func resolveCurrency(c *gin.Context, param string) (util.Currency, bool) {
+	name := c.Query(param)
+	if name == "" {
+		util.WarningLogger.Println(param + " parameter is required")
+		writeProblem(c, http.StatusBadRequest, problemInvalidRequest, param+" is required")
+		return util.Currency{}, false
+	}
+
+	return lookupCurrency(c, name)
+}
+
+// lookupCurrency finds the currency of the catalogue with the given name. When
+// there is none, including for Treasury countries missing from the catalogue,
+// whose minor unit is unknown, it writes the error response, with the close
+// matches, and returns false.
+func lookupCurrency(c *gin.Context, name string) (util.Currency, bool) {
+	currency, ok := util.LookupCurrency(name)
+	if !ok {
+		util.WarningLogger.Printf("unknown currency %q", name)
+		p := newProblem(c, http.StatusBadRequest, problemUnknownCurrency, unknownCurrencyMessage(name))
+		p.Suggestions = util.SuggestCurrencies(name)
+		p.write(c)
+		return util.Currency{}, false
+	}
+
+	return currency, true
+}
+
+// unknownCurrencyMessage is the error message of a currency missing from the catalogue.
+func unknownCurrencyMessage(name string) string {
+	return fmt.Sprintf("unknown currency %q; use an ISO 4217 code such as EUR or a Treasury country name", name)
+}
+
+// conversionPolicy returns the default conversion policy with the overrides of
+// the request. When the policy is invalid it writes the error response and
+// returns false.
+func conversionPolicy(c *gin.Context, defaultPolicy service.ConversionPolicy) (service.ConversionPolicy, bool) {
+	policy := defaultPolicy
+	if errMsg := parseConversionPolicy(c, &policy); errMsg != "" {
+		util.InfoLogger.Println(fmt.Sprintf("conversion refused. StatusCode %d:", http.StatusBadRequest), errMsg)
+		writeProblem(c, http.StatusBadRequest, problemInvalidRequest, errMsg)
+		return service.ConversionPolicy{}, false
+	}
+
+	return policy, true
+}
+
+// parseConversionPolicy applies the policy query parameters of the request to
+// the policy. The supported query parameters are:
+// - lookback: how long before the transaction date the rate may have become effective, e.g. 6m, 90d or 1y2m
+// - allow_later_rates: true to use a rate effective after the transaction date, within the lookback, when there is none before it
+// - exact_date: true to only accept a rate effective on the transaction date
+// - fallback_to_latest: true to use the latest earlier rate, however old, when there is none within the lookback
+//
+// Asking for exact_date disables allow_later_rates and fallback_to_latest unless
+// they are also given. It returns a non-empty error message when a parameter is
+// invalid or the options cannot be combined.
+func parseConversionPolicy(c *gin.Context, policy *service.ConversionPolicy) string {
+	if lookback, ok := c.GetQuery("lookback"); ok {
+		var err error
+		if policy.Lookback, err = service.ParseLookback(lookback); err != nil {
+			return "lookback must be a number of years, months and days such as 6m, 90d or 1y2m"
+		}
+	}
+
+	given := map[string]bool{}
+	for _, param := range []struct {
+		name  string
+		value *bool
+	}{
+		{"exact_date", &policy.ExactDate},
+		{"allow_later_rates", &policy.AllowLaterRates},
+		{"fallback_to_latest", &policy.FallbackToLatest},
+	} {
+		raw, ok := c.GetQuery(param.name)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return param.name + " must be true or false"
+		}
+		*param.value = value
+		given[param.name] = true
+	}
+
+	if given["exact_date"] && policy.ExactDate {
+		policy.AllowLaterRates = policy.AllowLaterRates && given["allow_later_rates"]
+		policy.FallbackToLatest = policy.FallbackToLatest && given["fallback_to_latest"]
+	}
+
+	if err := policy.Validate(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// conversionFailure is why a conversion failed: the status code, problem type and error message of the response.
+type conversionFailure struct {
+	status  int
+	kind    problemType
+	message string
+}
+
+// convertTransaction converts the transaction like convertAmount. When the
+// conversion fails it writes the error response and returns false.
+func convertTransaction(c *gin.Context, provider service.ExchangeRateProvider, rounding util.RoundingMode, conversions repository.ConversionRepository, policy service.ConversionPolicy, transaction *model.Transaction, country string, currencyCode string) (*model.Conversion, bool) {
+	conversion, failure := convertAmount(c.Request.Context(), provider, rounding, conversions, policy, transaction, country, currencyCode)
+	if failure != nil {
+		writeProblem(c, failure.status, failure.kind, failure.message)
+		return nil, false
+	}
+	return conversion, true
+}
+
+// convertAmount looks up the exchange rate of the country on the transaction
+// date under the policy, converts the transaction amount to the currency with the
+// given code (empty when unknown), rounded with the mode, and records the
+// conversion in the audit trail.
+func convertAmount(ctx context.Context, provider service.ExchangeRateProvider, rounding util.RoundingMode, conversions repository.ConversionRepository, policy service.ConversionPolicy, transaction *model.Transaction, country string, currencyCode string) (*model.Conversion, *conversionFailure) {
+	return recordConversion(ctx, provider, rounding, conversions, policy, &model.Conversion{
+		TransactionID:      transaction.ID,
+		TransactionVersion: transaction.Version,
+		TransactionDate:    transaction.TransactionDate,
+		USDAmount:          transaction.Amount,
+	}, country, currencyCode)
+}
+
+// recordConversion completes the conversion of conversion.USDAmount at the rate
+// of the country on conversion.TransactionDate, like convertAmount, and records it.
+func recordConversion(ctx context.Context, provider service.ExchangeRateProvider, rounding util.RoundingMode, conversions repository.ConversionRepository, policy service.ConversionPolicy, conversion *model.Conversion, country string, currencyCode string) (*model.Conversion, *conversionFailure) {
+	transactionDate, err := time.Parse(config.AppConfig.ExpectedDateFormat, conversion.TransactionDate)
+	if err != nil {
+		util.ErrorLogger.Println("stored transaction has an invalid date:", err)
+		return nil, &conversionFailure{http.StatusInternalServerError, problemInternal, "failed to retrieve transaction"}
+	}
+
+	// Look up the exchange rate
+	rate, match, err := service.ResolveRate(ctx, provider, country, transactionDate, policy)
+	if err != nil {
+		if errors.Is(err, service.ErrNoRate) {
+			util.WarningLogger.Printf("no exchange rate found for country %s", country)
+			return nil, &conversionFailure{http.StatusNotFound, problemNoExchangeRate, "the purchase cannot be converted to the target currency"}
+		} else if errors.Is(err, service.ErrCircuitOpen) {
+			util.WarningLogger.Println("exchange rates unavailable:", err)
+			return nil, &conversionFailure{http.StatusServiceUnavailable, problemRatesUnavailable, "exchange rates are temporarily unavailable, try again later"}
+		}
+		util.ErrorLogger.Println("failed to fetch exchange rates:", err)
+		return nil, &conversionFailure{http.StatusInternalServerError, problemInternal, "failed to fetch exchange rates"}
+	}
+
+	// Convert the amount, rounded to the minor unit of the currency
+	convertedAmount, err := conversion.USDAmount.Mul(rate.ExchangeRate, currencyCode, rounding)
+	if err != nil {
+		util.ErrorLogger.Println("failed to convert transaction amount:", err)
+		return nil, &conversionFailure{http.StatusInternalServerError, problemInternal, "failed to convert transaction amount"}
+	}
+
+	// Record the conversion, so the rate applied can be proven later
+	conversion.Country = country
+	conversion.ExchangeRate = rate.ExchangeRate
+	conversion.ConvertedAmount = convertedAmount
+	conversion.Currency = currencyCode
+	conversion.Rounding = string(rounding)
+	conversion.RateSource = rate.Source
+	conversion.RateCurrency = rate.Currency
+	conversion.RateEffectiveDate = rate.EffectiveDate
+	conversion.RateRecordDate = rate.RecordDate
+	conversion.Policy = policy.Model()
+	conversion.RateMatch = string(match)
+	conversion.ConvertedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := conversions.Record(ctx, conversion); err != nil {
+		util.ErrorLogger.Println("failed to record conversion:", err)
+		return nil, &conversionFailure{http.StatusInternalServerError, problemInternal, "failed to record conversion"}
+	}
+
+	return conversion, nil
+}
+
+// conversionResponse returns the response body of a conversion, with the
+// provenance of the rate applied.
+func conversionResponse(transaction *model.Transaction, conversion *model.Conversion) gin.H {
+	response := conversionFields(conversion)
+	response["id"] = transaction.ID
+	response["description"] = transaction.Description
+	response["transaction_date"] = transaction.TransactionDate
+	response["usd_amount"] = transaction.Amount
+	response["policy"] = conversion.Policy
+	return response
+}
+
+// conversionFields returns the converted amount of a conversion and the
+// provenance of the rate applied.
+func conversionFields(conversion *model.Conversion) gin.H {
+	fields := gin.H{
+		"exchange_rate":       conversion.ExchangeRate,
+		"converted_amount":    conversion.ConvertedAmount,
+		"scale":               conversion.ConvertedAmount.Scale(),
+		"conversion_id":       conversion.ID,
+		"rate_source":         conversion.RateSource,
+		"rate_currency":       conversion.RateCurrency,
+		"rate_effective_date": conversion.RateEffectiveDate,
+		"rate_record_date":    conversion.RateRecordDate,
+		"rate_match":          conversion.RateMatch,
+	}
+	if conversion.Currency != "" {
+		fields["currency"] = conversion.Currency
+	}
+
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	transactionDate, err := time.Parse(dateFormat, conversion.TransactionDate)
+	effectiveDate, effectiveErr := time.Parse(dateFormat, conversion.RateEffectiveDate)
+	if err == nil && effectiveErr == nil {
+		fields["days_before_purchase"] = int(transactionDate.Sub(effectiveDate).Hours() / 24)
+	}
+
+	return fields
+}
+
+// ConversionHistoryHandler handles GET /transactions/:id/conversion-history.
+// It returns the audit trail of the conversions of a transaction, oldest first,
+// including the exact rate applied and its provenance. Conversions of its refunds
+// have a "refund_id".
+//
+// If the transaction does not exist, it will return 404 with the error message.
+// Otherwise it will return 200 with the conversions in "data".
+func ConversionHistoryHandler(repo repository.TransactionRepository, conversions repository.ConversionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		history, err := conversions.ListByTransaction(c.Request.Context(), transaction.ID)
+		if err != nil {
+			util.ErrorLogger.Println("failed to list conversions:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to list conversions")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": history})
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ConvertAmountHandler handles GET /conversions?amount=<amount>&from=<currency>&to=<currency>&date=<date>.
+// It converts an arbitrary amount between two currencies, given like in
+// ConvertTransactionHandler, with the rates effective on the date (YYYY-MM-DD)
+// under the configured conversion policy, which the policy query parameters of
+// ConvertTransactionHandler override. Foreign amounts are converted to US dollars
+// by dividing by the Treasury rate, and between two foreign currencies by
+// triangulating through the US dollar. Nothing is recorded in the audit trail.
+//
+// If a parameter is missing or invalid, it will return 400 with the error message.
+// If the amount has more decimals than the minor unit of its currency, it will return 400 with the field error.
+// If a currency has no exchange rate under the policy, it will return 404 with the error message.
+// If the exchange rates are unavailable, it will return 503 with the error message.
+// Otherwise it will return 200 with the converted amount, rounded to the minor
+// unit of the target currency with the configured rounding mode, the cross rate
+// and the legs to and from the US dollar with their rates and provenance.
+func ConvertAmountHandler(provider service.ExchangeRateProvider, defaultPolicy service.ConversionPolicy, rounding util.RoundingMode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		source, ok := resolveCurrency(c, "from")
+		if !ok {
+			return
+		}
+		target, ok := resolveCurrency(c, "to")
+		if !ok {
+			return
+		}
+
+		amount, err := util.ParseExactMoney(c.Query("amount"), source.Code)
+		if errors.Is(err, util.ErrTooManyDecimals) {
+			errs := model.ValidationErrors{{Field: "amount", Code: model.CodeTooManyDecimals,
+				Message: fmt.Sprintf("amount must have at most %d decimals", util.Money{Currency: source.Code}.Scale()), RejectedValue: c.Query("amount")}}
+			util.InfoLogger.Println(fmt.Sprintf("conversion refused. StatusCode %d:", http.StatusBadRequest), errs)
+			writeValidationProblem(c, errs)
+			return
+		}
+		if err != nil || !amount.IsPositive() {
+			util.InfoLogger.Println(fmt.Sprintf("conversion refused. StatusCode %d:", http.StatusBadRequest), "invalid amount", c.Query("amount"))
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "amount must be a positive number")
+			return
+		}
+
+		date, err := time.Parse(config.AppConfig.ExpectedDateFormat, c.Query("date"))
+		if err != nil {
+			util.InfoLogger.Println(fmt.Sprintf("conversion refused. StatusCode %d:", http.StatusBadRequest), "invalid date", c.Query("date"))
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "date must be in YYYY-MM-DD format")
+			return
+		}
+
+		policy, ok := conversionPolicy(c, defaultPolicy)
+		if !ok {
+			return
+		}
+
+		conversion, err := service.CrossConvert(c.Request.Context(), provider, amount, source, target, date, policy, rounding)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrNoRate):
+				util.WarningLogger.Println("no exchange rate found:", err)
+				writeProblem(c, http.StatusNotFound, problemNoExchangeRate, "the amount cannot be converted to the target currency")
+			case errors.Is(err, service.ErrCircuitOpen):
+				util.WarningLogger.Println("exchange rates unavailable:", err)
+				writeProblem(c, http.StatusServiceUnavailable, problemRatesUnavailable, "exchange rates are temporarily unavailable, try again later")
+			default:
+				util.ErrorLogger.Println("failed to convert amount:", err)
+				writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to convert amount")
+			}
+			return
+		}
+
+		util.InfoLogger.Printf("converted %s %s to %s %s", amount, source.Code, conversion.ConvertedAmount, target.Code)
+		c.JSON(http.StatusOK, conversion)
+	}
+}
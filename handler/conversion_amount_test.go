@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestConvertAmountHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	provider := &stubProvider{rates: map[string]service.Rate{
+		"Euro Zone": {Country: "Euro Zone", Currency: "Euro", ExchangeRate: 0.9, EffectiveDate: "2024-03-31", Source: service.TreasurySource},
+		"Canada":    {Country: "Canada", Currency: "Dollar", ExchangeRate: 1.35, EffectiveDate: "2024-03-31", Source: service.TreasurySource},
+	}}
+
+	router := gin.New()
+	router.GET("/conversions", ConvertAmountHandler(provider, service.DefaultConversionPolicy, util.RoundHalfUp))
+
+	convert := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/conversions"+query, nil)
+		router.ServeHTTP(w, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	t.Run("triangulates through the US dollar", func(t *testing.T) {
+		code, body := convert("?amount=120&from=EUR&to=canada&date=2024-04-15")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(120), body["amount"])
+		assert.Equal(t, float64(180), body["converted_amount"])
+		assert.Equal(t, 1.5, body["exchange_rate"])
+
+		legs := body["legs"].([]any)
+		require.Len(t, legs, 2)
+		assert.Equal(t, 133.33, legs[0].(map[string]any)["amount"])
+		assert.Equal(t, "USD", legs[0].(map[string]any)["to"])
+		assert.Equal(t, 1.35, legs[1].(map[string]any)["exchange_rate"])
+	})
+
+	t.Run("converts to US dollars", func(t *testing.T) {
+		code, body := convert("?amount=90&from=EUR&to=USD&date=2024-04-15")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(100), body["converted_amount"])
+	})
+
+	t.Run("no rate", func(t *testing.T) {
+		code, body := convert("?amount=120&from=EUR&to=MXN&date=2024-04-15")
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, "the amount cannot be converted to the target currency", body["detail"])
+	})
+
+	t.Run("refuses amounts more precise than the currency", func(t *testing.T) {
+		code, body := convert("?amount=120.005&from=EUR&to=CAD&date=2024-04-15")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, []any{map[string]any{"field": "amount", "code": "too_many_decimals", "message": "amount must have at most 2 decimals", "rejected_value": "120.005"}}, body["errors"])
+
+		code, body = convert("?amount=1.5&from=JPY&to=EUR&date=2024-04-15")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "amount must have at most 0 decimals", body["errors"].([]any)[0].(map[string]any)["message"])
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			"?amount=120&to=CAD&date=2024-04-15":                    "from is required",
+			"?amount=120&from=EUR&date=2024-04-15":                  "to is required",
+			"?amount=-1&from=EUR&to=CAD&date=2024-04-15":            "amount must be a positive number",
+			"?amount=ten&from=EUR&to=CAD&date=2024-04-15":           "amount must be a positive number",
+			"?amount=120&from=EUR&to=CAD&date=15/04/2024":           "date must be in YYYY-MM-DD format",
+			"?amount=120&from=EUR&to=CAD&date=2024-04-15&lookback=": "lookback must be a number of years, months and days such as 6m, 90d or 1y2m",
+		} {
+			code, body := convert(query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+			assert.Equal(t, expected, body["detail"], query)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// maxConversionCountries is the largest number of countries accepted by ConvertTransactionCountriesHandler.
+const maxConversionCountries = 25
+
+// Conversion entry statuses reported by ConvertTransactionCountriesHandler.
+const (
+	conversionEntryConverted = "converted"
+	conversionEntryFailed    = "failed"
+)
+
+// ConvertTransactionCountriesHandler handles GET /transactions/:id/conversions?countries=<countries>.
+// It converts the transaction amount into the currencies of several countries at
+// once, given as a comma separated list of country names matched like in
+// RetrievePurchaseTransactionHandler (e.g. countries=Canada,Mexico,Euro Zone).
+// The exchange rates are looked up concurrently, at most conversion.max_concurrency
+// at a time, and every conversion is recorded in the audit trail. The conversion
+// policy query parameters of ConvertTransactionHandler apply to every country.
+//
+// If the countries are missing or more than 25, it will return 400 with the error message.
+// If a policy parameter is invalid, it will return 400 with the error message.
+// If the transaction does not exist, it will return 404 with the error message.
+// Otherwise the response lists in "results", in the order of the request, the
+// status of each country: converted, with the fields of a single conversion, or
+// failed, with the error message, the status code ("code") and the problem type
+// ("type") the single conversion would have returned. It will return 200 when every country was
+// converted and 207 when some failed.
+func ConvertTransactionCountriesHandler(repo repository.TransactionRepository, provider service.ExchangeRateProvider, defaultPolicy service.ConversionPolicy, rounding util.RoundingMode, conversions repository.ConversionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		countries, errMsg := parseCountries(c.Query("countries"))
+		if errMsg != "" {
+			util.InfoLogger.Println(fmt.Sprintf("conversion refused. StatusCode %d:", http.StatusBadRequest), errMsg)
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, errMsg)
+			return
+		}
+
+		policy, ok := conversionPolicy(c, defaultPolicy)
+		if !ok {
+			return
+		}
+
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		results := make([]gin.H, len(countries))
+		limit := make(chan struct{}, max(config.AppConfig.Conversion.MaxConcurrency, 1))
+		var wg sync.WaitGroup
+		for i, country := range countries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				limit <- struct{}{}
+				defer func() { <-limit }()
+
+				results[i] = convertEntry(c.Request.Context(), provider, rounding, conversions, policy, transaction, country)
+			}()
+		}
+		wg.Wait()
+
+		converted := 0
+		for _, result := range results {
+			if result["status"] == conversionEntryConverted {
+				converted++
+			}
+		}
+
+		status := http.StatusOK
+		if converted < len(results) {
+			status = http.StatusMultiStatus
+		}
+
+		util.InfoLogger.Printf("transaction %d converted into %d of %d currencies", transaction.ID, converted, len(results))
+		c.JSON(status, gin.H{
+			"id":               transaction.ID,
+			"description":      transaction.Description,
+			"transaction_date": transaction.TransactionDate,
+			"usd_amount":       transaction.Amount,
+			"policy":           policy.Model(),
+			"converted":        converted,
+			"failed":           len(results) - converted,
+			"results":          results,
+		})
+	}
+}
+
+// convertEntry converts the transaction into the currency of the country and
+// returns the result entry of ConvertTransactionCountriesHandler.
+func convertEntry(ctx context.Context, provider service.ExchangeRateProvider, rounding util.RoundingMode, conversions repository.ConversionRepository, policy service.ConversionPolicy, transaction *model.Transaction, country string) gin.H {
+	// Country names are matched ignoring case; countries missing from the
+	// currency catalogue are refused, as their minor unit is unknown.
+	currency, ok := util.LookupCurrency(country)
+	if !ok {
+		return gin.H{
+			"country": country,
+			"status":  conversionEntryFailed,
+			"code":    http.StatusBadRequest,
+			"type":    problemTypeBase + problemUnknownCurrency.slug,
+			"error":   unknownCurrencyMessage(country),
+		}
+	}
+	country = currency.Country
+
+	conversion, failure := convertAmount(ctx, provider, rounding, conversions, policy, transaction, country, currency.Code)
+	if failure != nil {
+		return gin.H{
+			"country": country,
+			"status":  conversionEntryFailed,
+			"code":    failure.status,
+			"type":    problemTypeBase + failure.kind.slug,
+			"error":   failure.message,
+		}
+	}
+
+	entry := conversionFields(conversion)
+	entry["country"] = country
+	entry["status"] = conversionEntryConverted
+	return entry
+}
+
+// parseCountries splits the comma separated countries, ignoring blank and
+// repeated names. It returns a non-empty error message when the list is empty
+// or too long.
+func parseCountries(value string) ([]string, string) {
+	var countries []string
+	seen := map[string]bool{}
+	for _, country := range strings.Split(value, ",") {
+		country = strings.TrimSpace(country)
+		key := strings.ToLower(country)
+		if currency, ok := util.LookupCurrency(country); ok {
+			key = currency.Code
+		}
+		if country == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		countries = append(countries, country)
+	}
+
+	if len(countries) == 0 {
+		return nil, "countries is required"
+	}
+	if len(countries) > maxConversionCountries {
+		return nil, fmt.Sprintf("countries must list at most %d countries", maxConversionCountries)
+	}
+	return countries, ""
+}
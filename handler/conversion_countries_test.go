@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// parallelProvider returns the rate of the countries it knows after a short
+// delay and records the largest number of concurrent lookups.
+type parallelProvider struct {
+	rates    map[string]service.Rate
+	inflight atomic.Int32
+	peak     atomic.Int32
+	calls    atomic.Int32
+}
+
+func (p *parallelProvider) RateOn(_ context.Context, country string, _ time.Time, _ service.Lookback) (service.Rate, error) {
+	p.calls.Add(1)
+	current := p.inflight.Add(1)
+	defer p.inflight.Add(-1)
+	for peak := p.peak.Load(); current > peak && !p.peak.CompareAndSwap(peak, current); peak = p.peak.Load() {
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	rate, ok := p.rates[country]
+	if !ok {
+		return service.Rate{}, service.ErrNoRate
+	}
+	return rate, nil
+}
+
+func TestConvertTransactionCountriesHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	provider := &parallelProvider{rates: map[string]service.Rate{
+		"Canada":    {Country: "Canada", Currency: "Dollar", ExchangeRate: 1.35, EffectiveDate: "2019-12-31", Source: service.TreasurySource},
+		"Mexico":    {Country: "Mexico", Currency: "Peso", ExchangeRate: 18.9, EffectiveDate: "2019-12-31", Source: service.TreasurySource},
+		"Euro Zone": {Country: "Euro Zone", Currency: "Euro", ExchangeRate: 0.9, EffectiveDate: "2019-12-31", Source: service.TreasurySource},
+		"Japan":     {Country: "Japan", Currency: "Yen", ExchangeRate: 108.7, EffectiveDate: "2019-12-31", Source: service.TreasurySource},
+	}}
+
+	conversions := repository.NewInMemoryConversionRepository()
+	router := gin.New()
+	router.GET("/transactions/:id/conversions", ConvertTransactionCountriesHandler(newRepositoryWithTransaction(t), provider, service.DefaultConversionPolicy, util.RoundHalfUp, conversions))
+
+	convert := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/conversions"+query, nil)
+		router.ServeHTTP(w, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	t.Run("converts into every currency", func(t *testing.T) {
+		code, body := convert("?countries=Canada,mexico,Euro%20Zone,%20japan")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(4), body["converted"])
+		assert.Equal(t, float64(0), body["failed"])
+
+		results := body["results"].([]any)
+		require.Len(t, results, 4)
+		for i, expected := range []struct {
+			country  string
+			currency string
+			amount   float64
+		}{
+			{"Canada", "CAD", 16.66},
+			{"Mexico", "MXN", 233.23},
+			{"Euro Zone", "EUR", 11.11},
+			{"Japan", "JPY", 1341},
+		} {
+			result := results[i].(map[string]any)
+			assert.Equal(t, "converted", result["status"], expected.country)
+			assert.Equal(t, expected.country, result["country"])
+			assert.Equal(t, expected.currency, result["currency"])
+			assert.Equal(t, expected.amount, result["converted_amount"], expected.country)
+		}
+
+		history, err := conversions.ListByTransaction(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Len(t, history, 4)
+	})
+
+	t.Run("reports failures per country", func(t *testing.T) {
+		code, body := convert("?countries=Canada,Brazil,Atlantis")
+		assert.Equal(t, http.StatusMultiStatus, code)
+		assert.Equal(t, float64(1), body["converted"])
+		assert.Equal(t, float64(2), body["failed"])
+		assert.Equal(t, map[string]any{
+			"country": "Brazil",
+			"status":  "failed",
+			"code":    float64(http.StatusNotFound),
+			"type":    "/problems/no-exchange-rate",
+			"error":   "the purchase cannot be converted to the target currency",
+		}, body["results"].([]any)[1])
+		// Countries missing from the catalogue have no known minor unit
+		assert.Equal(t, map[string]any{
+			"country": "Atlantis",
+			"status":  "failed",
+			"code":    float64(http.StatusBadRequest),
+			"type":    "/problems/unknown-currency",
+			"error":   `unknown currency "Atlantis"; use an ISO 4217 code such as EUR or a Treasury country name`,
+		}, body["results"].([]any)[2])
+	})
+
+	t.Run("bounds the lookups in parallel", func(t *testing.T) {
+		config.AppConfig.Conversion.MaxConcurrency = 2
+		defer func() { config.AppConfig.Conversion.MaxConcurrency = 4 }()
+		provider.peak.Store(0)
+
+		code, _ := convert("?countries=Canada,Mexico,Euro%20Zone,Japan")
+		assert.Equal(t, http.StatusOK, code)
+		assert.LessOrEqual(t, provider.peak.Load(), int32(2))
+	})
+
+	t.Run("ignores repeated countries", func(t *testing.T) {
+		calls := provider.calls.Load()
+		_, body := convert("?countries=Canada,,CANADA,CAD")
+		assert.Len(t, body["results"], 1)
+		assert.Equal(t, calls+1, provider.calls.Load())
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		code, body := convert("?countries=,%20")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "countries is required", body["detail"])
+
+		code, body = convert("?countries=Canada&lookback=soon")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Contains(t, body["detail"], "lookback must be")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/2/conversions?countries=Canada", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
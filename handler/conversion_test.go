@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// stubProvider returns the rate of the countries it knows and records the countries asked for.
+type stubProvider struct {
+	rates     map[string]service.Rate
+	countries []string
+}
+
+func (p *stubProvider) RateOn(_ context.Context, country string, _ time.Time, _ service.Lookback) (service.Rate, error) {
+	p.countries = append(p.countries, country)
+	rate, ok := p.rates[country]
+	if !ok {
+		return service.Rate{}, service.ErrNoRate
+	}
+	return rate, nil
+}
+
+func TestConvertTransactionHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	provider := &stubProvider{rates: map[string]service.Rate{
+		"Euro Zone": {Country: "Euro Zone", Currency: "Euro", ExchangeRate: 0.9, EffectiveDate: "2019-12-31", RecordDate: "2019-12-31", Source: service.TreasurySource},
+		"Japan":     {Country: "Japan", Currency: "Yen", ExchangeRate: 108.7, EffectiveDate: "2019-09-30", RecordDate: "2019-09-30", Source: service.TreasurySource},
+	}}
+
+	repo := newRepositoryWithTransaction(t)
+	conversions := repository.NewInMemoryConversionRepository()
+	router := gin.New()
+	router.GET("/transactions/:id/convert", ConvertTransactionHandler(repo, provider, service.DefaultConversionPolicy, util.RoundHalfUp, conversions))
+	router.GET("/transactions/:id/conversion-history", ConversionHistoryHandler(repo, conversions))
+
+	convert := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/convert"+query, nil)
+		router.ServeHTTP(w, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	t.Run("converts by ISO code", func(t *testing.T) {
+		code, body := convert("?currency=EUR")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]any{
+			"id":                   float64(1),
+			"description":          "test",
+			"transaction_date":     "2020-01-01",
+			"usd_amount":           12.34,
+			"currency":             "EUR",
+			"scale":                float64(2),
+			"country":              "Euro Zone",
+			"exchange_rate":        0.9,
+			"converted_amount":     11.11,
+			"conversion_id":        float64(1),
+			"rate_source":          "treasury",
+			"rate_currency":        "Euro",
+			"rate_effective_date":  "2019-12-31",
+			"rate_record_date":     "2019-12-31",
+			"days_before_purchase": float64(1),
+			"policy": map[string]any{
+				"lookback":           "6m",
+				"allow_later_rates":  false,
+				"exact_date":         false,
+				"fallback_to_latest": false,
+			},
+			"rate_match": "lookback",
+		}, body)
+	})
+
+	t.Run("rounds to the minor unit of the currency", func(t *testing.T) {
+		code, body := convert("?currency=JPY")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(1341), body["converted_amount"]) // 12.34 * 108.7 = 1341.358
+		assert.Equal(t, float64(0), body["scale"])
+
+		down := gin.New()
+		down.GET("/transactions/:id/convert", ConvertTransactionHandler(repo, provider, service.DefaultConversionPolicy, util.RoundDown, repository.NewInMemoryConversionRepository()))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/convert?currency=EUR", nil)
+		down.ServeHTTP(w, req)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, 11.1, body["converted_amount"]) // 12.34 * 0.9 = 11.106
+	})
+
+	t.Run("matches names ignoring case", func(t *testing.T) {
+		for _, currency := range []string{"jpy", "japan", "JAPAN-YEN"} {
+			code, body := convert("?currency=" + currency)
+			assert.Equal(t, http.StatusOK, code, currency)
+			assert.Equal(t, "JPY", body["currency"], currency)
+		}
+	})
+
+	t.Run("records the conversions", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/conversion-history", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var body struct {
+			Data []model.Conversion `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		require.NotEmpty(t, body.Data)
+		assert.Equal(t, "EUR", body.Data[0].Currency)
+		assert.Equal(t, 0.9, body.Data[0].ExchangeRate)
+		assert.Equal(t, "2019-12-31", body.Data[0].RateEffectiveDate)
+		assert.Equal(t, "half_up", body.Data[0].Rounding)
+		assert.Equal(t, model.ConversionPolicy{Lookback: "6m"}, body.Data[0].Policy)
+		assert.Equal(t, "lookback", body.Data[0].RateMatch)
+	})
+
+	t.Run("converts to the US dollar at rate 1", func(t *testing.T) {
+		code, body := convert("?currency=USD")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 12.34, body["converted_amount"])
+		assert.Equal(t, float64(1), body["exchange_rate"])
+		assert.Equal(t, "identity", body["rate_match"])
+	})
+
+	t.Run("applies the policy of the request", func(t *testing.T) {
+		code, body := convert("?currency=EUR&lookback=1y2m&fallback_to_latest=true")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]any{
+			"lookback":           "14m",
+			"allow_later_rates":  false,
+			"exact_date":         false,
+			"fallback_to_latest": true,
+		}, body["policy"])
+
+		laterRates := gin.New()
+		laterRates.GET("/transactions/:id/convert", ConvertTransactionHandler(repo, provider, service.ConversionPolicy{Lookback: service.DefaultLookback, AllowLaterRates: true}, util.RoundHalfUp, repository.NewInMemoryConversionRepository()))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/convert?currency=EUR&exact_date=true", nil)
+		laterRates.ServeHTTP(w, req)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "exact_date", body["rate_match"])
+		assert.Equal(t, map[string]any{
+			"lookback":           "6m",
+			"allow_later_rates":  false,
+			"exact_date":         true,
+			"fallback_to_latest": false,
+		}, body["policy"])
+	})
+
+	t.Run("rejects invalid policies", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			"&lookback=6w":      "lookback must be a number of years, months and days such as 6m, 90d or 1y2m",
+			"&exact_date=maybe": "exact_date must be true or false",
+			"&exact_date=true&allow_later_rates=true": "exact_date cannot be combined with allow_later_rates or fallback_to_latest",
+			"&fallback_to_latest=1&exact_date=t":      "exact_date cannot be combined with allow_later_rates or fallback_to_latest",
+		} {
+			code, body := convert("?currency=EUR" + query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+			assert.Equal(t, expected, body["detail"], query)
+		}
+	})
+
+	t.Run("suggests close matches", func(t *testing.T) {
+		code, body := convert("?currency=Japn")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "/problems/unknown-currency", body["type"])
+		assert.Equal(t, []any{"JPY (Japan)"}, body["suggestions"])
+		assert.Contains(t, body["detail"], `unknown currency "Japn"`)
+	})
+
+	t.Run("requires a currency", func(t *testing.T) {
+		code, body := convert("")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "currency is required", body["detail"])
+	})
+
+	t.Run("no rate", func(t *testing.T) {
+		code, body := convert("?currency=CAD")
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, "the purchase cannot be converted to the target currency", body["detail"])
+	})
+}
+
+func TestRetrievePurchaseTransactionHandlerMatchesCountryIgnoringCase(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	provider := &stubProvider{rates: map[string]service.Rate{
+		"Euro Zone": {Country: "Euro Zone", Currency: "Euro", ExchangeRate: 0.9, EffectiveDate: "2019-12-31"},
+	}}
+
+	router := gin.New()
+	router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(newRepositoryWithTransaction(t), provider, service.DefaultConversionPolicy, util.RoundHalfUp, repository.NewInMemoryConversionRepository()))
+
+	for _, country := range []string{"euro%20zone", "Euro%20Zone"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/exchange-rate/"+country, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, country)
+	}
+	assert.Equal(t, []string{"Euro Zone", "Euro Zone"}, provider.countries)
+
+	// Countries missing from the catalogue are refused, as their minor unit is unknown
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/transactions/1/exchange-rate/Uganda", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "/problems/unknown-currency", body["type"])
+	assert.Equal(t, []string{"Euro Zone", "Euro Zone"}, provider.countries)
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+// Body limits used when requests.max_body_bytes and requests.max_batch_body_bytes are not set.
+const (
+	defaultMaxBodyBytes      = 64 << 10
+	defaultMaxBatchBodyBytes = 16 << 20
+)
+
+// errNotJSONObject is returned by decodeJSON when the body is not a single JSON object.
+var errNotJSONObject = errors.New("body must be a single JSON object")
+
+// limitBody refuses to read more than limit bytes of the request body, or
+// fallback bytes when limit is not positive.
+func limitBody(c *gin.Context, limit int64, fallback int64) {
+	if limit <= 0 {
+		limit = fallback
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+}
+
+// bindJSON decodes the JSON object of the request body into the struct v with
+// decodeJSON. When the body cannot be read or decoded it writes the error
+// response and returns false.
+func bindJSON(c *gin.Context, v any) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		err = decodeJSON(body, v, config.AppConfig.Requests.LenientJSON)
+	}
+	if err != nil {
+		util.InfoLogger.Println(fmt.Sprintf("request body refused. StatusCode %d:", bodyProblemStatus(err)), err)
+		writeBodyProblem(c, err)
+		return false
+	}
+	return true
+}
+
+// bodyProblemStatus returns the status code of the response to a body that
+// could not be read or decoded: 413 when it is too large, 400 otherwise.
+func bodyProblemStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// writeBodyProblem sends the problem of a request body that could not be read
+// or decoded: the invalid fields for model.ValidationErrors, 413 when the body is
+// too large, or the error message.
+func writeBodyProblem(c *gin.Context, err error) {
+	var errs model.ValidationErrors
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &errs):
+		writeValidationProblem(c, errs)
+	case errors.As(err, &tooLarge):
+		writeProblem(c, http.StatusRequestEntityTooLarge, problemBodyTooLarge, fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+	default:
+		writeProblem(c, http.StatusBadRequest, problemInvalidRequest, err.Error())
+	}
+}
+
+// decodeJSON decodes the JSON object in data into the struct v. Unlike
+// json.Unmarshal it refuses unknown fields and repeated keys, unless lenient
+// (when fields match ignoring case and the last repeated key wins), and it
+// decodes every field before failing, so that model.ValidationErrors lists all
+// the fields refused. Other errors mean data is not a single JSON object.
+func decodeJSON(data []byte, v any, lenient bool) error {
+	fields := jsonFields(reflect.ValueOf(v).Elem())
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return errNotJSONObject
+	}
+
+	var errs model.ValidationErrors
+	seen := map[string]bool{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("invalid JSON body: %w", err)
+		}
+		key := token.(string)
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("invalid JSON body: %w", err)
+		}
+
+		field, known := fields[key]
+		if !known && lenient {
+			field, known = lookupFold(fields, key)
+		}
+		switch {
+		case seen[key] && !lenient:
+			errs = append(errs, newFieldError(key, model.CodeDuplicateKey, key+" must appear only once", raw))
+		case !known && !lenient:
+			errs = append(errs, newFieldError(key, model.CodeUnknownField, key+" is not a known field", raw))
+		case known:
+			if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+				errs = append(errs, decodeFieldError(key, raw, err))
+			}
+		}
+		seen[key] = true
+	}
+
+	// The closing brace must end the data.
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errNotJSONObject
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// jsonFields returns the exported fields of a struct by JSON name.
+func jsonFields(v reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+// lookupFold returns the field whose JSON name matches key ignoring case, like json.Unmarshal does.
+func lookupFold(fields map[string]reflect.Value, key string) (reflect.Value, bool) {
+	for name, field := range fields {
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// decodeFieldError describes why the raw value of a field could not be decoded.
+func decodeFieldError(field string, raw json.RawMessage, err error) model.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, util.ErrTooManyDecimals):
+		return newFieldError(field, model.CodeTooManyDecimals, fmt.Sprintf("%s must have at most %d decimals", field, util.USD(0).Scale()), raw)
+	case errors.Is(err, util.ErrNotFinite):
+		return newFieldError(field, model.CodeNotFinite, field+" must be a finite number", raw)
+	case errors.Is(err, util.ErrInvalidAmount):
+		return newFieldError(field, model.CodeInvalidFormat, field+" must be a number", raw)
+	case errors.As(err, &typeErr):
+		return newFieldError(field, model.CodeInvalidType, field+" must be a JSON "+typeErr.Type.Kind().String(), raw)
+	default:
+		return newFieldError(field, model.CodeInvalidFormat, field+" is invalid: "+err.Error(), raw)
+	}
+}
+
+// newFieldError returns a field error rejecting the raw JSON value.
+func newFieldError(field string, code string, message string, raw json.RawMessage) model.FieldError {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		value = string(raw)
+	}
+	return model.FieldError{Field: field, Code: code, Message: message, RejectedValue: value}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		lenient        bool
+		expectedResult model.Transaction
+		expectedErrors model.ValidationErrors
+		expectedError  error
+	}{
+		{
+			name:           "Known fields",
+			body:           `{"description": "Lunch", "amount": 12.5, "transaction_date": "2024-04-15"}`,
+			expectedResult: model.Transaction{Description: "Lunch", Amount: util.USD(1250), TransactionDate: "2024-04-15"},
+		},
+		{
+			name: "Unknown field",
+			body: `{"description": "Lunch", "ammount": 10}`,
+			expectedErrors: model.ValidationErrors{
+				{Field: "ammount", Code: model.CodeUnknownField, Message: "ammount is not a known field", RejectedValue: float64(10)},
+			},
+		},
+		{
+			name: "Duplicate key",
+			body: `{"amount": 10, "amount": 20}`,
+			expectedErrors: model.ValidationErrors{
+				{Field: "amount", Code: model.CodeDuplicateKey, Message: "amount must appear only once", RejectedValue: float64(20)},
+			},
+		},
+		{
+			name: "Too many decimals",
+			body: `{"amount": 10.005}`,
+			expectedErrors: model.ValidationErrors{
+				{Field: "amount", Code: model.CodeTooManyDecimals, Message: "amount must have at most 2 decimals", RejectedValue: 10.005},
+			},
+		},
+		{
+			name: "Not finite",
+			body: `{"amount": "Infinity", "description": 1e999}`,
+			expectedErrors: model.ValidationErrors{
+				{Field: "amount", Code: model.CodeNotFinite, Message: "amount must be a finite number", RejectedValue: "Infinity"},
+				{Field: "description", Code: model.CodeInvalidType, Message: "description must be a JSON string", RejectedValue: "1e999"},
+			},
+		},
+		{
+			name:           "Lenient ignores unknown fields and keeps the last key",
+			body:           `{"Description": "Lunch", "amount": 10, "amount": 20, "ammount": 30}`,
+			lenient:        true,
+			expectedResult: model.Transaction{Description: "Lunch", Amount: util.USD(2000)},
+		},
+		{
+			name:          "Not an object",
+			body:          `[{"amount": 10}]`,
+			expectedError: errNotJSONObject,
+		},
+		{
+			name:          "Trailing data",
+			body:          `{"amount": 10} {"amount": 20}`,
+			expectedError: errNotJSONObject,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transaction model.Transaction
+			err := decodeJSON([]byte(tt.body), &transaction, tt.lenient)
+			switch {
+			case tt.expectedErrors != nil:
+				assert.Equal(t, tt.expectedErrors, err)
+			case tt.expectedError != nil:
+				assert.ErrorIs(t, err, tt.expectedError)
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResult, transaction)
+			}
+		})
+	}
+}
+
+func TestStoreTransactionHandlerBodyLimit(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	config.AppConfig.Requests.MaxBodyBytes = 100
+
+	router := gin.New()
+	router.POST("/transactions", StoreTransactionHandler(repository.NewInMemoryTransactionRepository(), repository.NewInMemoryCategoryRepository(), repository.NewInMemoryIdempotencyRepository()))
+
+	for _, key := range []string{"", "large-1"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/transactions", strings.NewReader(`{"description": "`+strings.Repeat("a", 100)+`", "amount": 1, "transaction_date": "2024-04-15"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, key)
+		assert.Contains(t, w.Body.String(), `"type":"/problems/body-too-large"`, key)
+		assert.Contains(t, w.Body.String(), `"detail":"request body must be at most 100 bytes"`, key)
+	}
+}
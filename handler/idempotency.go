@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// Idempotency headers: the key sent by clients and the marker of replayed responses.
+const (
+	idempotencyKeyHeader       = "Idempotency-Key"
+	idempotentReplayedHeader   = "Idempotent-Replayed"
+	maxIdempotencyKeyLength    = 255
+	defaultIdempotencyKeyTTL   = 24 * time.Hour
+	defaultIdempotencyLease    = time.Minute
+	idempotencyCompleteRetries = 3
+	idempotencyTimestampFormat = "2006-01-02T15:04:05Z"
+)
+
+// claimIdempotencyKey claims the Idempotency-Key of the request, if any, and
+// returns the claim (nil without the header), whose token completes or releases
+// the key. The request body is read to identify the request and restored for
+// the handler.
+//
+// When the key has already been used it answers the request and returns false:
+// with the stored response for an identical request (marked with the
+// Idempotent-Replayed header), 409 while the first request is being processed
+// (within its lease, idempotency.lease), or 422 for a different request.
+func claimIdempotencyKey(c *gin.Context, keys repository.IdempotencyRepository) (*model.IdempotencyKey, bool) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return nil, true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", http.StatusBadRequest), "idempotency key too long")
+		writeProblem(c, http.StatusBadRequest, problemInvalidRequest, fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+		return nil, false
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", bodyProblemStatus(err)), err)
+		writeBodyProblem(c, fmt.Errorf("failed to read request body: %w", err))
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	ttl := config.AppConfig.Idempotency.TTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyKeyTTL
+	}
+	lease := config.AppConfig.Idempotency.Lease
+	if lease <= 0 {
+		lease = defaultIdempotencyLease
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		util.ErrorLogger.Println("failed to claim idempotency key:", err)
+		writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transaction")
+		return nil, false
+	}
+	now := time.Now().UTC()
+	hash := sha256.Sum256([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n" + string(body)))
+	claim := &model.IdempotencyKey{
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash[:]),
+		CreatedAt:   now.Format(idempotencyTimestampFormat),
+		ExpiresAt:   now.Add(ttl).Format(idempotencyTimestampFormat),
+		LockedUntil: now.Add(lease).Format(idempotencyTimestampFormat),
+		Token:       hex.EncodeToString(token),
+	}
+
+	existing, err := keys.Claim(c.Request.Context(), claim)
+	if err != nil {
+		util.ErrorLogger.Println("failed to claim idempotency key:", err)
+		writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transaction")
+		return nil, false
+	}
+
+	switch {
+	case existing == nil:
+		return claim, true
+	case existing.RequestHash != claim.RequestHash:
+		util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", http.StatusUnprocessableEntity), "idempotency key reused with a different request", key)
+		writeProblem(c, http.StatusUnprocessableEntity, problemIdempotencyKeyReused, idempotencyKeyHeader+" has already been used with a different request")
+	case !existing.Completed():
+		util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", http.StatusConflict), "idempotency key in use", key)
+		if lockedUntil, err := time.Parse(idempotencyTimestampFormat, existing.LockedUntil); err == nil {
+			c.Header("Retry-After", strconv.Itoa(int(lockedUntil.Sub(now).Seconds())+1))
+		}
+		writeProblem(c, http.StatusConflict, problemIdempotencyKeyInUse, "a request with the same "+idempotencyKeyHeader+" is being processed, retry later")
+	default:
+		util.InfoLogger.Println("replaying response of idempotency key", key)
+		if existing.ETag != "" {
+			c.Header("ETag", existing.ETag)
+		}
+		c.Header(idempotentReplayedHeader, "true")
+		c.Data(existing.StatusCode, "application/json; charset=utf-8", existing.ResponseBody)
+	}
+	return nil, false
+}
+
+// completeIdempotencyKey stores the response of a claimed key, retrying a few
+// times: a key left without its response would answer retries with 409 until
+// its lease lapses, and then let them store the transaction again. It gives up
+// with ErrClaimLost when the key has been taken over by another request.
+// ctx must not be the request context, so that the response is stored even
+// when the client has gone away.
+func completeIdempotencyKey(ctx context.Context, keys repository.IdempotencyRepository, claim *model.IdempotencyKey, statusCode int, body []byte, etag string) error {
+	var err error
+	for attempt := 0; attempt < idempotencyCompleteRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+		}
+		if err = keys.Complete(ctx, claim.Key, claim.Token, statusCode, body, etag); err == nil || errors.Is(err, repository.ErrClaimLost) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestStoreTransactionHandlerIdempotencyKey(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	repo := repository.NewInMemoryTransactionRepository()
+	keys := repository.NewInMemoryIdempotencyRepository()
+	router := gin.New()
+	router.POST("/transactions", StoreTransactionHandler(repo, repository.NewInMemoryCategoryRepository(), keys))
+
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/transactions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	count := func() int {
+		page, err := repo.List(context.Background(), repository.ListOptions{})
+		require.NoError(t, err)
+		return len(page.Transactions)
+	}
+
+	body := `{"description": "Hotel", "amount": 120, "transaction_date": "2024-04-15"}`
+
+	t.Run("replays the response of retries", func(t *testing.T) {
+		first := post("retry-1", body)
+		assert.Equal(t, http.StatusCreated, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		retry := post("retry-1", body)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+		assert.JSONEq(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, 1, count())
+	})
+
+	t.Run("refuses a key used with a different body", func(t *testing.T) {
+		w := post("retry-1", `{"description": "Flight", "amount": 120, "transaction_date": "2024-04-15"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.JSONEq(t, `{"type": "/problems/idempotency-key-reused", "title": "The idempotency key was used with a different request", "status": 422, "detail": "Idempotency-Key has already been used with a different request", "instance": "/transactions"}`, w.Body.String())
+		assert.Equal(t, 1, count())
+	})
+
+	t.Run("refuses a key being processed", func(t *testing.T) {
+		hash := sha256.Sum256([]byte("POST /transactions\n" + body))
+		_, err := keys.Claim(context.Background(), &model.IdempotencyKey{Key: "pending", RequestHash: hex.EncodeToString(hash[:]), CreatedAt: "2000-01-01T00:00:00Z", ExpiresAt: "9999-01-01T00:00:00Z", LockedUntil: "9999-01-01T00:00:00Z"})
+		require.NoError(t, err)
+
+		w := post("pending", body)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		assert.Equal(t, 1, count())
+	})
+
+	t.Run("takes over a key abandoned past its lease", func(t *testing.T) {
+		hash := sha256.Sum256([]byte("POST /transactions\n" + body))
+		_, err := keys.Claim(context.Background(), &model.IdempotencyKey{Key: "abandoned", RequestHash: hex.EncodeToString(hash[:]), CreatedAt: "2000-01-01T00:00:00Z", ExpiresAt: "9999-01-01T00:00:00Z", LockedUntil: "2000-01-01T00:01:00Z"})
+		require.NoError(t, err)
+
+		w := post("abandoned", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, 2, count())
+
+		retry := post("abandoned", body)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 2, count())
+	})
+
+	t.Run("releases the key of failed requests", func(t *testing.T) {
+		w := post("retry-2", `{"description": "Hotel", "amount": -1, "transaction_date": "2024-04-15"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = post("retry-2", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, 3, count())
+	})
+
+	t.Run("stores every request without a key", func(t *testing.T) {
+		post("", body)
+		post("", body)
+		assert.Equal(t, 5, count())
+	})
+
+	t.Run("refuses overlong keys", func(t *testing.T) {
+		w := post(strings.Repeat("k", 256), body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
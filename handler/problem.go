@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+// problemContentType is the media type of RFC 7807 error responses.
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the type URI of every problem. Type URIs are stable
+// identifiers clients may match on; they are relative to the API.
+const problemTypeBase = "/problems/"
+
+// problemType is a kind of error response: the last segment of its type URI and
+// its human-readable summary.
+type problemType struct {
+	slug  string
+	title string
+}
+
+// Problem types returned by the handlers.
+var (
+	problemInvalidRequest       = problemType{"invalid-request", "The request is invalid"}
+	problemBodyTooLarge         = problemType{"body-too-large", "The request body is too large"}
+	problemValidation           = problemType{"validation-error", "The request has invalid fields"}
+	problemNotFound             = problemType{"not-found", "The resource was not found"}
+	problemUnknownCurrency      = problemType{"unknown-currency", "The currency is unknown"}
+	problemNoExchangeRate       = problemType{"no-exchange-rate", "No exchange rate is available"}
+	problemRatesUnavailable     = problemType{"rates-unavailable", "Exchange rates are unavailable"}
+	problemPreconditionRequired = problemType{"precondition-required", "The request must be conditional"}
+	problemPreconditionFailed   = problemType{"precondition-failed", "The precondition failed"}
+	problemVersionConflict      = problemType{"version-conflict", "The resource was modified"}
+	problemIdempotencyKeyReused = problemType{"idempotency-key-reused", "The idempotency key was used with a different request"}
+	problemIdempotencyKeyInUse  = problemType{"idempotency-key-in-use", "The idempotency key is in use"}
+	problemStatementTooLarge    = problemType{"statement-too-large", "The statement is too large"}
+	problemCategoryExists       = problemType{"category-exists", "The category already exists"}
+	problemRefundExceedsAmount  = problemType{"refund-exceeds-amount", "The refunds would exceed the transaction amount"}
+	problemInternal             = problemType{"internal-error", "The server failed to process the request"}
+)
+
+// problem is an error response in the RFC 7807 application/problem+json format.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the invalid fields of validation-error problems.
+	Errors model.ValidationErrors `json:"errors,omitempty"`
+	// Suggestions lists the close matches of unknown-currency problems.
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// newProblem returns a problem of the given type for the request.
+func newProblem(c *gin.Context, status int, kind problemType, detail string) *problem {
+	return &problem{
+		Type:     problemTypeBase + kind.slug,
+		Title:    kind.title,
+		Status:   status,
+		Detail:   detail,
+		Instance: c.Request.URL.Path,
+	}
+}
+
+// write sends the problem as the response.
+func (p *problem) write(c *gin.Context) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		util.ErrorLogger.Println("failed to encode problem:", err)
+		body = []byte(`{"type":"` + problemTypeBase + problemInternal.slug + `","title":"` + problemInternal.title + `","status":500}`)
+	}
+	c.Data(p.Status, problemContentType, body)
+}
+
+// writeProblem sends a problem of the given type as the response.
+func writeProblem(c *gin.Context, status int, kind problemType, detail string) {
+	newProblem(c, status, kind, detail).write(c)
+}
+
+// writeValidationProblem sends a 400 validation-error problem listing the invalid fields.
+func writeValidationProblem(c *gin.Context, errs model.ValidationErrors) {
+	p := newProblem(c, http.StatusBadRequest, problemValidation, errs.Error())
+	p.Errors = errs
+	p.write(c)
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// Dates of the exchange rate refunds are converted at (refunds.rate_date).
+const (
+	rateDatePurchase = "purchase"
+	rateDateRefund   = "refund"
+)
+
+// CreateRefundHandler handles POST /transactions/:id/refunds.
+// It records money returned for the transaction. The expected body is a JSON object with fields:
+// - kind: refund (default) or reversal
+// - amount: number with up to two decimals; optional for a reversal, which defaults to what is left to refund
+// - refund_date: string in YYYY-MM-DD format, not before the transaction date
+// - reason: optional string of up to 200 characters
+//
+// If the request body is invalid, it will return 400 with the error message, or every field refused.
+// If the body is larger than requests.max_body_bytes, it will return 413.
+// If the refund is invalid, it will return 400 with every invalid field.
+// If the transaction does not exist, it will return 404.
+// If the refunds of the transaction would total more than its amount, it will return 422 with the amount left to refund.
+// If the refund is successfully stored, it will return 201 with the stored refund.
+func CreateRefundHandler(repo repository.TransactionRepository, refunds repository.RefundRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limitBody(c, config.AppConfig.Requests.MaxBodyBytes, defaultMaxBodyBytes)
+
+		var refund model.Refund
+		if !bindJSON(c, &refund) {
+			return
+		}
+
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		refunded, ok := refundedAmount(c, refunds, transaction)
+		if !ok {
+			return
+		}
+
+		refund.TransactionID = transaction.ID
+		if refund.Kind == "" {
+			refund.Kind = model.RefundKindRefund
+		}
+		if refund.Kind == model.RefundKindReversal && refund.Amount.Amount == 0 {
+			refund.Amount = util.Money{Amount: transaction.Amount.Amount - refunded, Currency: transaction.Amount.Currency}
+		}
+
+		errs := refund.Validate()
+		if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, refund.RefundDate); err == nil && refund.RefundDate < transaction.TransactionDate {
+			errs = append(errs, refundBeforePurchase(refund))
+		}
+		if len(errs) > 0 {
+			util.InfoLogger.Println(fmt.Sprintf("refund refused. StatusCode %d:", http.StatusBadRequest), errs)
+			writeValidationProblem(c, errs)
+			return
+		}
+
+		refund.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := refunds.Create(c.Request.Context(), &refund); err != nil {
+			switch {
+			case errors.Is(err, repository.ErrNotFound):
+				util.WarningLogger.Printf("transaction with id %d not found", transaction.ID)
+				writeProblem(c, http.StatusNotFound, problemNotFound, "transaction not found")
+			case errors.Is(err, repository.ErrRefundBeforePurchase):
+				// The transaction was dated after the refund meanwhile
+				errs := model.ValidationErrors{refundBeforePurchase(refund)}
+				util.InfoLogger.Println(fmt.Sprintf("refund refused. StatusCode %d:", http.StatusBadRequest), errs)
+				writeValidationProblem(c, errs)
+			case errors.Is(err, repository.ErrRefundExceedsAmount):
+				// Other refunds may have been stored meanwhile
+				if refunded, ok = refundedAmount(c, refunds, transaction); !ok {
+					return
+				}
+				remaining := util.Money{Amount: transaction.Amount.Amount - refunded, Currency: transaction.Amount.Currency}
+				util.InfoLogger.Println(fmt.Sprintf("refund refused. StatusCode %d:", http.StatusUnprocessableEntity), err)
+				writeProblem(c, http.StatusUnprocessableEntity, problemRefundExceedsAmount,
+					fmt.Sprintf("refunds cannot exceed the transaction amount of %s; at most %s can still be refunded", transaction.Amount, remaining))
+			default:
+				util.ErrorLogger.Println(fmt.Sprintf("failed to store refund. StatusCode %d:", http.StatusInternalServerError), err)
+				writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store refund")
+			}
+			return
+		}
+
+		util.InfoLogger.Println("refund successfully stored:", refund.ID)
+		c.JSON(http.StatusCreated, refund)
+	}
+}
+
+// refundBeforePurchase is the error of a refund dated before the transaction.
+func refundBeforePurchase(refund model.Refund) model.FieldError {
+	return model.FieldError{Field: "refund_date", Code: model.CodeBeforePurchase, Message: "Refund date must not be before the transaction date", RejectedValue: refund.RefundDate}
+}
+
+// ListRefundsHandler handles GET /transactions/:id/refunds.
+// It returns the refunds of the transaction in "data", oldest first, with the
+// total refunded in "refunded_amount" and what is left of the purchase in "net_amount".
+//
+// If the transaction does not exist, it will return 404 with the error message.
+// Otherwise it will return 200.
+func ListRefundsHandler(repo repository.TransactionRepository, refunds repository.RefundRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		list, err := refunds.ListByTransaction(c.Request.Context(), transaction.ID)
+		if err != nil {
+			util.ErrorLogger.Println("failed to list refunds:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to list refunds")
+			return
+		}
+
+		var refunded int64
+		for _, refund := range list {
+			refunded += refund.Amount.Amount
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data":            list,
+			"refunded_amount": util.Money{Amount: refunded, Currency: transaction.Amount.Currency},
+			"net_amount":      util.Money{Amount: transaction.Amount.Amount - refunded, Currency: transaction.Amount.Currency},
+		})
+	}
+}
+
+// ConvertRefundHandler handles GET /transactions/:id/refunds/:refund_id/convert?currency=<currency>.
+// It converts the refund amount like ConvertTransactionHandler converts the
+// transaction amount, at the rate of the purchase date or of the refund date,
+// as configured with refunds.rate_date or given in the rate_date query
+// parameter (purchase or refund). Converting a full refund at the rate of the
+// purchase gives back the amount originally converted.
+//
+// The status codes are the same as for ConvertTransactionHandler, and a 404 is
+// also returned when the refund does not exist. The response has the converted
+// amount, the provenance of the rate and the rate date used in "rate_date".
+func ConvertRefundHandler(repo repository.TransactionRepository, refunds repository.RefundRepository, provider service.ExchangeRateProvider, defaultPolicy service.ConversionPolicy, rounding util.RoundingMode, conversions repository.ConversionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currency, ok := resolveCurrency(c, "currency")
+		if !ok {
+			return
+		}
+
+		rateDate := c.DefaultQuery("rate_date", config.AppConfig.Refunds.RateDate)
+		if rateDate == "" {
+			rateDate = rateDatePurchase
+		}
+		if rateDate != rateDatePurchase && rateDate != rateDateRefund {
+			util.InfoLogger.Println(fmt.Sprintf("conversion refused. StatusCode %d:", http.StatusBadRequest), "invalid rate_date", rateDate)
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "rate_date must be purchase or refund")
+			return
+		}
+
+		policy, ok := conversionPolicy(c, defaultPolicy)
+		if !ok {
+			return
+		}
+
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		refund, ok := findRefund(c, refunds, transaction.ID, c.Param("refund_id"))
+		if !ok {
+			return
+		}
+
+		date := transaction.TransactionDate
+		if rateDate == rateDateRefund {
+			date = refund.RefundDate
+		}
+		conversion, failure := recordConversion(c.Request.Context(), provider, rounding, conversions, policy, &model.Conversion{
+			TransactionID:      transaction.ID,
+			TransactionVersion: transaction.Version,
+			RefundID:           refund.ID,
+			TransactionDate:    date,
+			USDAmount:          refund.Amount,
+		}, currency.Country, currency.Code)
+		if failure != nil {
+			writeProblem(c, failure.status, failure.kind, failure.message)
+			return
+		}
+
+		response := conversionFields(conversion)
+		if rateDate == rateDateRefund {
+			response["days_before_refund"] = response["days_before_purchase"]
+			delete(response, "days_before_purchase")
+		}
+		response["refund_id"] = refund.ID
+		response["transaction_id"] = transaction.ID
+		response["refund_date"] = refund.RefundDate
+		response["usd_amount"] = refund.Amount
+		response["rate_date"] = rateDate
+		response["country"] = currency.Country
+		response["policy"] = conversion.Policy
+		util.InfoLogger.Println("successfully converted refund:", response)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// findRefund loads the refund of the transaction identified by the given path
+// parameter. When the refund cannot be loaded it writes the error response and returns false.
+func findRefund(c *gin.Context, refunds repository.RefundRepository, transactionID int, id string) (*model.Refund, bool) {
+	refundID, err := strconv.Atoi(id)
+	if err != nil {
+		util.WarningLogger.Printf("refund with id %s not found", id)
+		writeProblem(c, http.StatusNotFound, problemNotFound, "refund not found")
+		return nil, false
+	}
+
+	refund, err := refunds.Get(c.Request.Context(), transactionID, refundID)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefundNotFound) {
+			util.WarningLogger.Printf("refund with id %s not found", id)
+			writeProblem(c, http.StatusNotFound, problemNotFound, "refund not found")
+		} else {
+			util.ErrorLogger.Println("failed to retrieve refund:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to retrieve refund")
+		}
+		return nil, false
+	}
+
+	return refund, true
+}
+
+// refundedAmount returns the total refunded of the transaction, in minor units.
+// When it cannot be looked up it writes the error response and returns false.
+func refundedAmount(c *gin.Context, refunds repository.RefundRepository, transaction *model.Transaction) (int64, bool) {
+	totals, err := refunds.Totals(c.Request.Context(), []int{transaction.ID})
+	if err != nil {
+		util.ErrorLogger.Println("failed to sum refunds:", err)
+		writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to sum refunds")
+		return 0, false
+	}
+	return totals[transaction.ID], true
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// monthlyProvider returns the Euro Zone rate of the month of the date asked for.
+type monthlyProvider map[string]float64
+
+func (p monthlyProvider) RateOn(_ context.Context, _ string, date time.Time, _ service.Lookback) (service.Rate, error) {
+	month := date.Format("2006-01")
+	rate, ok := p[month]
+	if !ok {
+		return service.Rate{}, service.ErrNoRate
+	}
+	return service.Rate{Country: "Euro Zone", Currency: "Euro", ExchangeRate: rate, EffectiveDate: month + "-01", RecordDate: month + "-01", Source: service.TreasurySource}, nil
+}
+
+// staleTransactionRepository returns the transactions as they were dated before
+// a concurrent update.
+type staleTransactionRepository struct {
+	repository.TransactionRepository
+	date string
+}
+
+func (r staleTransactionRepository) GetByID(ctx context.Context, id int) (*model.Transaction, error) {
+	transaction, err := r.TransactionRepository.GetByID(ctx, id)
+	if err == nil {
+		transaction.TransactionDate = r.date
+	}
+	return transaction, err
+}
+
+func TestRefundHandlers(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	// The transaction of 12.34 USD is dated 2020-01-01
+	repo := newRepositoryWithTransaction(t)
+	refunds := repository.NewInMemoryRefundRepository(repo)
+	conversions := repository.NewInMemoryConversionRepository()
+	provider := monthlyProvider{"2020-01": 0.9, "2020-02": 0.8}
+
+	router := gin.New()
+	router.POST("/transactions/:id/refunds", CreateRefundHandler(repo, refunds))
+	router.GET("/transactions/:id/refunds", ListRefundsHandler(repo, refunds))
+	router.GET("/transactions/:id/refunds/:refund_id/convert", ConvertRefundHandler(repo, refunds, provider, service.DefaultConversionPolicy, util.RoundHalfUp, conversions))
+	router.GET("/transactions", ListTransactionsHandler(repo, repository.NewInMemoryCategoryRepository(), refunds))
+	router.PATCH("/transactions/:id", PatchTransactionHandler(repo, repository.NewInMemoryCategoryRepository(), refunds))
+
+	send := func(method string, path string, body string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", `"1"`)
+		router.ServeHTTP(w, req)
+
+		var response map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	t.Run("refuses invalid refunds", func(t *testing.T) {
+		code, body := send("POST", "/transactions/1/refunds", `{"amount": 1, "refund_date": "2019-12-31"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, []any{map[string]any{"field": "refund_date", "code": "before_purchase", "message": "Refund date must not be before the transaction date", "rejected_value": "2019-12-31"}}, body["errors"])
+
+		code, body = send("POST", "/transactions/1/refunds", `{"kind": "chargeback", "amount": 0, "refund_date": "2020-02-01"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Len(t, body["errors"], 2)
+
+		code, _ = send("POST", "/transactions/2/refunds", `{"amount": 1, "refund_date": "2020-02-01"}`)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("refuses refunds dated before a concurrent update", func(t *testing.T) {
+		updated := newRepositoryWithTransaction(t)
+		stale := gin.New()
+		stale.POST("/transactions/:id/refunds", CreateRefundHandler(staleTransactionRepository{updated, "2019-12-01"}, repository.NewInMemoryRefundRepository(updated)))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/transactions/1/refunds", strings.NewReader(`{"amount": 1, "refund_date": "2019-12-15"}`))
+		req.Header.Set("Content-Type", "application/json")
+		stale.ServeHTTP(w, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []any{map[string]any{"field": "refund_date", "code": "before_purchase", "message": "Refund date must not be before the transaction date", "rejected_value": "2019-12-15"}}, body["errors"])
+	})
+
+	t.Run("refunds up to the transaction amount", func(t *testing.T) {
+		code, body := send("POST", "/transactions/1/refunds", `{"amount": 10, "refund_date": "2020-02-10", "reason": "Returned"}`)
+		assert.Equal(t, http.StatusCreated, code)
+		assert.Equal(t, "refund", body["kind"])
+		assert.Equal(t, float64(10), body["amount"])
+		assert.Equal(t, float64(1), body["transaction_id"])
+
+		code, body = send("POST", "/transactions/1/refunds", `{"amount": 2.35, "refund_date": "2020-02-11"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, code)
+		assert.Equal(t, "/problems/refund-exceeds-amount", body["type"])
+		assert.Equal(t, "refunds cannot exceed the transaction amount of 12.34; at most 2.34 can still be refunded", body["detail"])
+
+		// A reversal cancels what is left
+		code, body = send("POST", "/transactions/1/refunds", `{"kind": "reversal", "refund_date": "2020-02-11"}`)
+		assert.Equal(t, http.StatusCreated, code)
+		assert.Equal(t, 2.34, body["amount"])
+	})
+
+	t.Run("lists net amounts", func(t *testing.T) {
+		code, body := send("GET", "/transactions/1/refunds", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, body["data"], 2)
+		assert.Equal(t, 12.34, body["refunded_amount"])
+		assert.Equal(t, float64(0), body["net_amount"])
+
+		code, body = send("GET", "/transactions", "")
+		assert.Equal(t, http.StatusOK, code)
+		transaction := body["data"].([]any)[0].(map[string]any)
+		assert.Equal(t, 12.34, transaction["amount"])
+		assert.Equal(t, 12.34, transaction["refunded_amount"])
+		assert.Equal(t, float64(0), transaction["net_amount"])
+	})
+
+	t.Run("keeps the amount above the refunds", func(t *testing.T) {
+		code, body := send("PATCH", "/transactions/1", `{"amount": 12}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, []any{map[string]any{"field": "amount", "code": "below_refunded", "message": "Amount must not be less than the 12.34 already refunded", "rejected_value": float64(12)}}, body["errors"])
+	})
+
+	t.Run("keeps the purchase before the refunds", func(t *testing.T) {
+		code, body := send("PATCH", "/transactions/1", `{"transaction_date": "2020-02-11"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, []any{map[string]any{"field": "transaction_date", "code": "after_refund", "message": "Transaction date must not be after the date of its refunds", "rejected_value": "2020-02-11"}}, body["errors"])
+	})
+
+	t.Run("converts at the rate of the purchase or of the refund", func(t *testing.T) {
+		code, body := send("GET", "/transactions/1/refunds/1/convert?currency=EUR", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "purchase", body["rate_date"])
+		assert.Equal(t, 0.9, body["exchange_rate"])
+		assert.Equal(t, float64(9), body["converted_amount"])
+		assert.Equal(t, float64(10), body["usd_amount"])
+		assert.Equal(t, "2020-02-10", body["refund_date"])
+
+		config.AppConfig.Refunds.RateDate = "refund"
+		defer func() { config.AppConfig.Refunds.RateDate = "purchase" }()
+		code, body = send("GET", "/transactions/1/refunds/1/convert?currency=EUR", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "refund", body["rate_date"])
+		assert.Equal(t, float64(8), body["converted_amount"])
+		assert.Equal(t, float64(9), body["days_before_refund"])
+
+		// The query parameter overrides the configuration
+		_, body = send("GET", "/transactions/1/refunds/1/convert?currency=EUR&rate_date=purchase", "")
+		assert.Equal(t, float64(9), body["converted_amount"])
+
+		history, err := conversions.ListByTransaction(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		assert.Equal(t, 1, history[1].RefundID)
+		assert.Equal(t, "2020-02-10", history[1].TransactionDate)
+
+		code, body = send("GET", "/transactions/1/refunds/1/convert?currency=EUR&rate_date=today", "")
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "rate_date must be purchase or refund", body["detail"])
+
+		code, _ = send("GET", "/transactions/1/refunds/9/convert?currency=EUR", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
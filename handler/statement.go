@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+// StatementHandler handles GET /statements?from_date=<date>&to_date=<date>&currency=<currency>.
+// It converts every transaction dated within the period (inclusive, YYYY-MM-DD)
+// to one currency, given like in ConvertTransactionHandler, and returns the line
+// items with the totals in US dollars and in the currency. The rate of each
+// transaction date is looked up once with the configured conversion policy,
+// which the policy query parameters of ConvertTransactionHandler override.
+//
+// If the currency or a date is missing or invalid, it will return 400 with the error message.
+// If a policy parameter is invalid, it will return 400 with the error message.
+// If the period holds more than service.MaxStatementTransactions transactions, it will return 400 with the error message.
+// If the exchange rates are unavailable, it will return 503 with the error message.
+// Otherwise it will return 200 with the statement. Transactions without an
+// exchange rate are listed with an error and left out of the converted totals.
+// Every converted line is recorded in the audit trail, with its ID in "conversion_id".
+func StatementHandler(builder *service.StatementBuilder, defaultPolicy service.ConversionPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to := c.Query("from_date"), c.Query("to_date")
+		if err := service.ValidatePeriod(from, to); err != nil {
+			util.InfoLogger.Println(fmt.Sprintf("statement refused. StatusCode %d:", http.StatusBadRequest), err)
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, err.Error())
+			return
+		}
+
+		currency, ok := resolveCurrency(c, "currency")
+		if !ok {
+			return
+		}
+
+		policy, ok := conversionPolicy(c, defaultPolicy)
+		if !ok {
+			return
+		}
+
+		statement, err := builder.Build(c.Request.Context(), from, to, currency, policy)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrStatementTooLarge):
+				util.InfoLogger.Println(fmt.Sprintf("statement refused. StatusCode %d:", http.StatusBadRequest), err)
+				writeProblem(c, http.StatusBadRequest, problemStatementTooLarge, fmt.Sprintf("the period holds more than %d transactions, request a shorter one", service.MaxStatementTransactions))
+			case errors.Is(err, service.ErrCircuitOpen):
+				util.WarningLogger.Println("exchange rates unavailable:", err)
+				writeProblem(c, http.StatusServiceUnavailable, problemRatesUnavailable, "exchange rates are temporarily unavailable, try again later")
+			default:
+				util.ErrorLogger.Println("failed to build statement:", err)
+				writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to build statement")
+			}
+			return
+		}
+
+		util.InfoLogger.Printf("statement %s to %s in %s: %d converted, %d failed", from, to, currency.Code, statement.Converted, statement.Failed)
+		c.JSON(http.StatusOK, statement)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestStatementHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	provider := &stubProvider{rates: map[string]service.Rate{
+		"Euro Zone": {Country: "Euro Zone", Currency: "Euro", ExchangeRate: 0.9, EffectiveDate: "2019-12-31", Source: service.TreasurySource},
+	}}
+
+	router := gin.New()
+	router.GET("/statements", StatementHandler(service.NewStatementBuilder(newRepositoryWithTransaction(t), provider, repository.NewInMemoryConversionRepository(), util.RoundHalfUp), service.DefaultConversionPolicy))
+
+	get := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/statements"+query, nil)
+		router.ServeHTTP(w, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	t.Run("converts the transactions of the period", func(t *testing.T) {
+		code, body := get("?from_date=2020-01-01&to_date=2020-01-31&currency=eur")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "EUR", body["currency"])
+		assert.Equal(t, "Euro Zone", body["country"])
+		assert.Equal(t, 12.34, body["total_usd"])
+		assert.Equal(t, 11.11, body["total_converted"])
+		assert.Equal(t, float64(1), body["converted"])
+
+		lines := body["lines"].([]any)
+		require.Len(t, lines, 1)
+		assert.Equal(t, 11.11, lines[0].(map[string]any)["converted_amount"])
+	})
+
+	t.Run("reports transactions without a rate", func(t *testing.T) {
+		code, body := get("?from_date=2020-01-01&to_date=2020-01-31&currency=CAD")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(1), body["failed"])
+		assert.Equal(t, float64(0), body["total_converted"])
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			"?to_date=2020-01-31&currency=EUR":                                 "from_date must be in YYYY-MM-DD format",
+			"?from_date=2020-02-01&to_date=2020-01-31&currency=EUR":            "from_date must not be after to_date",
+			"?from_date=2020-01-01&to_date=2020-01-31":                         "currency is required",
+			"?from_date=2020-01-01&to_date=2020-01-31&currency=EUR&lookback=x": "lookback must be a number of years, months and days such as 6m, 90d or 1y2m",
+		} {
+			code, body := get(query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+			assert.Equal(t, expected, body["detail"], query)
+		}
+	})
+}
@@ -1,59 +1,153 @@
 package handler
 
 import (
-	"database/sql"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/mvfavila/transactions/config"
 	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
 	"github.com/mvfavila/transactions/service"
 	"github.com/mvfavila/transactions/util"
 )
 
 // StoreTransactionHandler handles POST /transactions.
-// It stores a new transaction in the database. The expected body is a JSON object with fields:
+// It stores a new transaction in the repository. The expected body is a JSON object with fields:
 // - description: string
-// - amount: float64
+// - amount: number with up to two decimals (stored as integer cents)
 // - transaction_date: string in YYYY-MM-DD format
+// - merchant_name: optional string of up to 100 characters
+// - merchant_category_code: optional 4-digit ISO 18245 code
+// - category: optional code of a category of the category tree (see CreateCategoryHandler)
+// - tags: optional list of up to 10 tags, stored trimmed, lowercased and sorted
 //
-// If the request body is invalid, it will return 400 with the error message.
-// If the transaction is invalid (i.e. description is too long, amount is not positive, or date is invalid), it will return 400 with the error message.
-// If the transaction is successfully stored, it will return 201 with the stored transaction in the response body.
-func StoreTransactionHandler(db *sql.DB) gin.HandlerFunc {
+// Requests may carry an Idempotency-Key header, so that retries do not store the
+// transaction twice: a retry with the same key and body gets the stored 201
+// response again, with the Idempotent-Replayed header, until the key expires
+// (idempotency.ttl).
+//
+// The body is decoded strictly (see decodeJSON): unknown fields, repeated keys,
+// non-finite amounts and amounts with more than two decimals are refused.
+//
+// If the request body is invalid, it will return 400 with the error message, or every field refused.
+// If the request body is larger than requests.max_body_bytes, it will return 413 with the error message.
+// If the transaction is invalid (i.e. description is too long, amount is not positive, date is invalid, or category does not exist), it will return 400 with every invalid field.
+// Errors are application/problem+json responses; see problem.
+// If the Idempotency-Key is still being processed, it will return 409 with the error message.
+// If the Idempotency-Key was used with a different body, it will return 422 with the error message.
+// If the transaction is successfully stored, it will return 201 with the stored transaction in the response body and its version in the ETag header.
+func StoreTransactionHandler(repo repository.TransactionRepository, categories repository.CategoryRepository, keys repository.IdempotencyRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var transaction model.Transaction
-		if err := c.ShouldBindJSON(&transaction); err != nil {
-			util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", http.StatusBadRequest), err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		limitBody(c, config.AppConfig.Requests.MaxBodyBytes, defaultMaxBodyBytes)
+		claim, ok := claimIdempotencyKey(c, keys)
+		if !ok {
 			return
 		}
+		// Keys are released and completed even if the client has gone away
+		keyCtx := context.WithoutCancel(c.Request.Context())
+		stored := false
+		if claim != nil {
+			// Let the request be retried with the same key when it failed
+			defer func() {
+				if !stored {
+					if err := keys.Release(keyCtx, claim.Key, claim.Token); err != nil {
+						util.ErrorLogger.Println("failed to release idempotency key:", err)
+					}
+				}
+			}()
+		}
 
-		if errMsg := transaction.Validate(); errMsg != "" {
-			util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", http.StatusBadRequest), errMsg)
-			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		var transaction model.Transaction
+		if !bindJSON(c, &transaction) {
 			return
 		}
 
-		query := "INSERT INTO transactions (description, amount, transaction_date) VALUES (?, ?, ?)"
-		res, err := db.Exec(query, transaction.Description, transaction.Amount, transaction.TransactionDate)
+		errs, err := checkTransaction(c.Request.Context(), categories, &transaction)
 		if err != nil {
+			util.ErrorLogger.Println(fmt.Sprintf("failed to check transaction category. StatusCode %d:", http.StatusInternalServerError), err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transaction")
+			return
+		}
+		if len(errs) > 0 {
+			util.InfoLogger.Println(fmt.Sprintf("transaction refused. StatusCode %d:", http.StatusBadRequest), errs)
+			writeValidationProblem(c, errs)
+			return
+		}
+
+		if err := repo.Create(c.Request.Context(), &transaction); err != nil {
 			util.ErrorLogger.Println(fmt.Sprintf("failed to store transaction. StatusCode %d:", http.StatusInternalServerError), err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store transaction"})
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transaction")
 			return
 		}
+		stored = true
 
-		id, _ := res.LastInsertId()
-		transaction.ID = int(id)
-		util.InfoLogger.Println("transaction successfully stored:", id)
-		c.JSON(http.StatusCreated, transaction)
+		util.InfoLogger.Println("transaction successfully stored:", transaction.ID)
+		setETag(c, transaction.Version)
+		if claim == nil {
+			c.JSON(http.StatusCreated, transaction)
+			return
+		}
+
+		// Keep the response to replay it to retries
+		body, err := json.Marshal(transaction)
+		if err != nil {
+			util.ErrorLogger.Println("failed to encode transaction:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transaction")
+			return
+		}
+		if err := completeIdempotencyKey(keyCtx, keys, claim, http.StatusCreated, body, c.Writer.Header().Get("ETag")); err != nil {
+			// The transaction is stored: answer with it, but retries with the key
+			// can no longer be replayed and may store it again once its lease lapses,
+			// or are answered with the response of the request that took it over.
+			util.ErrorLogger.Println(fmt.Sprintf("failed to store idempotent response of transaction %d:", transaction.ID), claim.Key, err)
+		}
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", body)
+	}
+}
+
+// GetTransactionHandler handles GET /transactions/:id.
+// It returns the stored transaction without converting its amount, so it does
+// not depend on the Treasury API.
+//
+// If the transaction does not exist, it will return 404 with the error message.
+// Otherwise it will return 200 with the stored transaction in the response body
+// and its version in the ETag header.
+func GetTransactionHandler(repo repository.TransactionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		util.InfoLogger.Println("successfully retrieved transaction:", transaction.ID)
+		setETag(c, transaction.Version)
+		c.JSON(http.StatusOK, transaction)
 	}
 }
 
 // RetrievePurchaseTransactionHandler handles GET /transactions/:id/exchange-rate/:country.
-// It retrieves a transaction, fetches exchange rates, and calculates the converted amount.
-func RetrievePurchaseTransactionHandler(db *sql.DB, client *http.Client) gin.HandlerFunc {
+// It retrieves a transaction, looks up the exchange rate from the provider, and calculates the converted amount.
+// The country is a Treasury country name of the currency catalogue, matched ignoring
+// case; see ConvertTransactionHandler for ISO currency codes. Countries missing from
+// the catalogue are refused with 400, as the minor unit of their currency is unknown.
+// The converted amount is rounded to the minor unit of the country's currency, given
+// in "scale", with the ISO code of the currency.
+// The response includes the provenance of the rate (source, effective and record dates)
+// and every conversion is recorded in the audit trail.
+// The rate is selected with the configured conversion policy, which the policy
+// query parameters of ConvertTransactionHandler override; the response includes
+// the policy used and how the rate was found under it.
+func RetrievePurchaseTransactionHandler(repo repository.TransactionRepository, provider service.ExchangeRateProvider, defaultPolicy service.ConversionPolicy, rounding util.RoundingMode, conversions repository.ConversionRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Parse parameters
 		country := c.Param("country")
@@ -61,56 +155,275 @@ func RetrievePurchaseTransactionHandler(db *sql.DB, client *http.Client) gin.Han
 
 		if country == "" {
 			util.WarningLogger.Println("country parameter is required")
-			c.JSON(http.StatusBadRequest, gin.H{"error": "country is required"})
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "country is required")
 			return
 		}
 
-		// Retrieve transaction from database
-		var transaction model.Transaction
-		query := "SELECT id, description, amount, transaction_date FROM transactions WHERE id = ?"
-		err := db.QueryRow(query, id).Scan(&transaction.ID, &transaction.Description, &transaction.Amount, &transaction.TransactionDate)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				util.WarningLogger.Printf("transaction with id %s not found", id)
-				c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found"})
-			} else {
-				util.ErrorLogger.Println("failed to retrieve transaction:", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve transaction"})
-			}
+		// Country names are matched ignoring case; countries missing from the
+		// currency catalogue are refused, as their minor unit is unknown.
+		currency, ok := lookupCurrency(c, country)
+		if !ok {
 			return
 		}
 
-		util.InfoLogger.Println("successfully retrieved transaction:", &transaction)
-
-		// Fetch exchange rates
-		rates, err := service.FetchExchangeRates(client, country, &transaction)
-		if err != nil {
-			util.ErrorLogger.Println("failed to fetch exchange rates:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch exchange rates"})
+		policy, ok := conversionPolicy(c, defaultPolicy)
+		if !ok {
 			return
 		}
 
-		// Check if rates were found
-		if len(rates) == 0 {
-			util.WarningLogger.Printf("no exchange rate found for country %s", country)
-			c.JSON(http.StatusNotFound, gin.H{"error": "the purchase cannot be converted to the target currency"})
+		// Retrieve transaction from the repository
+		transaction, ok := findTransaction(c, repo, id)
+		if !ok {
 			return
 		}
 
-		// Use the most recent exchange rate
-		latestRate := rates[0]
-		convertedAmount := transaction.Amount * latestRate.ExchangeRate
+		util.InfoLogger.Println("successfully retrieved transaction:", transaction)
 
-		// Respond with the result
-		response := gin.H{
-			"id":               transaction.ID,
-			"description":      transaction.Description,
-			"transaction_date": transaction.TransactionDate,
-			"usd_amount":       transaction.Amount,
-			"exchange_rate":    latestRate.ExchangeRate,
-			"converted_amount": util.RoundToCents(convertedAmount),
+		conversion, ok := convertTransaction(c, provider, rounding, conversions, policy, transaction, currency.Country, currency.Code)
+		if !ok {
+			return
 		}
+
+		// Respond with the result
+		response := conversionResponse(transaction, conversion)
 		util.InfoLogger.Println("successfully retrieved transaction with exchange rate:", response)
 		c.JSON(http.StatusOK, response)
 	}
 }
+
+// findTransaction loads the transaction identified by the given path parameter.
+// When the transaction cannot be loaded it writes the error response and returns false.
+func findTransaction(c *gin.Context, repo repository.TransactionRepository, id string) (*model.Transaction, bool) {
+	transactionID, err := strconv.Atoi(id)
+	if err != nil {
+		util.WarningLogger.Printf("transaction with id %s not found", id)
+		writeProblem(c, http.StatusNotFound, problemNotFound, "transaction not found")
+		return nil, false
+	}
+
+	transaction, err := repo.GetByID(c.Request.Context(), transactionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			util.WarningLogger.Printf("transaction with id %s not found", id)
+			writeProblem(c, http.StatusNotFound, problemNotFound, "transaction not found")
+		} else {
+			util.ErrorLogger.Println("failed to retrieve transaction:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to retrieve transaction")
+		}
+		return nil, false
+	}
+
+	return transaction, true
+}
+
+// checkTransaction normalizes and validates the transaction and checks that its
+// category exists. It returns every invalid field, or an error when the category
+// could not be looked up.
+func checkTransaction(ctx context.Context, categories repository.CategoryRepository, transaction *model.Transaction) (model.ValidationErrors, error) {
+	transaction.Normalize()
+	errs := transaction.Validate()
+	if transaction.Category == "" || !model.ValidCategoryCode(transaction.Category) {
+		return errs, nil
+	}
+
+	if _, err := categories.Get(ctx, transaction.Category); err != nil {
+		if !errors.Is(err, repository.ErrCategoryNotFound) {
+			return nil, err
+		}
+		errs = append(errs, model.FieldError{Field: "category", Code: model.CodeUnknownCategory, Message: "Category does not exist", RejectedValue: transaction.Category})
+	}
+
+	return errs, nil
+}
+
+// maxListLimit is the largest page size accepted by ListTransactionsHandler.
+const maxListLimit = 500
+
+// listTransactionsResponse is the body returned by ListTransactionsHandler.
+type listTransactionsResponse struct {
+	Data       []listedTransaction `json:"data"`
+	NextCursor *string             `json:"next_cursor"`
+}
+
+// listedTransaction is a transaction returned by ListTransactionsHandler, with
+// the total of its refunds and what is left of the purchase.
+type listedTransaction struct {
+	model.Transaction
+	RefundedAmount util.Money `json:"refunded_amount"`
+	NetAmount      util.Money `json:"net_amount"`
+}
+
+// ListTransactionsHandler handles GET /transactions.
+// It returns a page of stored transactions. The supported query parameters are:
+// - from_date, to_date: inclusive transaction date range in YYYY-MM-DD format
+// - min_amount, max_amount: inclusive amount range in US dollars
+// - description: case-insensitive substring of the description
+// - merchant: case-insensitive substring of the merchant name
+// - mcc: merchant category code
+// - category: code of a category; transactions in its subcategories match too
+// - tag: a tag the transactions must have; repeat it to require several tags
+// - sort: id, transaction_date or amount, prefixed with "-" for descending order (default id)
+// - limit: page size, between 1 and 500 (default 100)
+// - cursor: the next_cursor returned with the previous page, followed with the same sort and filters
+//
+// If a parameter is invalid or the category does not exist, it will return 400 with the error message.
+// If the cursor was returned for another sort or other filters, it will return 400 with the error message.
+// Otherwise it will return 200 with the transactions in `data` and, when more
+// transactions follow, the cursor of the next page in `next_cursor`. Every
+// transaction has the total of its refunds in `refunded_amount` and its amount
+// less its refunds in `net_amount`; the filters apply to the original amount.
+func ListTransactionsHandler(repo repository.TransactionRepository, categories repository.CategoryRepository, refunds repository.RefundRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, errMsg := parseListOptions(c)
+		if errMsg != "" {
+			util.InfoLogger.Println(fmt.Sprintf("transaction listing refused. StatusCode %d:", http.StatusBadRequest), errMsg)
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, errMsg)
+			return
+		}
+
+		if category := c.Query("category"); category != "" {
+			var err error
+			if options.Categories, err = categories.Subtree(c.Request.Context(), category); err != nil {
+				if errors.Is(err, repository.ErrCategoryNotFound) {
+					util.InfoLogger.Println(fmt.Sprintf("transaction listing refused. StatusCode %d:", http.StatusBadRequest), err)
+					writeProblem(c, http.StatusBadRequest, problemInvalidRequest, fmt.Sprintf("category %q does not exist", category))
+					return
+				}
+				util.ErrorLogger.Println("failed to list category subtree:", err)
+				writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to list transactions")
+				return
+			}
+		}
+
+		page, err := repo.List(c.Request.Context(), options)
+		if err != nil {
+			if errors.Is(err, repository.ErrInvalidCursor) {
+				util.InfoLogger.Println(fmt.Sprintf("transaction listing refused. StatusCode %d:", http.StatusBadRequest), err)
+				writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "cursor is invalid or does not match the sort order and filters")
+				return
+			}
+			util.ErrorLogger.Println("failed to list transactions:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to list transactions")
+			return
+		}
+
+		ids := make([]int, len(page.Transactions))
+		for i, transaction := range page.Transactions {
+			ids[i] = transaction.ID
+		}
+		totals, err := refunds.Totals(c.Request.Context(), ids)
+		if err != nil {
+			util.ErrorLogger.Println("failed to sum refunds:", err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to list transactions")
+			return
+		}
+
+		response := listTransactionsResponse{Data: make([]listedTransaction, len(page.Transactions))}
+		for i, transaction := range page.Transactions {
+			refunded := util.Money{Amount: totals[transaction.ID], Currency: transaction.Amount.Currency}
+			net := util.Money{Amount: transaction.Amount.Amount - refunded.Amount, Currency: transaction.Amount.Currency}
+			response.Data[i] = listedTransaction{Transaction: transaction, RefundedAmount: refunded, NetAmount: net}
+		}
+		if page.Next != nil {
+			page.Next.Filter = listFilterHash(options, c.Query("category"))
+			next := page.Next.Encode()
+			response.NextCursor = &next
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// parseListOptions reads the listing query parameters. It returns a non-empty
+// error message when a parameter is invalid.
+func parseListOptions(c *gin.Context) (repository.ListOptions, string) {
+	var options repository.ListOptions
+
+	for _, param := range []struct {
+		name  string
+		value *string
+	}{
+		{"from_date", &options.FromDate},
+		{"to_date", &options.ToDate},
+	} {
+		*param.value = c.Query(param.name)
+		if *param.value == "" {
+			continue
+		}
+		if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, *param.value); err != nil {
+			return options, param.name + " must be in YYYY-MM-DD format"
+		}
+	}
+
+	for _, param := range []struct {
+		name  string
+		value **int64
+	}{
+		{"min_amount", &options.MinAmount},
+		{"max_amount", &options.MaxAmount},
+	} {
+		raw := c.Query(param.name)
+		if raw == "" {
+			continue
+		}
+		amount, err := util.ParseMoney(raw, util.USDCurrency)
+		if err != nil {
+			return options, param.name + " must be a number"
+		}
+		*param.value = &amount.Amount
+	}
+
+	options.Description = c.Query("description")
+	options.Merchant = c.Query("merchant")
+
+	if options.MerchantCategoryCode = c.Query("mcc"); options.MerchantCategoryCode != "" {
+		if len(options.MerchantCategoryCode) != 4 || strings.Trim(options.MerchantCategoryCode, "0123456789") != "" {
+			return options, "mcc must be 4 digits"
+		}
+	}
+
+	options.Tags = model.NormalizeTags(c.QueryArray("tag"))
+
+	if sortBy := c.Query("sort"); sortBy != "" {
+		if strings.HasPrefix(sortBy, "-") {
+			options.Descending = true
+			sortBy = sortBy[1:]
+		}
+		options.SortBy = repository.SortField(sortBy)
+		if !options.SortBy.IsValid() {
+			return options, "sort must be one of id, transaction_date or amount, optionally prefixed with -"
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if options.Limit, err = strconv.Atoi(limit); err != nil || options.Limit < 1 || options.Limit > maxListLimit {
+			return options, fmt.Sprintf("limit must be an integer between 1 and %d", maxListLimit)
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if options.After, err = repository.DecodeCursor(cursor); err != nil || options.After.Filter != listFilterHash(options, c.Query("category")) {
+			return options, "cursor is invalid or does not match the sort order and filters"
+		}
+	}
+
+	return options, ""
+}
+
+// listFilterHash returns a hash of the sort order and filters of the listing,
+// stored in its cursors. The category is hashed as requested rather than as the
+// subtree it is expanded to, and the page size is left out, as it may change
+// from one page to the next.
+func listFilterHash(options repository.ListOptions, category string) string {
+	if options.SortBy == "" {
+		options.SortBy = repository.SortByID
+	}
+	options.Limit, options.After, options.Categories = 0, nil, nil
+	data, _ := json.Marshal(struct {
+		Options  repository.ListOptions
+		Category string
+	}{options, category})
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
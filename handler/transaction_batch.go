@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// maxBatchSize is the largest number of transactions accepted by StoreTransactionBatchHandler.
+const maxBatchSize = 10000
+
+// ndjsonContentType is the content type of newline-delimited JSON bodies.
+const ndjsonContentType = "application/x-ndjson"
+
+// Batch item statuses reported by StoreTransactionBatchHandler.
+const (
+	batchItemCreated = "created"
+	batchItemInvalid = "invalid"
+	batchItemFailed  = "failed"
+	batchItemSkipped = "skipped"
+)
+
+// batchItemResult is the outcome of a single item of a batch.
+type batchItemResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Errors lists the invalid fields of invalid items.
+	Errors model.ValidationErrors `json:"errors,omitempty"`
+}
+
+// batchResponse is the body returned by StoreTransactionBatchHandler.
+type batchResponse struct {
+	Mode    repository.BatchMode `json:"mode"`
+	Created int                  `json:"created"`
+	Failed  int                  `json:"failed"`
+	Results []batchItemResult    `json:"results"`
+}
+
+// StoreTransactionBatchHandler handles POST /transactions/batch.
+// It stores many transactions in a single database transaction. The body is
+// either a JSON array of transactions or, with the Content-Type
+// application/x-ndjson, one transaction object per line. Every item is decoded
+// and validated like in StoreTransactionHandler. If the body is larger than
+// requests.max_batch_body_bytes, it will return 413.
+//
+// The mode query parameter selects what happens when items are invalid or cannot be stored:
+// - atomic (default): nothing is stored. It will return 400 if any item is invalid,
+// or 500 if an item could not be stored.
+// - partial: every other item is stored. It will return 207 when some items failed.
+//
+// When every item is stored, it will return 201. The response always lists the
+// status of each item by index, with the ID of the created transaction or the error.
+func StoreTransactionBatchHandler(repo repository.TransactionRepository, categories repository.CategoryRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mode := repository.BatchMode(c.DefaultQuery("mode", string(repository.BatchAtomic)))
+		if mode != repository.BatchAtomic && mode != repository.BatchPartial {
+			util.InfoLogger.Println(fmt.Sprintf("transaction batch refused. StatusCode %d:", http.StatusBadRequest), "invalid mode", mode)
+			writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "mode must be atomic or partial")
+			return
+		}
+
+		limitBody(c, config.AppConfig.Requests.MaxBatchBodyBytes, defaultMaxBatchBodyBytes)
+		items, err := decodeBatch(c.Request.Body, strings.HasPrefix(c.ContentType(), ndjsonContentType))
+		if err != nil {
+			util.InfoLogger.Println(fmt.Sprintf("transaction batch refused. StatusCode %d:", bodyProblemStatus(err)), err)
+			writeBodyProblem(c, err)
+			return
+		}
+
+		response := batchResponse{Mode: mode, Results: make([]batchItemResult, len(items))}
+		var valid []*model.Transaction
+		var validIndexes []int
+		for i, item := range items {
+			response.Results[i] = batchItemResult{Index: i}
+			if item.err == nil {
+				errs, err := checkTransaction(c.Request.Context(), categories, item.transaction)
+				if err != nil {
+					util.ErrorLogger.Println(fmt.Sprintf("failed to check transaction category. StatusCode %d:", http.StatusInternalServerError), err)
+					writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transactions")
+					return
+				}
+				if len(errs) > 0 {
+					item.err = errs
+				}
+			}
+			if item.err != nil {
+				response.Results[i].Status, response.Results[i].Error = batchItemInvalid, item.err.Error()
+				errors.As(item.err, &response.Results[i].Errors)
+				response.Failed++
+				continue
+			}
+			valid = append(valid, item.transaction)
+			validIndexes = append(validIndexes, i)
+		}
+
+		if mode == repository.BatchAtomic && response.Failed > 0 {
+			markSkipped(&response, validIndexes)
+			util.InfoLogger.Println(fmt.Sprintf("transaction batch refused. StatusCode %d:", http.StatusBadRequest), response.Failed, "invalid items")
+			c.JSON(http.StatusBadRequest, response)
+			return
+		}
+
+		itemErrs, err := repo.CreateBatch(c.Request.Context(), valid, mode)
+		if err != nil && !errors.Is(err, repository.ErrBatchAborted) {
+			util.ErrorLogger.Println(fmt.Sprintf("failed to store transaction batch. StatusCode %d:", http.StatusInternalServerError), err)
+			writeProblem(c, http.StatusInternalServerError, problemInternal, "Failed to store transactions")
+			return
+		}
+
+		for i, index := range validIndexes {
+			result := &response.Results[index]
+			switch {
+			case itemErrs[i] != nil:
+				util.ErrorLogger.Println("failed to store batch item", index, itemErrs[i])
+				result.Status, result.Error = batchItemFailed, "Failed to store transaction"
+				response.Failed++
+			case err != nil:
+				result.Status = batchItemSkipped
+			default:
+				result.Status, result.ID = batchItemCreated, valid[i].ID
+				response.Created++
+			}
+		}
+
+		status := http.StatusCreated
+		switch {
+		case err != nil:
+			status = http.StatusInternalServerError
+		case response.Failed > 0:
+			status = http.StatusMultiStatus
+		}
+
+		util.InfoLogger.Printf("transaction batch processed: %d created, %d failed", response.Created, response.Failed)
+		c.JSON(status, response)
+	}
+}
+
+// batchItem is a decoded item of a batch, or the error that prevented decoding it.
+type batchItem struct {
+	transaction *model.Transaction
+	err         error
+}
+
+// decodeBatch reads the items of a batch body, either a JSON array or NDJSON.
+// Items that are not valid transaction objects are returned with their error; an
+// error is returned only when the body as a whole cannot be read.
+func decodeBatch(body io.Reader, ndjson bool) ([]batchItem, error) {
+	var raw []json.RawMessage
+	if ndjson {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			raw = append(raw, append(json.RawMessage(nil), line...))
+			if len(raw) > maxBatchSize {
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read NDJSON body: %w", err)
+		}
+	} else if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("body must be a JSON array of transactions: %w", err)
+	}
+
+	if len(raw) == 0 {
+		return nil, errors.New("batch must contain at least one transaction")
+	}
+	if len(raw) > maxBatchSize {
+		return nil, fmt.Errorf("batch must contain at most %d transactions", maxBatchSize)
+	}
+
+	items := make([]batchItem, len(raw))
+	for i, data := range raw {
+		var transaction model.Transaction
+		if err := decodeJSON(data, &transaction, config.AppConfig.Requests.LenientJSON); err != nil {
+			items[i].err = fmt.Errorf("invalid transaction: %w", err)
+			continue
+		}
+		items[i].transaction = &transaction
+	}
+
+	return items, nil
+}
+
+// markSkipped flags the given items as skipped because the batch was aborted.
+func markSkipped(response *batchResponse, indexes []int) {
+	for _, index := range indexes {
+		response.Results[index].Status = batchItemSkipped
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestStoreTransactionBatchHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	newRouter := func(t *testing.T) (*gin.Engine, repository.TransactionRepository) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() { db.Close() })
+		repository.ApplyMigrations(db)
+		repo := repository.NewSQLiteTransactionRepository(db)
+
+		router := gin.New()
+		router.POST("/transactions/batch", StoreTransactionBatchHandler(repo, repository.NewInMemoryCategoryRepository()))
+		router.POST("/transactions/:id/restore", RestoreTransactionHandler(repo))
+		return router, repo
+	}
+
+	post := func(router *gin.Engine, query string, contentType string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/transactions/batch"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	countStored := func(t *testing.T, repo repository.TransactionRepository) int {
+		page, err := repo.List(context.Background(), repository.ListOptions{})
+		require.NoError(t, err)
+		return len(page.Transactions)
+	}
+
+	const mixedBatch = `[
+		{"description": "First", "amount": 1.00, "transaction_date": "2020-01-01"},
+		{"description": "Second", "amount": 0, "transaction_date": "2020-01-02"},
+		{"description": "Third", "amount": "abc", "transaction_date": "2020-01-03"}
+	]`
+
+	t.Run("atomic stores every item", func(t *testing.T) {
+		router, repo := newRouter(t)
+		w := post(router, "", "application/json", `[
+			{"description": "First", "amount": 1.00, "transaction_date": "2020-01-01"},
+			{"description": "Second", "amount": 2.00, "transaction_date": "2020-01-02"}
+		]`)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, `{"mode":"atomic","created":2,"failed":0,"results":[{"index":0,"status":"created","id":1},{"index":1,"status":"created","id":2}]}`, w.Body.String())
+		assert.Equal(t, 2, countStored(t, repo))
+	})
+
+	t.Run("atomic rejects the whole batch", func(t *testing.T) {
+		router, repo := newRouter(t)
+		w := post(router, "?mode=atomic", "application/json", mixedBatch)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `{"index":0,"status":"skipped"}`)
+		assert.Contains(t, w.Body.String(), `{"index":1,"status":"invalid","error":"Amount must be greater than 0","errors":[{"field":"amount","code":"not_positive","message":"Amount must be greater than 0","rejected_value":0.00}]}`)
+		assert.Contains(t, w.Body.String(), `{"index":2,"status":"invalid","error":"invalid transaction: amount must be a number","errors":[{"field":"amount","code":"invalid_format","message":"amount must be a number","rejected_value":"abc"}]}`)
+		assert.Equal(t, 0, countStored(t, repo))
+	})
+
+	t.Run("partial stores valid items", func(t *testing.T) {
+		router, repo := newRouter(t)
+		w := post(router, "?mode=partial", "application/json", mixedBatch)
+
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+		assert.Contains(t, w.Body.String(), `"created":1,"failed":2`)
+		assert.Contains(t, w.Body.String(), `{"index":0,"status":"created","id":1}`)
+		assert.Equal(t, 1, countStored(t, repo))
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		router, repo := newRouter(t)
+		body := "{\"description\": \"First\", \"amount\": 1.00, \"transaction_date\": \"2020-01-01\"}\n" +
+			"not json\n" +
+			"\n" +
+			"{\"description\": \"Third\", \"amount\": 3.00, \"transaction_date\": \"2020-01-03\"}\n"
+		w := post(router, "?mode=partial", "application/x-ndjson", body)
+
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+		assert.Contains(t, w.Body.String(), `"created":2,"failed":1`)
+		assert.Contains(t, w.Body.String(), `{"index":1,"status":"invalid"`)
+		assert.Equal(t, 2, countStored(t, repo))
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router, _ := newRouter(t)
+
+		w := post(router, "?mode=sometimes", "application/json", mixedBatch)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, `{"type":"/problems/invalid-request","title":"The request is invalid","status":400,"detail":"mode must be atomic or partial","instance":"/transactions/batch"}`, w.Body.String())
+
+		w = post(router, "", "application/json", `{"description": "Not an array"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = post(router, "", "application/json", `[]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, `{"type":"/problems/invalid-request","title":"The request is invalid","status":400,"detail":"batch must contain at least one transaction","instance":"/transactions/batch"}`, w.Body.String())
+	})
+}
@@ -2,13 +2,15 @@ package handler
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -18,6 +20,7 @@ import (
 	"github.com/mvfavila/transactions/config"
 	"github.com/mvfavila/transactions/model"
 	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
 	"github.com/mvfavila/transactions/util"
 )
 
@@ -42,16 +45,9 @@ func TestStoreTransactionHandler(t *testing.T) {
 	// Initialize logger with in-memory buffer
 	util.InitLogger(&buf)
 
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to connect to SQLite: %v", err)
-	}
-
-	repository.ApplyMigrations(db)
-
 	transaction := model.Transaction{
 		Description:     "Test",
-		Amount:          1.00,
+		Amount:          util.USD(100),
 		TransactionDate: "2020-01-01",
 	}
 
@@ -59,7 +55,7 @@ func TestStoreTransactionHandler(t *testing.T) {
 	assert.NoError(t, err)
 
 	router := gin.New()
-	router.POST("/transactions", StoreTransactionHandler(db))
+	router.POST("/transactions", StoreTransactionHandler(repository.NewInMemoryTransactionRepository(), repository.NewInMemoryCategoryRepository(), repository.NewInMemoryIdempotencyRepository()))
 
 	req, err := http.NewRequest("POST", "/transactions", strings.NewReader(string(jsonData)))
 	assert.NoError(t, err)
@@ -71,29 +67,168 @@ func TestStoreTransactionHandler(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, w.Code)
 }
 
+func TestStoreTransactionHandlerValidationProblems(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	categories := repository.NewInMemoryCategoryRepository()
+	require.NoError(t, categories.Create(context.Background(), &model.Category{Code: "food", Name: "Food"}))
+
+	router := gin.New()
+	router.POST("/transactions", StoreTransactionHandler(repository.NewInMemoryTransactionRepository(), categories, repository.NewInMemoryIdempotencyRepository()))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/transactions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("lists every invalid field", func(t *testing.T) {
+		w := post(`{"description": "` + strings.Repeat("a", 51) + `", "amount": -5, "transaction_date": "01/01/2020"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+		var body problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "/problems/validation-error", body.Type)
+		assert.Equal(t, http.StatusBadRequest, body.Status)
+		assert.Equal(t, "/transactions", body.Instance)
+		require.Len(t, body.Errors, 3)
+		assert.Equal(t, model.FieldError{Field: "description", Code: model.CodeTooLong, Message: "Description must be 50 characters or fewer", RejectedValue: strings.Repeat("a", 51)}, body.Errors[0])
+		assert.Equal(t, model.FieldError{Field: "amount", Code: model.CodeNotPositive, Message: "Amount must be greater than 0", RejectedValue: float64(-5)}, body.Errors[1])
+		assert.Equal(t, model.FieldError{Field: "transaction_date", Code: model.CodeInvalidFormat, Message: "Transaction date must be in YYYY-MM-DD format", RejectedValue: "01/01/2020"}, body.Errors[2])
+	})
+
+	t.Run("reports fields of the wrong type", func(t *testing.T) {
+		w := post(`{"description": 42, "amount": 1, "transaction_date": "2020-01-01"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{
+			"type": "/problems/validation-error",
+			"title": "The request has invalid fields",
+			"status": 400,
+			"detail": "description must be a JSON string",
+			"instance": "/transactions",
+			"errors": [{"field": "description", "code": "invalid_type", "message": "description must be a JSON string", "rejected_value": 42}]
+		}`, w.Body.String())
+	})
+
+	t.Run("refuses unknown categories", func(t *testing.T) {
+		w := post(`{"description": "Lunch", "amount": 1, "transaction_date": "2020-01-01", "category": "travel"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var body problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, model.ValidationErrors{{Field: "category", Code: model.CodeUnknownCategory, Message: "Category does not exist", RejectedValue: "travel"}}, body.Errors)
+	})
+
+	t.Run("stores normalized metadata", func(t *testing.T) {
+		w := post(`{"description": "Lunch", "amount": 1, "transaction_date": "2020-01-01",
+			"merchant_name": " Corner Cafe ", "merchant_category_code": "5812", "category": "food", "tags": ["Work", "lunch", "work"]}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.JSONEq(t, `{"id": 1, "description": "Lunch", "amount": 1, "transaction_date": "2020-01-01", "merchant_name": "Corner Cafe",
+			"merchant_category_code": "5812", "category": "food", "tags": ["lunch", "work"], "version": 1}`, w.Body.String())
+	})
+
+	t.Run("refuses malformed bodies", func(t *testing.T) {
+		w := post(`{"description": `)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `"type":"/problems/invalid-request"`)
+	})
+}
+
+// newRepositoryWithTransaction returns an in-memory repository holding a single transaction with ID 1.
+func newRepositoryWithTransaction(t *testing.T) *repository.InMemoryTransactionRepository {
+	repo := repository.NewInMemoryTransactionRepository()
+	transaction := &model.Transaction{Description: "test", Amount: util.USD(1234), TransactionDate: "2020-01-01"}
+	require.NoError(t, repo.Create(context.Background(), transaction))
+
+	return repo
+}
+
+func TestGetTransactionHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	router := gin.New()
+	router.GET("/transactions/:id", GetTransactionHandler(newRepositoryWithTransaction(t)))
+
+	t.Run("success", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "{\"id\":1,\"description\":\"test\",\"amount\":12.34,\"transaction_date\":\"2020-01-01\",\"version\":1}", w.Body.String())
+		assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("transaction not found", func(t *testing.T) {
+		for _, id := range []string{"2", "abc"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/transactions/"+id, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			assert.Equal(t, `{"type":"/problems/not-found","title":"The resource was not found","status":404,"detail":"transaction not found","instance":"/transactions/`+id+`"}`, w.Body.String())
+		}
+	})
+}
+
 func TestRetrievePurchaseTransactionHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
 	t.Run("transaction not found", func(t *testing.T) {
 		var buf bytes.Buffer
 
 		// Initialize logger with in-memory buffer
 		util.InitLogger(&buf)
 
+		repo := repository.NewInMemoryTransactionRepository()
+
+		router := gin.New()
+		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(repo, nil, service.DefaultConversionPolicy, util.RoundHalfUp, nil))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/exchange-rate/AFN", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, `{"type":"/problems/not-found","title":"The resource was not found","status":404,"detail":"transaction not found","instance":"/transactions/1/exchange-rate/AFN"}`, w.Body.String())
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		// Initialize logger with in-memory buffer
+		util.InitLogger(&buf)
+
 		// Initialize the mock database
 		db, mock, err := sqlmock.New()
 		require.NoError(t, err)
 
-		mock.ExpectQuery("SELECT id, description, amount, transaction_date FROM transactions WHERE id = \\?").
-			WithArgs("123").
-			WillReturnRows(sqlmock.NewRows([]string{"id", "description", "amount", "transaction_date"}))
+		mock.ExpectQuery("SELECT (.+) FROM transactions WHERE id = \\?").
+			WithArgs(123).
+			WillReturnError(errors.New("disk I/O error"))
 
 		router := gin.New()
-		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(db, nil))
+		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(repository.NewSQLiteTransactionRepository(db), nil, service.DefaultConversionPolicy, util.RoundHalfUp, nil))
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/transactions/123/exchange-rate/USD", nil)
+		req, _ := http.NewRequest("GET", "/transactions/123/exchange-rate/AFN", nil)
 		router.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusNotFound, w.Code)
-		assert.Equal(t, "{\"error\":\"transaction not found\"}", w.Body.String())
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, `{"type":"/problems/internal-error","title":"The server failed to process the request","status":500,"detail":"failed to retrieve transaction","instance":"/transactions/123/exchange-rate/AFN"}`, w.Body.String())
 	})
 
 	t.Run("exchange rate not found", func(t *testing.T) {
@@ -102,9 +237,7 @@ func TestRetrievePurchaseTransactionHandler(t *testing.T) {
 		// Initialize logger with in-memory buffer
 		util.InitLogger(&buf)
 
-		// Initialize the mock database
-		db, mock, err := sqlmock.New()
-		require.NoError(t, err)
+		repo := newRepositoryWithTransaction(t)
 
 		mockResponseBody := `{
 			"data": [],
@@ -122,39 +255,54 @@ func TestRetrievePurchaseTransactionHandler(t *testing.T) {
 			},
 		}
 
-		mock.ExpectQuery("SELECT id, description, amount, transaction_date FROM transactions WHERE id = \\?").
-			WithArgs("123").
-			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "description", "amount", "transaction_date"}).
-					AddRow(123, "test", 12.34, "2020-01-01"),
-			)
-
 		router := gin.New()
-		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(db, mockClient))
+		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(repo, service.NewTreasuryProvider(mockClient, config.AppConfig.TreasuryAPIBaseURL), service.DefaultConversionPolicy, util.RoundHalfUp, repository.NewInMemoryConversionRepository()))
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/transactions/123/exchange-rate/USD", nil)
+		req, _ := http.NewRequest("GET", "/transactions/1/exchange-rate/AFN", nil)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusNotFound, w.Code)
-		assert.Equal(t, "{\"error\":\"the purchase cannot be converted to the target currency\"}", w.Body.String())
+		assert.Equal(t, `{"type":"/problems/no-exchange-rate","title":"No exchange rate is available","status":404,"detail":"the purchase cannot be converted to the target currency","instance":"/transactions/1/exchange-rate/AFN"}`, w.Body.String())
 	})
 
-	t.Run("success", func(t *testing.T) {
+	t.Run("exchange rates unavailable", func(t *testing.T) {
 		var buf bytes.Buffer
 
 		// Initialize logger with in-memory buffer
 		util.InitLogger(&buf)
 
-		// Initialize the mock database
-		db, mock, err := sqlmock.New()
-		require.NoError(t, err)
+		repo := newRepositoryWithTransaction(t)
+
+		// Create a mock HTTP client failing every call
+		mockClient := &http.Client{
+			Transport: &mockRoundTripper{
+				mockError: errors.New("connection refused"),
+			},
+		}
+		provider := service.NewTreasuryProvider(mockClient, config.AppConfig.TreasuryAPIBaseURL, service.WithCircuitBreaker(service.NewCircuitBreaker(1, time.Minute)))
+
+		router := gin.New()
+		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(repo, provider, service.DefaultConversionPolicy, util.RoundHalfUp, repository.NewInMemoryConversionRepository()))
 
-		mock.ExpectQuery("SELECT id, description, amount, transaction_date FROM transactions WHERE id = \\?").
-			WithArgs("123").
-			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "description", "amount", "transaction_date"}).
-					AddRow(123, "test", 12.34, "2020-01-01"),
-			)
+		// The first failure opens the circuit breaker, then calls fail fast
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions/1/exchange-rate/AFN", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, `{"type":"/problems/rates-unavailable","title":"Exchange rates are unavailable","status":503,"detail":"exchange rates are temporarily unavailable, try again later","instance":"/transactions/1/exchange-rate/AFN"}`, w.Body.String())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		// Initialize logger with in-memory buffer
+		util.InitLogger(&buf)
+
+		repo := newRepositoryWithTransaction(t)
 
 		mockResponseBody := `{
 			"data": [
@@ -187,13 +335,140 @@ func TestRetrievePurchaseTransactionHandler(t *testing.T) {
 			},
 		}
 
+		conversions := repository.NewInMemoryConversionRepository()
 		router := gin.New()
-		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(db, client))
+		router.GET("/transactions/:id/exchange-rate/:country", RetrievePurchaseTransactionHandler(repo, service.NewTreasuryProvider(client, config.AppConfig.TreasuryAPIBaseURL), service.DefaultConversionPolicy, util.RoundHalfUp, conversions))
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/transactions/123/exchange-rate/USD", nil)
+		req, _ := http.NewRequest("GET", "/transactions/1/exchange-rate/AFN", nil)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
-		assert.Equal(t, "{\"converted_amount\":12.34,\"description\":\"test\",\"exchange_rate\":1,\"id\":123,\"transaction_date\":\"2020-01-01\",\"usd_amount\":12.34}", w.Body.String())
+		assert.Equal(t, "{\"conversion_id\":1,\"converted_amount\":12.34,\"currency\":\"AFN\",\"days_before_purchase\":0,\"description\":\"test\",\"exchange_rate\":1,\"id\":1,\"policy\":{\"lookback\":\"6m\",\"allow_later_rates\":false,\"exact_date\":false,\"fallback_to_latest\":false},\"rate_currency\":\"Afghani\",\"rate_effective_date\":\"2020-01-01\",\"rate_match\":\"lookback\",\"rate_record_date\":\"2020-01-01\",\"rate_source\":\"treasury\",\"scale\":2,\"transaction_date\":\"2020-01-01\",\"usd_amount\":12.34}", w.Body.String())
+
+		// The conversion is recorded with the exact rate applied
+		history, err := conversions.ListByTransaction(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, "Afghani", history[0].RateCurrency)
+		assert.Equal(t, "2020-01-01", history[0].RateRecordDate)
+		assert.Equal(t, util.USD(1234), history[0].USDAmount)
+		assert.Equal(t, 1, history[0].TransactionVersion)
+	})
+}
+
+func TestListTransactionsHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	categories := repository.NewInMemoryCategoryRepository()
+	for _, category := range []*model.Category{
+		{Code: "food", Name: "Food"},
+		{Code: "food-restaurants", Name: "Restaurants", Parent: "food"},
+		{Code: "travel", Name: "Travel"},
+	} {
+		require.NoError(t, categories.Create(context.Background(), category))
+	}
+
+	repo := repository.NewInMemoryTransactionRepository()
+	for _, transaction := range []*model.Transaction{
+		{Description: "First", Amount: util.USD(100), TransactionDate: "2020-01-01", MerchantName: "Corner Cafe", MerchantCategoryCode: "5812",
+			Category: "food-restaurants", Tags: []string{"lunch", "work"}},
+		{Description: "Second", Amount: util.USD(200), TransactionDate: "2020-02-01", MerchantName: "Cafe Central", Category: "food", Tags: []string{"work"}},
+		{Description: "Third", Amount: util.USD(300), TransactionDate: "2020-03-01", Category: "travel"},
+	} {
+		require.NoError(t, repo.Create(context.Background(), transaction))
+	}
+
+	router := gin.New()
+	router.GET("/transactions", ListTransactionsHandler(repo, categories, repository.NewInMemoryRefundRepository(repo)))
+
+	list := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/transactions"+query, nil)
+		router.ServeHTTP(w, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	t.Run("paginates with next_cursor", func(t *testing.T) {
+		code, body := list("?sort=-transaction_date&limit=2")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, body["data"], 2)
+		assert.Equal(t, "Third", body["data"].([]any)[0].(map[string]any)["description"])
+		require.NotNil(t, body["next_cursor"])
+
+		code, body = list("?sort=-transaction_date&limit=2&cursor=" + body["next_cursor"].(string))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, body["data"], 1)
+		assert.Equal(t, "First", body["data"].([]any)[0].(map[string]any)["description"])
+		assert.Nil(t, body["next_cursor"])
+	})
+
+	t.Run("refuses a cursor of another listing", func(t *testing.T) {
+		code, body := list("?sort=-transaction_date&limit=1&category=food")
+		assert.Equal(t, http.StatusOK, code)
+		require.NotNil(t, body["next_cursor"])
+		cursor := body["next_cursor"].(string)
+
+		for _, query := range []string{"?sort=transaction_date", "?sort=-amount", "?sort=-transaction_date", "?sort=-transaction_date&category=food&tag=work", "?sort=-transaction_date&category=travel"} {
+			code, body = list(query + "&cursor=" + cursor)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+			assert.Equal(t, "cursor is invalid or does not match the sort order and filters", body["detail"], query)
+		}
+
+		// The page size may change from one page to the next.
+		code, body = list("?sort=-transaction_date&limit=5&category=food&cursor=" + cursor)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, body["data"], 1)
+		assert.Equal(t, "First", body["data"].([]any)[0].(map[string]any)["description"])
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		code, body := list("?from_date=2020-01-15&max_amount=2.50&description=sec")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, body["data"], 1)
+		assert.Equal(t, "Second", body["data"].([]any)[0].(map[string]any)["description"])
+	})
+
+	t.Run("filters by metadata", func(t *testing.T) {
+		descriptions := func(query string) []string {
+			code, body := list(query)
+			require.Equal(t, http.StatusOK, code, query)
+			result := []string{}
+			for _, transaction := range body["data"].([]any) {
+				result = append(result, transaction.(map[string]any)["description"].(string))
+			}
+			return result
+		}
+
+		assert.Equal(t, []string{"First", "Second"}, descriptions("?merchant=CAFE"))
+		assert.Equal(t, []string{"First"}, descriptions("?mcc=5812"))
+		assert.Equal(t, []string{"First", "Second"}, descriptions("?category=food"))
+		assert.Equal(t, []string{"First"}, descriptions("?category=food-restaurants"))
+		assert.Equal(t, []string{"First", "Second"}, descriptions("?tag=Work"))
+		assert.Equal(t, []string{"First"}, descriptions("?tag=work&tag=lunch"))
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for query, message := range map[string]string{
+			"?from_date=01-01-2020": "from_date must be in YYYY-MM-DD format",
+			"?min_amount=abc":       "min_amount must be a number",
+			"?sort=description":     "sort must be one of id, transaction_date or amount, optionally prefixed with -",
+			"?limit=0":              "limit must be an integer between 1 and 500",
+			"?cursor=garbage":       "cursor is invalid or does not match the sort order and filters",
+			"?mcc=58":               "mcc must be 4 digits",
+			"?category=pets":        `category "pets" does not exist`,
+		} {
+			code, body := list(query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+			assert.Equal(t, message, body["detail"], query)
+		}
 	})
 }
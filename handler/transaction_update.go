@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ReplaceTransactionHandler handles PUT /transactions/:id.
+// It replaces every field of a stored transaction but its ID and version.
+// The expected body is the same as for POST /transactions and the If-Match header
+// must hold the ETag of the version being replaced.
+//
+// If the If-Match header is missing or *, it will return 428.
+// If the If-Match header holds a list of ETags, it will return 400.
+// If the If-Match header holds a weak ETag, it will return 412.
+// If the body or the resulting transaction is invalid, including an amount lower
+// than the refunds of the transaction or a date after one of them, it will return
+// 400 with the error message.
+// If the body is larger than requests.max_body_bytes, it will return 413.
+// If the transaction does not exist, it will return 404.
+// If the transaction was changed since the given version, it will return 412.
+// Otherwise it will return 200 with the updated transaction and its new ETag.
+func ReplaceTransactionHandler(repo repository.TransactionRepository, categories repository.CategoryRepository, refunds repository.RefundRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limitBody(c, config.AppConfig.Requests.MaxBodyBytes, defaultMaxBodyBytes)
+		version, ok := requireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var replacement model.Transaction
+		if !bindJSON(c, &replacement) {
+			return
+		}
+
+		current, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		replacement.ID = current.ID
+		updateTransaction(c, repo, categories, refunds, &replacement, version)
+	}
+}
+
+// PatchTransactionHandler handles PATCH /transactions/:id.
+// It updates only the fields present in the body (any field of POST /transactions)
+// and validates the resulting transaction again. The If-Match
+// header must hold the ETag of the version being changed.
+//
+// The status codes are the same as for ReplaceTransactionHandler.
+func PatchTransactionHandler(repo repository.TransactionRepository, categories repository.CategoryRepository, refunds repository.RefundRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limitBody(c, config.AppConfig.Requests.MaxBodyBytes, defaultMaxBodyBytes)
+		version, ok := requireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var patch model.TransactionPatch
+		if !bindJSON(c, &patch) {
+			return
+		}
+
+		transaction, ok := findTransaction(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		patch.Apply(transaction)
+		updateTransaction(c, repo, categories, refunds, transaction, version)
+	}
+}
+
+// DeleteTransactionHandler handles DELETE /transactions/:id.
+// It soft deletes the transaction, which can be brought back with
+// POST /transactions/:id/restore. The If-Match header must hold the ETag of the
+// current version.
+//
+// If the If-Match header is missing or *, it will return 428.
+// If the If-Match header holds a list of ETags, it will return 400.
+// If the If-Match header holds a weak ETag, it will return 412.
+// If the transaction does not exist, it will return 404.
+// If the transaction was changed since the given version, it will return 412.
+// Otherwise it will return 204 with the ETag of the deleted version.
+func DeleteTransactionHandler(repo repository.TransactionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, ok := requireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			respondVersionedError(c, repository.ErrNotFound, c.Param("id"))
+			return
+		}
+
+		newVersion, err := repo.Delete(c.Request.Context(), id, version)
+		if err != nil {
+			respondVersionedError(c, err, c.Param("id"))
+			return
+		}
+
+		util.InfoLogger.Println("transaction successfully deleted:", id)
+		setETag(c, newVersion)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RestoreTransactionHandler handles POST /transactions/:id/restore.
+// It restores a deleted transaction. The If-Match header must hold the ETag
+// returned when the transaction was deleted.
+//
+// If the If-Match header is missing or *, it will return 428.
+// If the If-Match header holds a list of ETags, it will return 400.
+// If the If-Match header holds a weak ETag, it will return 412.
+// If there is no deleted transaction with the given id, it will return 404.
+// If the transaction was changed since the given version, it will return 412.
+// Otherwise it will return 200 with the restored transaction and its new ETag.
+func RestoreTransactionHandler(repo repository.TransactionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, ok := requireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			respondVersionedError(c, repository.ErrNotFound, c.Param("id"))
+			return
+		}
+
+		transaction, err := repo.Restore(c.Request.Context(), id, version)
+		if err != nil {
+			respondVersionedError(c, err, c.Param("id"))
+			return
+		}
+
+		util.InfoLogger.Println("transaction successfully restored:", id)
+		setETag(c, transaction.Version)
+		c.JSON(http.StatusOK, transaction)
+	}
+}
+
+// updateTransaction validates the transaction and stores it, provided the stored
+// version is still the given one. The repository refuses, atomically with the
+// update, an amount lower than the refunds or a date after one of them.
+func updateTransaction(c *gin.Context, repo repository.TransactionRepository, categories repository.CategoryRepository, refunds repository.RefundRepository, transaction *model.Transaction, version int) {
+	errs, err := checkTransaction(c.Request.Context(), categories, transaction)
+	if err != nil {
+		util.ErrorLogger.Println(fmt.Sprintf("failed to check transaction category. StatusCode %d:", http.StatusInternalServerError), err)
+		writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to change transaction")
+		return
+	}
+	if len(errs) > 0 {
+		util.InfoLogger.Println(fmt.Sprintf("transaction update refused. StatusCode %d:", http.StatusBadRequest), errs)
+		writeValidationProblem(c, errs)
+		return
+	}
+
+	transaction.Version = version
+	err = repo.Update(c.Request.Context(), transaction)
+	if errors.Is(err, repository.ErrBelowRefunds) || errors.Is(err, repository.ErrAfterRefunds) {
+		if errs, ok := refundErrors(c, refunds, transaction, err); ok {
+			util.InfoLogger.Println(fmt.Sprintf("transaction update refused. StatusCode %d:", http.StatusBadRequest), errs)
+			writeValidationProblem(c, errs)
+		}
+		return
+	}
+	if err != nil {
+		respondVersionedError(c, err, c.Param("id"))
+		return
+	}
+
+	util.InfoLogger.Println("transaction successfully updated:", transaction.ID)
+	setETag(c, transaction.Version)
+	c.JSON(http.StatusOK, transaction)
+}
+
+// refundErrors describes the fields of the transaction refused by Update because
+// of its refunds. When the refunds cannot be looked up it writes the error
+// response and returns false.
+func refundErrors(c *gin.Context, refunds repository.RefundRepository, transaction *model.Transaction, err error) (model.ValidationErrors, bool) {
+	var errs model.ValidationErrors
+	if errors.Is(err, repository.ErrBelowRefunds) {
+		refunded, ok := refundedAmount(c, refunds, transaction)
+		if !ok {
+			return nil, false
+		}
+		message := fmt.Sprintf("Amount must not be less than the %s already refunded", util.Money{Amount: refunded, Currency: transaction.Amount.Currency})
+		errs = append(errs, model.FieldError{Field: "amount", Code: model.CodeBelowRefunded, Message: message, RejectedValue: transaction.Amount})
+	}
+	if errors.Is(err, repository.ErrAfterRefunds) {
+		errs = append(errs, model.FieldError{Field: "transaction_date", Code: model.CodeAfterRefund, Message: "Transaction date must not be after the date of its refunds", RejectedValue: transaction.TransactionDate})
+	}
+	return errs, true
+}
+
+// requireIfMatch reads the version from the If-Match header. When the header is
+// missing or invalid it writes the error response and returns false.
+// If-Match uses strong comparison (RFC 9110, section 13.1.1), so a weak ETag
+// never matches and is refused with 412. The wildcard and lists of ETags are
+// refused too, as every change must name the single version it is based on.
+func requireIfMatch(c *gin.Context) (int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		util.InfoLogger.Println(fmt.Sprintf("transaction change refused. StatusCode %d:", http.StatusPreconditionRequired), "missing If-Match header")
+		writeProblem(c, http.StatusPreconditionRequired, problemPreconditionRequired, "If-Match header with the transaction ETag is required")
+		return 0, false
+	}
+
+	if strings.Contains(header, ",") {
+		util.InfoLogger.Println(fmt.Sprintf("transaction change refused. StatusCode %d:", http.StatusBadRequest), "list in If-Match header", header)
+		writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "If-Match header must hold a single transaction ETag")
+		return 0, false
+	}
+
+	if header == "*" {
+		util.InfoLogger.Println(fmt.Sprintf("transaction change refused. StatusCode %d:", http.StatusPreconditionRequired), "wildcard If-Match header")
+		writeProblem(c, http.StatusPreconditionRequired, problemPreconditionRequired, "If-Match header must hold the transaction ETag instead of *")
+		return 0, false
+	}
+
+	if strings.HasPrefix(header, "W/") {
+		util.InfoLogger.Println(fmt.Sprintf("transaction change refused. StatusCode %d:", http.StatusPreconditionFailed), "weak If-Match header", header)
+		writeProblem(c, http.StatusPreconditionFailed, problemPreconditionFailed, "If-Match header must hold a strong ETag; weak ETags never match")
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version < 1 {
+		util.InfoLogger.Println(fmt.Sprintf("transaction change refused. StatusCode %d:", http.StatusBadRequest), "invalid If-Match header", header)
+		writeProblem(c, http.StatusBadRequest, problemInvalidRequest, "If-Match header must be a transaction ETag")
+		return 0, false
+	}
+
+	return version, true
+}
+
+// respondVersionedError writes the response for an error returned by a versioned repository operation.
+func respondVersionedError(c *gin.Context, err error, id string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		util.WarningLogger.Printf("transaction with id %s not found", id)
+		writeProblem(c, http.StatusNotFound, problemNotFound, "transaction not found")
+	case errors.Is(err, repository.ErrVersionConflict):
+		util.WarningLogger.Printf("transaction with id %s was modified concurrently", id)
+		writeProblem(c, http.StatusPreconditionFailed, problemVersionConflict, "transaction was modified by another request; fetch it again and retry")
+	default:
+		util.ErrorLogger.Println("failed to change transaction:", err)
+		writeProblem(c, http.StatusInternalServerError, problemInternal, "failed to change transaction")
+	}
+}
+
+// setETag sets the ETag header identifying the given transaction version.
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", fmt.Sprintf(`"%d"`, version))
+}
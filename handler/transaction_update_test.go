@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// newUpdateRouter returns a router with every transaction change route, backed by
+// a repository holding a single transaction with ID 1.
+func newUpdateRouter(t *testing.T) *gin.Engine {
+	repo := newRepositoryWithTransaction(t)
+	categories := repository.NewInMemoryCategoryRepository()
+	refunds := repository.NewInMemoryRefundRepository(repo)
+
+	router := gin.New()
+	router.GET("/transactions/:id", GetTransactionHandler(repo))
+	router.PUT("/transactions/:id", ReplaceTransactionHandler(repo, categories, refunds))
+	router.PATCH("/transactions/:id", PatchTransactionHandler(repo, categories, refunds))
+	router.DELETE("/transactions/:id", DeleteTransactionHandler(repo))
+	router.POST("/transactions/:id/restore", RestoreTransactionHandler(repo))
+	return router
+}
+
+// send performs a request with an optional If-Match header and JSON body.
+func send(router *gin.Engine, method string, path string, ifMatch string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPatchTransactionHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	router := newUpdateRouter(t)
+
+	w := send(router, "PATCH", "/transactions/1", "", `{"description": "Edited"}`)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = send(router, "PATCH", "/transactions/1", "abc", `{"description": "Edited"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send(router, "PATCH", "/transactions/1", `"1"`, `{"amount": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"type":"/problems/validation-error","title":"The request has invalid fields","status":400,"detail":"Amount must be greater than 0","instance":"/transactions/1","errors":[{"field":"amount","code":"not_positive","message":"Amount must be greater than 0","rejected_value":-1.00}]}`, w.Body.String())
+
+	w = send(router, "PATCH", "/transactions/1", `"1"`, `{"description": "Edited"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	assert.Equal(t, `{"id":1,"description":"Edited","amount":12.34,"transaction_date":"2020-01-01","version":2}`, w.Body.String())
+
+	// A second clerk still holding version 1 cannot overwrite the change.
+	w = send(router, "PATCH", "/transactions/1", `"1"`, `{"amount": 99}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = send(router, "PATCH", "/transactions/2", `"1"`, `{"amount": 99}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReplaceTransactionHandler(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	router := newUpdateRouter(t)
+
+	w := send(router, "PUT", "/transactions/1", `"1"`, `{"description": "Replaced", "amount": 1.5, "transaction_date": "2021-05-05"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":1,"description":"Replaced","amount":1.50,"transaction_date":"2021-05-05","version":2}`, w.Body.String())
+
+	w = send(router, "PUT", "/transactions/1", `"2"`, `{"description": "Replaced", "amount": 1.5}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"type":"/problems/validation-error","title":"The request has invalid fields","status":400,"detail":"Transaction date must be in YYYY-MM-DD format","instance":"/transactions/1","errors":[{"field":"transaction_date","code":"invalid_format","message":"Transaction date must be in YYYY-MM-DD format","rejected_value":""}]}`, w.Body.String())
+
+	w = send(router, "PUT", "/transactions/1", `"1"`, `{"description": "Again", "amount": 1, "transaction_date": "2021-05-05"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestDeleteAndRestoreTransactionHandlers(t *testing.T) {
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	router := newUpdateRouter(t)
+
+	w := send(router, "DELETE", "/transactions/1", "", "")
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = send(router, "DELETE", "/transactions/1", `"5"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = send(router, "DELETE", "/transactions/1", `"1"`, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = send(router, "GET", "/transactions/1", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send(router, "DELETE", "/transactions/1", `"2"`, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send(router, "POST", "/transactions/1/restore", `"2"`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+	w = send(router, "GET", "/transactions/1", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send(router, "POST", "/transactions/1/restore", `"3"`, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var buf bytes.Buffer
+
+	// Initialize logger with in-memory buffer
+	util.InitLogger(&buf)
+
+	router := newUpdateRouter(t)
+
+	tests := []struct {
+		name     string
+		ifMatch  string
+		wantCode int
+		wantBody string
+	}{
+		{"weak etag", `W/"1"`, http.StatusPreconditionFailed, `{"type":"/problems/precondition-failed","title":"The precondition failed","status":412,"detail":"If-Match header must hold a strong ETag; weak ETags never match","instance":"/transactions/1"}`},
+		{"wildcard", `*`, http.StatusPreconditionRequired, `{"type":"/problems/precondition-required","title":"The request must be conditional","status":428,"detail":"If-Match header must hold the transaction ETag instead of *","instance":"/transactions/1"}`},
+		{"list", `"1", "2"`, http.StatusBadRequest, `{"type":"/problems/invalid-request","title":"The request is invalid","status":400,"detail":"If-Match header must hold a single transaction ETag","instance":"/transactions/1"}`},
+		{"invalid", `"one"`, http.StatusBadRequest, `{"type":"/problems/invalid-request","title":"The request is invalid","status":400,"detail":"If-Match header must be a transaction ETag","instance":"/transactions/1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(router, "DELETE", "/transactions/1", tt.ifMatch, "")
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+
+	w := send(router, "DELETE", "/transactions/1", `"1"`, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -11,6 +14,7 @@ import (
 	"github.com/mvfavila/transactions/handler"
 	"github.com/mvfavila/transactions/middleware"
 	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/service"
 	"github.com/mvfavila/transactions/util"
 )
 
@@ -43,12 +47,90 @@ func main() {
 	// Initialize the logger
 	util.InitLogger(logFile)
 
+	// Run the migrate command instead of the server when requested
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db := repository.OpenDB(appConfig.Database.Driver, appConfig.Database.Source)
+		defer db.Close()
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	rounding, err := util.ParseRoundingMode(appConfig.Conversion.Rounding)
+	if err != nil {
+		log.Fatalf("Invalid conversion config: %v", err)
+	}
+	policy, err := service.NewConversionPolicy(appConfig.Conversion)
+	if err != nil {
+		log.Fatalf("Invalid conversion config: %v", err)
+	}
+
 	// Initialize the database
 	db := repository.InitializeDB(appConfig.Database.Driver, appConfig.Database.Source)
 	defer db.Close()
+	transactionRepository := repository.NewSQLiteTransactionRepository(db)
+	rateRepository := repository.NewSQLiteRateRepository(db)
+	conversionRepository := repository.NewSQLiteConversionRepository(db)
+	idempotencyRepository := repository.NewSQLiteIdempotencyRepository(db)
+	categoryRepository := repository.NewSQLiteCategoryRepository(db)
+	refundRepository := repository.NewSQLiteRefundRepository(db)
+
+	// Initialize the Treasury API client
+	treasuryClient := appConfig.TreasuryClient
+	treasuryOptions := []service.TreasuryOption{
+		service.WithRetryPolicy(service.RetryPolicy{
+			MaxAttempts:    treasuryClient.MaxAttempts,
+			Timeout:        treasuryClient.Timeout,
+			AttemptTimeout: treasuryClient.AttemptTimeout,
+			BaseBackoff:    treasuryClient.BaseBackoff,
+			MaxBackoff:     treasuryClient.MaxBackoff,
+		}),
+	}
+	if treasuryClient.BreakerThreshold > 0 {
+		breaker := service.NewCircuitBreaker(treasuryClient.BreakerThreshold, treasuryClient.BreakerCooldown)
+		treasuryOptions = append(treasuryOptions, service.WithCircuitBreaker(breaker))
+	}
+	treasury := service.NewTreasuryProvider(&http.Client{Timeout: treasuryClient.Timeout}, appConfig.TreasuryAPIBaseURL, treasuryOptions...)
+
+	// Initialize the exchange rate provider and the sync of the local rates
+	rateProvider, err := service.NewExchangeRateProvider(appConfig.RateProvider, treasury, rateRepository)
+	if err != nil {
+		log.Fatalf("Failed to initialize exchange rate provider: %v", err)
+	}
+	var rateCache *service.CachingProvider
+	if appConfig.RateCache.TTL > 0 {
+		rateCache = service.NewCachingProvider(rateProvider, appConfig.RateCache.TTL, appConfig.RateCache.NegativeTTL, appConfig.RateCache.MaxEntries)
+		rateProvider = rateCache
+	}
+	rateSyncer := service.NewRateSyncer(treasury, rateRepository, appConfig.RateSync.PageSize)
+
+	// Run the sync-rates command instead of the server when requested
+	if len(os.Args) > 1 && os.Args[1] == "sync-rates" {
+		result, err := rateSyncer.Sync(context.Background())
+		if err != nil {
+			log.Fatalf("Exchange rate sync failed: %v", err)
+		}
+		fmt.Printf("synced %d rates in %d pages, last record date %s\n", result.Rates, result.Pages, result.LastRecordDate)
+		return
+	}
 
-	// Initialize the HTTP client
-	httpClient := &http.Client{}
+	// Run the statement command instead of the server when requested
+	statementBuilder := service.NewStatementBuilder(transactionRepository, rateProvider, conversionRepository, rounding)
+	if len(os.Args) > 1 && os.Args[1] == "statement" {
+		if err := runStatement(context.Background(), statementBuilder, policy, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Statement failed: %v", err)
+		}
+		return
+	}
+
+	if appConfig.RateSync.Interval > 0 {
+		go rateSyncer.RunPeriodically(context.Background(), appConfig.RateSync.Interval)
+	}
+
+	if appConfig.Idempotency.TTL > 0 {
+		go purgeIdempotencyKeys(context.Background(), idempotencyRepository, appConfig.Idempotency.TTL)
+	}
 
 	// Initialize the router
 	router := gin.New()
@@ -59,8 +141,30 @@ func main() {
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
-	router.POST(transactionsPath, handler.StoreTransactionHandler(db))
-	router.GET(transactionsPath+"/:id/exchange-rate/:country", handler.RetrievePurchaseTransactionHandler(db, httpClient))
+	if rateCache != nil {
+		router.GET("/metrics/rate-cache", func(c *gin.Context) {
+			c.JSON(200, rateCache.Stats())
+		})
+	}
+	router.POST(transactionsPath, handler.StoreTransactionHandler(transactionRepository, categoryRepository, idempotencyRepository))
+	router.POST(transactionsPath+"/batch", handler.StoreTransactionBatchHandler(transactionRepository, categoryRepository))
+	router.GET(transactionsPath, handler.ListTransactionsHandler(transactionRepository, categoryRepository, refundRepository))
+	router.GET("/categories", handler.ListCategoriesHandler(categoryRepository))
+	router.POST("/categories", handler.CreateCategoryHandler(categoryRepository))
+	router.GET("/statements", handler.StatementHandler(statementBuilder, policy))
+	router.GET("/conversions", handler.ConvertAmountHandler(rateProvider, policy, rounding))
+	router.GET(transactionsPath+"/:id", handler.GetTransactionHandler(transactionRepository))
+	router.PUT(transactionsPath+"/:id", handler.ReplaceTransactionHandler(transactionRepository, categoryRepository, refundRepository))
+	router.PATCH(transactionsPath+"/:id", handler.PatchTransactionHandler(transactionRepository, categoryRepository, refundRepository))
+	router.DELETE(transactionsPath+"/:id", handler.DeleteTransactionHandler(transactionRepository))
+	router.POST(transactionsPath+"/:id/restore", handler.RestoreTransactionHandler(transactionRepository))
+	router.GET(transactionsPath+"/:id/exchange-rate/:country", handler.RetrievePurchaseTransactionHandler(transactionRepository, rateProvider, policy, rounding, conversionRepository))
+	router.GET(transactionsPath+"/:id/convert", handler.ConvertTransactionHandler(transactionRepository, rateProvider, policy, rounding, conversionRepository))
+	router.GET(transactionsPath+"/:id/conversions", handler.ConvertTransactionCountriesHandler(transactionRepository, rateProvider, policy, rounding, conversionRepository))
+	router.GET(transactionsPath+"/:id/conversion-history", handler.ConversionHistoryHandler(transactionRepository, conversionRepository))
+	router.POST(transactionsPath+"/:id/refunds", handler.CreateRefundHandler(transactionRepository, refundRepository))
+	router.GET(transactionsPath+"/:id/refunds", handler.ListRefundsHandler(transactionRepository, refundRepository))
+	router.GET(transactionsPath+"/:id/refunds/:refund_id/convert", handler.ConvertRefundHandler(transactionRepository, refundRepository, rateProvider, policy, rounding, conversionRepository))
 
 	// Start the application
 	util.InfoLogger.Println("transactions service listening on port", appConfig.Port)
@@ -68,3 +172,24 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// purgeIdempotencyKeys deletes the expired idempotency keys at every interval
+// until the context is cancelled.
+func purgeIdempotencyKeys(ctx context.Context, keys repository.IdempotencyRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := keys.DeleteExpired(ctx, now.UTC().Format("2006-01-02T15:04:05Z"))
+			if err != nil {
+				util.ErrorLogger.Println("failed to purge idempotency keys:", err)
+				continue
+			}
+			util.InfoLogger.Printf("purged %d expired idempotency keys", deleted)
+		}
+	}
+}
@@ -9,8 +9,9 @@ import (
 func Cors() gin.HandlerFunc {
 	return cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "If-Match", "Idempotency-Key"},
+		ExposeHeaders:    []string{"ETag", "Idempotent-Replayed"},
 		AllowCredentials: true,
 	})
 }
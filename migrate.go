@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mvfavila/transactions/repository"
+)
+
+const migrateUsage = "usage: transactions migrate up|down [-allow-data-loss] [steps]|status"
+
+// runMigrate executes the `migrate` command with the given arguments.
+//
+//   - up: applies every pending migration
+//   - down [-allow-data-loss] [steps]: reverts the last `steps` applied migrations
+//     (default 1), refusing to revert a migration that deletes rows unless
+//     -allow-data-loss is given
+//   - status: lists every migration and whether it has been applied
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := repository.MigrateUp(db); err != nil {
+			return err
+		}
+		fmt.Println("migrations applied")
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		allowDataLoss := flags.Bool("allow-data-loss", false, "revert migrations that delete rows")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 1 {
+			return errors.New(migrateUsage)
+		}
+		steps := 1
+		if flags.NArg() == 1 {
+			var err error
+			if steps, err = strconv.Atoi(flags.Arg(0)); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive integer")
+			}
+		}
+		reverted, err := repository.MigrateDown(db, steps, *allowDataLoss)
+		if errors.Is(err, repository.ErrDataLoss) {
+			return fmt.Errorf("%w\n%d migration(s) reverted; run migrate down -allow-data-loss to revert it anyway", err, reverted)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) reverted\n", reverted)
+	case "status":
+		statuses, err := repository.GetMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt
+			}
+			fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, state)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package model
+
+import "regexp"
+
+// categoryCodePattern is the format of category codes: lowercase words of
+// letters and digits separated by hyphens, e.g. "travel" or "office-supplies".
+var categoryCodePattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Category is a node of the category tree transactions are grouped by for budgeting.
+type Category struct {
+	// Code identifies the category, e.g. "flights". It cannot be changed.
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Parent is the code of the parent category, empty for top-level categories.
+	Parent string `json:"parent,omitempty"`
+}
+
+// Validate checks the Category fields for validity. It returns an error for
+// every invalid field, or nil when the category is valid.
+func (c *Category) Validate() ValidationErrors {
+	var errs ValidationErrors
+
+	if !ValidCategoryCode(c.Code) {
+		errs = append(errs, FieldError{Field: "code", Code: CodeInvalidFormat, Message: "Code must be 1 to 50 lowercase letters, digits and hyphens", RejectedValue: c.Code})
+	}
+
+	if c.Name == "" || len(c.Name) > 100 {
+		errs = append(errs, FieldError{Field: "name", Code: CodeInvalidLength, Message: "Name must be 1 to 100 characters", RejectedValue: c.Name})
+	}
+
+	if c.Parent != "" && !ValidCategoryCode(c.Parent) {
+		errs = append(errs, FieldError{Field: "parent", Code: CodeInvalidFormat, Message: "Parent must be the code of a category", RejectedValue: c.Parent})
+	}
+
+	return errs
+}
+
+// ValidCategoryCode reports whether code is well-formed, without checking that the category exists.
+func ValidCategoryCode(code string) bool {
+	return len(code) <= 50 && categoryCodePattern.MatchString(code)
+}
@@ -0,0 +1,46 @@
+package model
+
+import "github.com/mvfavila/transactions/util"
+
+// Conversion is the audit record of a transaction or refund amount converted to
+// another currency: the exact rate applied, where it came from and how the result
+// was rounded.
+type Conversion struct {
+	ID                 int `json:"id"`
+	TransactionID      int `json:"transaction_id"`
+	TransactionVersion int `json:"transaction_version"`
+	// RefundID is the refund converted, zero when the transaction amount was.
+	RefundID int `json:"refund_id,omitempty"`
+	// TransactionDate is the date the rate was selected for: the purchase date,
+	// or the refund date for refunds converted at the rate of their own date.
+	TransactionDate string     `json:"transaction_date"`
+	Country         string     `json:"country"`
+	USDAmount       util.Money `json:"usd_amount"`
+	ExchangeRate    float64    `json:"exchange_rate"`
+	ConvertedAmount util.Money `json:"converted_amount"`
+	// Currency is the ISO 4217 code of the converted amount, empty when the country is not in the currency catalogue.
+	Currency string `json:"currency"`
+	Rounding string `json:"rounding"`
+	// RateSource identifies the exchange rate provider, e.g. "treasury".
+	RateSource string `json:"rate_source"`
+	// RateCurrency is the currency name of the source, e.g. "Dollar" for Treasury rates.
+	RateCurrency      string `json:"rate_currency"`
+	RateEffectiveDate string `json:"rate_effective_date"`
+	RateRecordDate    string `json:"rate_record_date"`
+	// Policy is the conversion policy the rate was selected with.
+	Policy ConversionPolicy `json:"policy"`
+	// RateMatch tells how the rate was found under the policy: exact_date,
+	// lookback, later or latest.
+	RateMatch string `json:"rate_match"`
+	// ConvertedAt is the time of the conversion, in RFC 3339 format (UTC).
+	ConvertedAt string `json:"converted_at"`
+}
+
+// ConversionPolicy is the audit record of the policy used to select the exchange rate of a conversion.
+type ConversionPolicy struct {
+	// Lookback is how long before the transaction date the rate could have become effective, e.g. "6m" or "90d".
+	Lookback         string `json:"lookback"`
+	AllowLaterRates  bool   `json:"allow_later_rates"`
+	ExactDate        bool   `json:"exact_date"`
+	FallbackToLatest bool   `json:"fallback_to_latest"`
+}
@@ -0,0 +1,13 @@
+package model
+
+// ExchangeRate is a Treasury Reporting Rates of Exchange record: the number of
+// units of a currency per US dollar, effective from EffectiveDate, published in
+// the quarterly report of RecordDate.
+type ExchangeRate struct {
+	Country             string  `json:"country"`
+	Currency            string  `json:"currency"`
+	CountryCurrencyDesc string  `json:"country_currency_desc"`
+	ExchangeRate        float64 `json:"exchange_rate"`
+	EffectiveDate       string  `json:"effective_date"`
+	RecordDate          string  `json:"record_date"`
+}
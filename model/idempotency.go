@@ -0,0 +1,29 @@
+package model
+
+// IdempotencyKey is a request made with an Idempotency-Key header and, once
+// completed, the response it got, so that retries of the request are answered
+// with the same response instead of being processed again.
+type IdempotencyKey struct {
+	Key string
+	// RequestHash identifies the request the key was first used with.
+	RequestHash string
+	// StatusCode is the status of the stored response, zero while the request is being processed.
+	StatusCode   int
+	ResponseBody []byte
+	ETag         string
+	// CreatedAt and ExpiresAt are in RFC 3339 format (UTC, whole seconds).
+	CreatedAt string
+	ExpiresAt string
+	// LockedUntil ends the lease of a request being processed: a key still
+	// without a response after it is treated as abandoned and may be claimed again.
+	LockedUntil string
+	// Token identifies the claim of the key: only the request holding it may
+	// complete or release the key, not one whose lease lapsed and was taken over.
+	// It is not returned with the record of a key claimed by another request.
+	Token string
+}
+
+// Completed reports whether the response of the request has been stored.
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
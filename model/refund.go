@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/util"
+)
+
+// Kinds of refunds.
+const (
+	// RefundKindRefund returns part or all of a purchase, e.g. a returned item.
+	RefundKindRefund = "refund"
+	// RefundKindReversal cancels what is left of a purchase, e.g. a voided charge.
+	RefundKindReversal = "reversal"
+)
+
+// Refund is money returned for a stored transaction. The refunds of a
+// transaction never total more than its amount.
+type Refund struct {
+	ID            int `json:"id"`
+	TransactionID int `json:"transaction_id"`
+	// Kind is RefundKindRefund or RefundKindReversal.
+	Kind       string     `json:"kind"`
+	Amount     util.Money `json:"amount"`
+	RefundDate string     `json:"refund_date"`
+	Reason     string     `json:"reason,omitempty"`
+	// CreatedAt is when the refund was recorded, in RFC 3339 format (UTC).
+	CreatedAt string `json:"created_at"`
+}
+
+// Validate checks the Refund fields for validity. It returns an error for
+// every invalid field, or nil when the refund is valid.
+func (r *Refund) Validate() ValidationErrors {
+	var errs ValidationErrors
+
+	if r.Kind != RefundKindRefund && r.Kind != RefundKindReversal {
+		errs = append(errs, FieldError{Field: "kind", Code: CodeInvalidFormat, Message: "Kind must be refund or reversal", RejectedValue: r.Kind})
+	}
+
+	if !r.Amount.IsPositive() {
+		errs = append(errs, FieldError{Field: "amount", Code: CodeNotPositive, Message: "Amount must be greater than 0", RejectedValue: r.Amount})
+	}
+
+	if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, r.RefundDate); err != nil {
+		errs = append(errs, FieldError{Field: "refund_date", Code: CodeInvalidFormat, Message: "Refund date must be in YYYY-MM-DD format", RejectedValue: r.RefundDate})
+	}
+
+	if len(r.Reason) > 200 {
+		errs = append(errs, FieldError{Field: "reason", Code: CodeTooLong, Message: "Reason must be 200 characters or fewer", RejectedValue: r.Reason})
+	}
+
+	return errs
+}
@@ -1,34 +1,136 @@
 package model
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/mvfavila/transactions/config"
 	"github.com/mvfavila/transactions/util"
 )
 
+// MaxTags is the largest number of tags of a transaction.
+const MaxTags = 10
+
+var (
+	// merchantCategoryCodePattern is the format of ISO 18245 merchant category codes, e.g. "5812".
+	merchantCategoryCodePattern = regexp.MustCompile(`^[0-9]{4}$`)
+	// tagPattern is the format of normalized tags.
+	tagPattern = regexp.MustCompile(`^[a-z0-9_-]{1,30}$`)
+)
+
 type Transaction struct {
-	ID              int     `json:"id"`
-	Description     string  `json:"description"`
-	Amount          float64 `json:"amount"`
-	TransactionDate string  `json:"transaction_date"`
+	ID              int        `json:"id"`
+	Description     string     `json:"description"`
+	Amount          util.Money `json:"amount"`
+	TransactionDate string     `json:"transaction_date"`
+	// MerchantName and MerchantCategoryCode (ISO 18245, e.g. "5812") describe
+	// where the purchase was made. Both are optional.
+	MerchantName         string `json:"merchant_name,omitempty"`
+	MerchantCategoryCode string `json:"merchant_category_code,omitempty"`
+	// Category is the code of the category of the category tree the purchase is
+	// budgeted under, empty when uncategorized.
+	Category string `json:"category,omitempty"`
+	// Tags are free-form labels, normalized by Normalize.
+	Tags    []string `json:"tags,omitempty"`
+	Version int      `json:"version"`
 }
 
-// Validate checks the Transaction fields for validity.
-func (t *Transaction) Validate() string {
-	if len(t.Description) > 50 {
-		return "Description must be 50 characters or fewer"
+// Normalize trims the merchant name and normalizes the tags with NormalizeTags.
+// It is applied before Validate.
+func (t *Transaction) Normalize() {
+	t.MerchantName = strings.TrimSpace(t.MerchantName)
+	t.Tags = NormalizeTags(t.Tags)
+}
+
+// NormalizeTags trims and lowercases the tags and returns them sorted, without
+// duplicates. It returns nil when there are none.
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(tag)))
 	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// Validate checks the Transaction fields for validity. It returns an error for
+// every invalid field, or nil when the transaction is valid.
+func (t *Transaction) Validate() ValidationErrors {
+	var errs ValidationErrors
 
-	if t.Amount <= 0 {
-		return "Amount must be greater than 0"
+	if len(t.Description) > 50 {
+		errs = append(errs, FieldError{Field: "description", Code: CodeTooLong, Message: "Description must be 50 characters or fewer", RejectedValue: t.Description})
 	}
 
-	t.Amount = util.RoundToCents(t.Amount)
+	if !t.Amount.IsPositive() {
+		errs = append(errs, FieldError{Field: "amount", Code: CodeNotPositive, Message: "Amount must be greater than 0", RejectedValue: t.Amount})
+	}
 
 	if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, t.TransactionDate); err != nil {
-		return "Transaction date must be in YYYY-MM-DD format"
+		errs = append(errs, FieldError{Field: "transaction_date", Code: CodeInvalidFormat, Message: "Transaction date must be in YYYY-MM-DD format", RejectedValue: t.TransactionDate})
+	}
+
+	if len(t.MerchantName) > 100 {
+		errs = append(errs, FieldError{Field: "merchant_name", Code: CodeTooLong, Message: "Merchant name must be 100 characters or fewer", RejectedValue: t.MerchantName})
+	}
+
+	if t.MerchantCategoryCode != "" && !merchantCategoryCodePattern.MatchString(t.MerchantCategoryCode) {
+		errs = append(errs, FieldError{Field: "merchant_category_code", Code: CodeInvalidFormat, Message: "Merchant category code must be 4 digits", RejectedValue: t.MerchantCategoryCode})
+	}
+
+	if t.Category != "" && !ValidCategoryCode(t.Category) {
+		errs = append(errs, FieldError{Field: "category", Code: CodeInvalidFormat, Message: "Category must be the code of a category", RejectedValue: t.Category})
+	}
+
+	if len(t.Tags) > MaxTags {
+		errs = append(errs, FieldError{Field: "tags", Code: CodeTooMany, Message: fmt.Sprintf("A transaction can have at most %d tags", MaxTags), RejectedValue: t.Tags})
+	}
+	for i, tag := range t.Tags {
+		if !tagPattern.MatchString(tag) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("tags[%d]", i), Code: CodeInvalidFormat, Message: "Tags must be 1 to 30 letters, digits, hyphens and underscores", RejectedValue: tag})
+		}
 	}
 
-	return ""
+	return errs
+}
+
+// TransactionPatch is a partial update of a Transaction. Nil fields are left unchanged.
+type TransactionPatch struct {
+	Description          *string     `json:"description"`
+	Amount               *util.Money `json:"amount"`
+	TransactionDate      *string     `json:"transaction_date"`
+	MerchantName         *string     `json:"merchant_name"`
+	MerchantCategoryCode *string     `json:"merchant_category_code"`
+	// Category is set to "" to remove the category.
+	Category *string `json:"category"`
+	// Tags replaces every tag; an empty list removes them.
+	Tags *[]string `json:"tags"`
+}
+
+// Apply copies the fields set in the patch onto the given transaction.
+func (p *TransactionPatch) Apply(t *Transaction) {
+	if p.Description != nil {
+		t.Description = *p.Description
+	}
+	if p.Amount != nil {
+		t.Amount = *p.Amount
+	}
+	if p.TransactionDate != nil {
+		t.TransactionDate = *p.TransactionDate
+	}
+	if p.MerchantName != nil {
+		t.MerchantName = *p.MerchantName
+	}
+	if p.MerchantCategoryCode != nil {
+		t.MerchantCategoryCode = *p.MerchantCategoryCode
+	}
+	if p.Category != nil {
+		t.Category = *p.Category
+	}
+	if p.Tags != nil {
+		t.Tags = *p.Tags
+	}
 }
@@ -1,70 +1,114 @@
 package model
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/util"
 )
 
 func TestTransactionValidate(t *testing.T) {
 	tests := []struct {
 		name           string
 		transaction    Transaction
-		expectedResult string
+		expectedResult ValidationErrors
 	}{
 		{
 			name: "Description longer than 50 characters",
 			transaction: Transaction{
 				Description:     "This is exactly 51 chars long. Oooooops, too long!!",
-				Amount:          1.00,
+				Amount:          util.USD(100),
 				TransactionDate: "2020-01-01",
 			},
-			expectedResult: "Description must be 50 characters or fewer",
+			expectedResult: ValidationErrors{{Field: "description", Code: CodeTooLong, Message: "Description must be 50 characters or fewer", RejectedValue: "This is exactly 51 chars long. Oooooops, too long!!"}},
 		},
 		{
 			name: "Amount less than 0",
 			transaction: Transaction{
 				Description:     "Test",
-				Amount:          -0.01,
+				Amount:          util.USD(-1),
 				TransactionDate: "2020-01-01",
 			},
-			expectedResult: "Amount must be greater than 0",
+			expectedResult: ValidationErrors{{Field: "amount", Code: CodeNotPositive, Message: "Amount must be greater than 0", RejectedValue: util.USD(-1)}},
 		},
 		{
 			name: "Amount equals to 0",
 			transaction: Transaction{
 				Description:     "Test",
-				Amount:          0.00,
+				Amount:          util.USD(0),
 				TransactionDate: "2020-01-01",
 			},
-			expectedResult: "Amount must be greater than 0",
+			expectedResult: ValidationErrors{{Field: "amount", Code: CodeNotPositive, Message: "Amount must be greater than 0", RejectedValue: util.USD(0)}},
 		},
 		{
 			name: "Invalid date format",
 			transaction: Transaction{
 				Description:     "Test",
-				Amount:          1.00,
+				Amount:          util.USD(100),
 				TransactionDate: "01-01-2020",
 			},
-			expectedResult: "Transaction date must be in YYYY-MM-DD format",
+			expectedResult: ValidationErrors{{Field: "transaction_date", Code: CodeInvalidFormat, Message: "Transaction date must be in YYYY-MM-DD format", RejectedValue: "01-01-2020"}},
 		},
 		{
-			name: "Transaction with rounding up amount",
+			name: "Every invalid field",
 			transaction: Transaction{
-				Description:     "Round up",
-				Amount:          1.006,
+				Description: "This is exactly 51 chars long. Oooooops, too long!!",
+				Amount:      util.USD(0),
+			},
+			expectedResult: ValidationErrors{
+				{Field: "description", Code: CodeTooLong, Message: "Description must be 50 characters or fewer", RejectedValue: "This is exactly 51 chars long. Oooooops, too long!!"},
+				{Field: "amount", Code: CodeNotPositive, Message: "Amount must be greater than 0", RejectedValue: util.USD(0)},
+				{Field: "transaction_date", Code: CodeInvalidFormat, Message: "Transaction date must be in YYYY-MM-DD format", RejectedValue: ""},
+			},
+		},
+		{
+			name: "Invalid metadata",
+			transaction: Transaction{
+				Description:          "Test",
+				Amount:               util.USD(100),
+				TransactionDate:      "2020-01-01",
+				MerchantCategoryCode: "58a2",
+				Category:             "Travel",
+				Tags:                 []string{"ok", "not ok"},
+			},
+			expectedResult: ValidationErrors{
+				{Field: "merchant_category_code", Code: CodeInvalidFormat, Message: "Merchant category code must be 4 digits", RejectedValue: "58a2"},
+				{Field: "category", Code: CodeInvalidFormat, Message: "Category must be the code of a category", RejectedValue: "Travel"},
+				{Field: "tags[1]", Code: CodeInvalidFormat, Message: "Tags must be 1 to 30 letters, digits, hyphens and underscores", RejectedValue: "not ok"},
+			},
+		},
+		{
+			name: "Too many tags",
+			transaction: Transaction{
+				Description:     "Test",
+				Amount:          util.USD(100),
 				TransactionDate: "2020-01-01",
+				Tags:            []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"},
 			},
-			expectedResult: "",
+			expectedResult: ValidationErrors{{Field: "tags", Code: CodeTooMany, Message: "A transaction can have at most 10 tags", RejectedValue: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}}},
 		},
 		{
-			name: "Valid transaction",
+			name: "Transaction with large amount",
 			transaction: Transaction{
-				Description:     "This is exactly 50 characters long. Juuuust right.",
-				Amount:          0.01,
+				Description:     "Large",
+				Amount:          util.USD(9007199254740993),
 				TransactionDate: "2020-01-01",
 			},
-			expectedResult: "",
+			expectedResult: nil,
+		},
+		{
+			name: "Valid transaction",
+			transaction: Transaction{
+				Description:          "This is exactly 50 characters long. Juuuust right.",
+				Amount:               util.USD(1),
+				TransactionDate:      "2020-01-01",
+				MerchantName:         "Corner Market",
+				MerchantCategoryCode: "5411",
+				Category:             "food-groceries",
+				Tags:                 []string{"home", "weekly_shop"},
+			},
+			expectedResult: nil,
 		},
 	}
 
@@ -74,9 +118,39 @@ func TestTransactionValidate(t *testing.T) {
 			config.LoadDefaultConfig()
 
 			result := tt.transaction.Validate()
-			if result != tt.expectedResult {
+			if !reflect.DeepEqual(result, tt.expectedResult) {
 				t.Errorf("Validate() = %v, want %v", result, tt.expectedResult)
 			}
 		})
 	}
 }
+
+func TestTransactionPatchApply(t *testing.T) {
+	description := "Edited"
+	amount := util.USD(250)
+
+	transaction := Transaction{ID: 1, Description: "Original", Amount: util.USD(100), TransactionDate: "2020-01-01", Version: 3}
+	patch := TransactionPatch{Description: &description, Amount: &amount}
+	patch.Apply(&transaction)
+
+	expected := Transaction{ID: 1, Description: "Edited", Amount: util.USD(250), TransactionDate: "2020-01-01", Version: 3}
+	if !reflect.DeepEqual(transaction, expected) {
+		t.Errorf("Apply() = %+v, want %+v", transaction, expected)
+	}
+}
+
+func TestTransactionNormalize(t *testing.T) {
+	transaction := Transaction{MerchantName: "  Corner Market ", Tags: []string{" Weekly", "home", "weekly"}}
+	transaction.Normalize()
+
+	expected := Transaction{MerchantName: "Corner Market", Tags: []string{"home", "weekly"}}
+	if !reflect.DeepEqual(transaction, expected) {
+		t.Errorf("Normalize() = %+v, want %+v", transaction, expected)
+	}
+
+	transaction.Tags = []string{}
+	transaction.Normalize()
+	if transaction.Tags != nil {
+		t.Errorf("Normalize() tags = %#v, want nil", transaction.Tags)
+	}
+}
@@ -0,0 +1,48 @@
+package model
+
+import "strings"
+
+// Field error codes, stable identifiers of why a field is invalid.
+const (
+	CodeTooLong       = "too_long"
+	CodeNotPositive   = "not_positive"
+	CodeInvalidFormat = "invalid_format"
+	CodeInvalidType   = "invalid_type"
+	CodeInvalidLength = "invalid_length"
+	CodeTooMany       = "too_many"
+	// CodeUnknownCategory refuses a category missing from the category tree.
+	CodeUnknownCategory = "unknown_category"
+	// CodeBeforePurchase refuses a refund dated before the purchase it refunds.
+	CodeBeforePurchase = "before_purchase"
+	// CodeBelowRefunded refuses a transaction amount lower than its refunds.
+	CodeBelowRefunded = "below_refunded"
+	// CodeAfterRefund refuses a transaction date later than one of its refunds.
+	CodeAfterRefund = "after_refund"
+	// Codes of fields refused while decoding a JSON body.
+	CodeUnknownField    = "unknown_field"
+	CodeDuplicateKey    = "duplicate_key"
+	CodeNotFinite       = "not_finite"
+	CodeTooManyDecimals = "too_many_decimals"
+)
+
+// FieldError describes why a field of a request is invalid.
+type FieldError struct {
+	// Field is the path of the field in the request body, e.g. "amount".
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RejectedValue is the value of the field that was refused.
+	RejectedValue any `json:"rejected_value"`
+}
+
+// ValidationErrors lists every invalid field of a request. A nil or empty list means the request is valid.
+type ValidationErrors []FieldError
+
+// Error joins the messages of the field errors.
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
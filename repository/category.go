@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var (
+	// ErrCategoryNotFound is returned when the requested category does not exist.
+	ErrCategoryNotFound = errors.New("category not found")
+	// ErrCategoryExists is returned when a category is created with a code already in use.
+	ErrCategoryExists = errors.New("category already exists")
+)
+
+// CategoryRepository stores the category tree transactions are budgeted under.
+// Categories are identified by their code and only ever added, so the tree
+// cannot have cycles.
+type CategoryRepository interface {
+	// Create stores a new category. It returns ErrCategoryExists when the code is
+	// in use and ErrCategoryNotFound when the parent does not exist.
+	Create(ctx context.Context, category *model.Category) error
+	// Get returns the category with the given code or ErrCategoryNotFound.
+	Get(ctx context.Context, code string) (*model.Category, error)
+	// List returns every category, ordered by code.
+	List(ctx context.Context) ([]model.Category, error)
+	// Subtree returns the code of the category followed by the codes of all its
+	// descendants, or ErrCategoryNotFound.
+	Subtree(ctx context.Context, code string) ([]string, error)
+}
+
+var _ CategoryRepository = (*SQLiteCategoryRepository)(nil)
+
+// SQLiteCategoryRepository is a CategoryRepository backed by the categories table.
+type SQLiteCategoryRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteCategoryRepository creates a repository using the given database connection.
+func NewSQLiteCategoryRepository(db *sql.DB) *SQLiteCategoryRepository {
+	return &SQLiteCategoryRepository{db: db}
+}
+
+// Create inserts the category after checking that its parent exists.
+func (r *SQLiteCategoryRepository) Create(ctx context.Context, category *model.Category) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.Parent != "" {
+		var count int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM categories WHERE code = ?", category.Parent).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrCategoryNotFound
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO categories (code, name, parent_code) VALUES (?, ?, NULLIF(?, '')) ON CONFLICT (code) DO NOTHING",
+		category.Code, category.Name, category.Parent)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrCategoryExists
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the category with the given code.
+func (r *SQLiteCategoryRepository) Get(ctx context.Context, code string) (*model.Category, error) {
+	var category model.Category
+	err := r.db.QueryRowContext(ctx, "SELECT code, name, COALESCE(parent_code, '') FROM categories WHERE code = ?", code).
+		Scan(&category.Code, &category.Name, &category.Parent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// List returns every category.
+func (r *SQLiteCategoryRepository) List(ctx context.Context) ([]model.Category, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT code, name, COALESCE(parent_code, '') FROM categories ORDER BY code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []model.Category{}
+	for rows.Next() {
+		var category model.Category
+		if err := rows.Scan(&category.Code, &category.Name, &category.Parent); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// Subtree walks the descendants of the category with a recursive query.
+func (r *SQLiteCategoryRepository) Subtree(ctx context.Context, code string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE subtree (code, depth) AS (
+			SELECT code, 0 FROM categories WHERE code = ?
+			UNION ALL
+			SELECT categories.code, subtree.depth + 1 FROM categories JOIN subtree ON categories.parent_code = subtree.code
+		)
+		SELECT code FROM subtree ORDER BY depth, code`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, ErrCategoryNotFound
+	}
+
+	return codes, nil
+}
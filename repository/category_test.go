@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+func TestCategoryRepository(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	ApplyMigrations(db)
+
+	repositories := map[string]CategoryRepository{
+		"sqlite":    NewSQLiteCategoryRepository(db),
+		"in-memory": NewInMemoryCategoryRepository(),
+	}
+
+	for name, repo := range repositories {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			categories := []model.Category{
+				{Code: "food", Name: "Food"},
+				{Code: "food-restaurants", Name: "Restaurants", Parent: "food"},
+				{Code: "food-groceries", Name: "Groceries", Parent: "food"},
+				{Code: "food-restaurants-fast", Name: "Fast food", Parent: "food-restaurants"},
+				{Code: "travel", Name: "Travel"},
+			}
+			for _, category := range categories {
+				require.NoError(t, repo.Create(ctx, &category))
+			}
+
+			assert.ErrorIs(t, repo.Create(ctx, &model.Category{Code: "food", Name: "Again"}), ErrCategoryExists)
+			assert.ErrorIs(t, repo.Create(ctx, &model.Category{Code: "pets", Name: "Pets", Parent: "animals"}), ErrCategoryNotFound)
+
+			got, err := repo.Get(ctx, "food-groceries")
+			require.NoError(t, err)
+			assert.Equal(t, categories[2], *got)
+			_, err = repo.Get(ctx, "pets")
+			assert.ErrorIs(t, err, ErrCategoryNotFound)
+
+			list, err := repo.List(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []model.Category{categories[0], categories[2], categories[1], categories[3], categories[4]}, list)
+
+			subtree, err := repo.Subtree(ctx, "food")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"food", "food-groceries", "food-restaurants", "food-restaurants-fast"}, subtree)
+			subtree, err = repo.Subtree(ctx, "travel")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"travel"}, subtree)
+			_, err = repo.Subtree(ctx, "pets")
+			assert.ErrorIs(t, err, ErrCategoryNotFound)
+		})
+	}
+}
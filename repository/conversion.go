@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ConversionRepository stores the audit trail of currency conversions.
+// Conversions are only ever appended.
+type ConversionRepository interface {
+	// Record stores the conversion and sets its ID.
+	Record(ctx context.Context, conversion *model.Conversion) error
+	// ListByTransaction returns the conversions of a transaction and of its
+	// refunds, oldest first.
+	ListByTransaction(ctx context.Context, transactionID int) ([]model.Conversion, error)
+}
+
+var _ ConversionRepository = (*SQLiteConversionRepository)(nil)
+
+// SQLiteConversionRepository is a ConversionRepository backed by the conversions table.
+type SQLiteConversionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteConversionRepository creates a repository using the given database connection.
+func NewSQLiteConversionRepository(db *sql.DB) *SQLiteConversionRepository {
+	return &SQLiteConversionRepository{db: db}
+}
+
+// Record inserts the conversion.
+func (r *SQLiteConversionRepository) Record(ctx context.Context, conversion *model.Conversion) error {
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO conversions (transaction_id, transaction_version, refund_id, transaction_date, country,
+			usd_amount_minor, exchange_rate, converted_amount_minor, currency, scale, rounding,
+			rate_source, rate_currency, rate_effective_date, rate_record_date, lookback, allow_later_rates,
+			exact_date, fallback_to_latest, rate_match, converted_at)
+		VALUES (?, ?, NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		conversion.TransactionID, conversion.TransactionVersion, conversion.RefundID, conversion.TransactionDate, conversion.Country,
+		conversion.USDAmount.Amount, conversion.ExchangeRate, conversion.ConvertedAmount.Amount, conversion.Currency,
+		conversion.ConvertedAmount.Scale(), conversion.Rounding, conversion.RateSource, conversion.RateCurrency,
+		conversion.RateEffectiveDate, conversion.RateRecordDate, conversion.Policy.Lookback, conversion.Policy.AllowLaterRates,
+		conversion.Policy.ExactDate, conversion.Policy.FallbackToLatest, conversion.RateMatch, conversion.ConvertedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	conversion.ID = int(id)
+	return nil
+}
+
+// ListByTransaction returns the conversions of the transaction.
+func (r *SQLiteConversionRepository) ListByTransaction(ctx context.Context, transactionID int) ([]model.Conversion, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, transaction_id, transaction_version, COALESCE(refund_id, 0), transaction_date, country,
+			usd_amount_minor, exchange_rate, converted_amount_minor, currency, rounding,
+			rate_source, rate_currency, rate_effective_date, rate_record_date, lookback, allow_later_rates,
+			exact_date, fallback_to_latest, rate_match, converted_at
+		FROM conversions WHERE transaction_id = ? ORDER BY id`, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversions := []model.Conversion{}
+	for rows.Next() {
+		var conversion model.Conversion
+		err := rows.Scan(&conversion.ID, &conversion.TransactionID, &conversion.TransactionVersion, &conversion.RefundID, &conversion.TransactionDate,
+			&conversion.Country, &conversion.USDAmount.Amount, &conversion.ExchangeRate, &conversion.ConvertedAmount.Amount,
+			&conversion.Currency, &conversion.Rounding, &conversion.RateSource, &conversion.RateCurrency,
+			&conversion.RateEffectiveDate, &conversion.RateRecordDate, &conversion.Policy.Lookback, &conversion.Policy.AllowLaterRates,
+			&conversion.Policy.ExactDate, &conversion.Policy.FallbackToLatest, &conversion.RateMatch, &conversion.ConvertedAt)
+		if err != nil {
+			return nil, err
+		}
+		conversion.USDAmount.Currency = util.USDCurrency
+		conversion.ConvertedAmount.Currency = conversion.Currency
+		conversions = append(conversions, conversion)
+	}
+
+	return conversions, rows.Err()
+}
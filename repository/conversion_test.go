@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestConversionRepository(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	ApplyMigrations(db)
+
+	repositories := map[string]ConversionRepository{
+		"sqlite":    NewSQLiteConversionRepository(db),
+		"in-memory": NewInMemoryConversionRepository(),
+	}
+
+	for name, repo := range repositories {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			yen := &model.Conversion{
+				TransactionID:      1,
+				TransactionVersion: 2,
+				TransactionDate:    "2025-01-13",
+				Country:            "Japan",
+				USDAmount:          util.USD(1234),
+				ExchangeRate:       149.55,
+				ConvertedAmount:    util.Money{Amount: 1845, Currency: "JPY"},
+				Currency:           "JPY",
+				Rounding:           "half_up",
+				RateSource:         "treasury",
+				RateCurrency:       "Yen",
+				RateEffectiveDate:  "2024-12-31",
+				RateRecordDate:     "2024-12-31",
+				Policy:             model.ConversionPolicy{Lookback: "90d", AllowLaterRates: true, FallbackToLatest: true},
+				RateMatch:          "later",
+				ConvertedAt:        "2025-02-01T10:00:00Z",
+			}
+			other := &model.Conversion{TransactionID: 2, USDAmount: util.USD(1), ConvertedAmount: util.Money{Amount: 1}}
+			// A refund of the same transaction, converted at the rate of its own date
+			euro := *yen
+			euro.RefundID, euro.TransactionDate = 1, "2025-01-20"
+			euro.Country, euro.Currency, euro.ExchangeRate = "Euro Zone", "EUR", 0.96
+			euro.ConvertedAmount = util.Money{Amount: 1185, Currency: "EUR"}
+
+			for _, conversion := range []*model.Conversion{yen, other, &euro} {
+				require.NoError(t, repo.Record(ctx, conversion))
+			}
+			assert.Equal(t, []int{1, 2, 3}, []int{yen.ID, other.ID, euro.ID})
+
+			conversions, err := repo.ListByTransaction(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, []model.Conversion{*yen, euro}, conversions)
+
+			conversions, err = repo.ListByTransaction(ctx, 3)
+			require.NoError(t, err)
+			assert.Empty(t, conversions)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or does
+// not match the requested sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortField is a column transactions can be listed by.
+type SortField string
+
+const (
+	SortByID     SortField = "id"
+	SortByDate   SortField = "transaction_date"
+	SortByAmount SortField = "amount"
+)
+
+// IsValid reports whether the sort field is supported.
+func (f SortField) IsValid() bool {
+	switch f {
+	case SortByID, SortByDate, SortByAmount:
+		return true
+	}
+	return false
+}
+
+// Cursor identifies the last transaction of a page, so that the next page starts
+// right after it (keyset pagination). It records the sort order it was created
+// for, so that it cannot be reused with a different one, and Filter, a hash of
+// the sort order and filters of the listing set by the caller, so that callers
+// can refuse it for a listing with other filters.
+type Cursor struct {
+	SortBy     SortField `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	ID         int       `json:"i"`
+	Date       string    `json:"t,omitempty"`
+	Amount     int64     `json:"a,omitempty"`
+	Filter     string    `json:"f,omitempty"`
+}
+
+// cursorFor returns the cursor positioned on the given transaction.
+func cursorFor(transaction model.Transaction, sortBy SortField, descending bool) *Cursor {
+	cursor := &Cursor{SortBy: sortBy, Descending: descending, ID: transaction.ID}
+	switch sortBy {
+	case SortByDate:
+		cursor.Date = transaction.TransactionDate
+	case SortByAmount:
+		cursor.Amount = transaction.Amount.Amount
+	}
+	return cursor
+}
+
+// Encode returns the opaque string representation of the cursor.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by Cursor.Encode.
+func DecodeCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || !cursor.SortBy.IsValid() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
@@ -9,8 +9,16 @@ import (
 	"github.com/mvfavila/transactions/util"
 )
 
-// InitializeDB initializes the database.
+// InitializeDB opens the database and applies any pending migrations.
 func InitializeDB(driver string, source string) *sql.DB {
+	db := OpenDB(driver, source)
+	ApplyMigrations(db)
+	return db
+}
+
+// OpenDB opens the database, creating the database file if needed. It does not
+// apply migrations.
+func OpenDB(driver string, source string) *sql.DB {
 	if _, err := os.Stat(source); os.IsNotExist(err) {
 		file, err := os.Create(source)
 		if err != nil {
@@ -24,23 +32,13 @@ func InitializeDB(driver string, source string) *sql.DB {
 		util.ErrorLogger.Fatalf("Failed to connect to SQLite: %v", err)
 	}
 
-	ApplyMigrations(db)
 	return db
 }
 
-// ApplyMigrations applies the necessary database migrations to the given
+// ApplyMigrations applies every pending schema migration to the given
 // database connection.
 func ApplyMigrations(db *sql.DB) {
-	migration := `
-		CREATE TABLE IF NOT EXISTS transactions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			description TEXT NOT NULL CHECK(length(description) <= 50),
-			amount DECIMAL(10, 2) NOT NULL,
-			transaction_date TEXT NOT NULL
-		);
-	`
-
-	if _, err := db.Exec(migration); err != nil {
+	if err := MigrateUp(db); err != nil {
 		util.ErrorLogger.Fatalf("Failed to apply migrations: %v", err)
 	}
 }
@@ -24,3 +24,36 @@ func TestApplyMigrations(t *testing.T) {
 
 	assert.Equal(t, "transactions", result)
 }
+
+func TestApplyMigrationsConvertsLegacyAmounts(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to connect to SQLite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	legacy := `
+		CREATE TABLE transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			description TEXT NOT NULL CHECK(length(description) <= 50),
+			amount DECIMAL(10, 2) NOT NULL,
+			transaction_date TEXT NOT NULL
+		);
+		INSERT INTO transactions (description, amount, transaction_date) VALUES ('Test', 12.34, '2020-01-01');
+	`
+	if _, err := db.Exec(legacy); err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+
+	ApplyMigrations(db)
+
+	var amount int64
+	var currency string
+	err = db.QueryRow("SELECT amount_minor, currency FROM transactions WHERE id = 1").Scan(&amount, &currency)
+	if err != nil {
+		t.Fatalf("Failed to query the database: %v", err)
+	}
+
+	assert.Equal(t, int64(1234), amount)
+	assert.Equal(t, "USD", currency)
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+// ErrClaimLost is returned when a claim cannot be completed because the key is
+// no longer held by it: its lease lapsed and it was taken over, or it expired.
+var ErrClaimLost = errors.New("idempotency key claim lost")
+
+// IdempotencyRepository stores the Idempotency-Key of requests and their responses.
+//
+// Keys expire: an expired key is treated as unused and may be claimed again.
+// Timestamps are compared as RFC 3339 strings in UTC with whole seconds.
+type IdempotencyRepository interface {
+	// Claim stores the key, without a response, unless an unexpired record
+	// with the same key exists, in which case it returns that record and
+	// stores nothing. A record whose request lease (LockedUntil) lapsed without
+	// a response is replaced. It returns nil when the key was claimed.
+	Claim(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error)
+	// Complete stores the response of the key claimed with the token, or
+	// returns ErrClaimLost when the key is no longer held by that claim.
+	Complete(ctx context.Context, key string, token string, statusCode int, body []byte, etag string) error
+	// Release removes the key claimed with the token when its request failed,
+	// so it can be retried. Keys held by another claim are left untouched.
+	Release(ctx context.Context, key string, token string) error
+	// DeleteExpired removes the keys expired at the given time and returns how many there were.
+	DeleteExpired(ctx context.Context, now string) (int64, error)
+}
+
+var _ IdempotencyRepository = (*SQLiteIdempotencyRepository)(nil)
+
+// SQLiteIdempotencyRepository is an IdempotencyRepository backed by the idempotency_keys table.
+type SQLiteIdempotencyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteIdempotencyRepository creates a repository using the given database connection.
+func NewSQLiteIdempotencyRepository(db *sql.DB) *SQLiteIdempotencyRepository {
+	return &SQLiteIdempotencyRepository{db: db}
+}
+
+// Claim inserts the key, or replaces an expired or abandoned record with the
+// same key, in a single statement so concurrent claims of a key cannot both succeed.
+func (r *SQLiteIdempotencyRepository) Claim(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, status_code, response_body, etag, created_at, expires_at, locked_until, claim_token)
+		VALUES (?, ?, 0, NULL, '', ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			request_hash = excluded.request_hash,
+			status_code = 0,
+			response_body = NULL,
+			etag = '',
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at,
+			locked_until = excluded.locked_until,
+			claim_token = excluded.claim_token
+		WHERE idempotency_keys.expires_at <= excluded.created_at
+			OR (idempotency_keys.status_code = 0 AND idempotency_keys.locked_until <= excluded.created_at)`,
+		key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt, key.LockedUntil, key.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if claimed == 1 {
+		return nil, nil
+	}
+
+	var existing model.IdempotencyKey
+	err = r.db.QueryRowContext(ctx, `
+		SELECT key, request_hash, status_code, response_body, etag, created_at, expires_at, locked_until
+		FROM idempotency_keys WHERE key = ?`, key.Key).
+		Scan(&existing.Key, &existing.RequestHash, &existing.StatusCode, &existing.ResponseBody, &existing.ETag, &existing.CreatedAt, &existing.ExpiresAt, &existing.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released in the meantime: claim it again.
+		return r.Claim(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// Complete stores the response of the key if it is still held by the claim.
+func (r *SQLiteIdempotencyRepository) Complete(ctx context.Context, key string, token string, statusCode int, body []byte, etag string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status_code = ?, response_body = ?, etag = ?
+		WHERE key = ? AND claim_token = ? AND status_code = 0`,
+		statusCode, body, etag, key, token)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+// Release deletes the key if it is still held by the claim and its response
+// has not been stored.
+func (r *SQLiteIdempotencyRepository) Release(ctx context.Context, key string, token string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = ? AND claim_token = ? AND status_code = 0", key, token)
+	return err
+}
+
+// DeleteExpired deletes the keys expired at the given time.
+func (r *SQLiteIdempotencyRepository) DeleteExpired(ctx context.Context, now string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
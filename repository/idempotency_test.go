@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+func TestIdempotencyRepository(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	ApplyMigrations(db)
+
+	repositories := map[string]IdempotencyRepository{
+		"sqlite":    NewSQLiteIdempotencyRepository(db),
+		"in-memory": NewInMemoryIdempotencyRepository(),
+	}
+
+	for name, repo := range repositories {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := &model.IdempotencyKey{Key: "abc", RequestHash: "hash", CreatedAt: "2025-01-01T10:00:00Z", ExpiresAt: "2025-01-02T10:00:00Z", LockedUntil: "2025-01-01T10:01:00Z", Token: "first"}
+
+			existing, err := repo.Claim(ctx, key)
+			require.NoError(t, err)
+			assert.Nil(t, existing)
+
+			// A claimed key is returned while its request is being processed
+			retry := *key
+			retry.CreatedAt = "2025-01-01T10:00:05Z"
+			existing, err = repo.Claim(ctx, &retry)
+			require.NoError(t, err)
+			require.NotNil(t, existing)
+			assert.False(t, existing.Completed())
+			assert.Equal(t, "2025-01-01T10:01:00Z", existing.LockedUntil)
+
+			// A key abandoned past its lease is taken over
+			takeover := model.IdempotencyKey{Key: "abc", RequestHash: "hash", CreatedAt: "2025-01-01T10:01:00Z", ExpiresAt: "2025-01-02T10:01:00Z", LockedUntil: "2025-01-01T10:02:00Z", Token: "second"}
+			existing, err = repo.Claim(ctx, &takeover)
+			require.NoError(t, err)
+			assert.Nil(t, existing)
+			existing, err = repo.Claim(ctx, &retry)
+			require.NoError(t, err)
+			require.NotNil(t, existing)
+			assert.Equal(t, "2025-01-01T10:02:00Z", existing.LockedUntil)
+
+			// The abandoned claim can neither release nor complete the key taken over
+			require.NoError(t, repo.Release(ctx, "abc", "first"))
+			assert.ErrorIs(t, repo.Complete(ctx, "abc", "first", 201, []byte(`{"id":2}`), `"1"`), ErrClaimLost)
+			existing, err = repo.Claim(ctx, &retry)
+			require.NoError(t, err)
+			require.NotNil(t, existing)
+			assert.False(t, existing.Completed())
+
+			// And with its response once completed
+			require.NoError(t, repo.Complete(ctx, "abc", "second", 201, []byte(`{"id":1}`), `"1"`))
+			assert.ErrorIs(t, repo.Complete(ctx, "abc", "second", 201, []byte(`{"id":2}`), `"1"`), ErrClaimLost)
+			existing, err = repo.Claim(ctx, &retry)
+			require.NoError(t, err)
+			require.NotNil(t, existing)
+			assert.Equal(t, model.IdempotencyKey{
+				Key:          "abc",
+				RequestHash:  "hash",
+				StatusCode:   201,
+				ResponseBody: []byte(`{"id":1}`),
+				ETag:         `"1"`,
+				CreatedAt:    "2025-01-01T10:01:00Z",
+				ExpiresAt:    "2025-01-02T10:01:00Z",
+				LockedUntil:  "2025-01-01T10:02:00Z",
+			}, *existing)
+
+			// Completed keys are not released
+			require.NoError(t, repo.Release(ctx, "abc", "second"))
+			existing, err = repo.Claim(ctx, &retry)
+			require.NoError(t, err)
+			assert.NotNil(t, existing)
+
+			// An expired key can be claimed again
+			later := model.IdempotencyKey{Key: "abc", RequestHash: "other", CreatedAt: "2025-01-02T10:01:00Z", ExpiresAt: "2025-01-03T10:01:00Z", LockedUntil: "2025-01-02T10:02:00Z", Token: "third"}
+			existing, err = repo.Claim(ctx, &later)
+			require.NoError(t, err)
+			assert.Nil(t, existing)
+
+			// A released key can be claimed again
+			require.NoError(t, repo.Release(ctx, "abc", "third"))
+			existing, err = repo.Claim(ctx, &later)
+			require.NoError(t, err)
+			assert.Nil(t, existing)
+
+			assert.ErrorIs(t, repo.Complete(ctx, "missing", "third", 201, nil, ""), ErrClaimLost)
+
+			deleted, err := repo.DeleteExpired(ctx, "2025-01-02T10:00:00Z")
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), deleted)
+			deleted, err = repo.DeleteExpired(ctx, "2025-01-03T10:01:00Z")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), deleted)
+		})
+	}
+}
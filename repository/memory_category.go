@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var _ CategoryRepository = (*InMemoryCategoryRepository)(nil)
+
+// InMemoryCategoryRepository is a CategoryRepository keeping the categories in memory.
+type InMemoryCategoryRepository struct {
+	mu         sync.RWMutex
+	categories map[string]model.Category
+}
+
+// NewInMemoryCategoryRepository creates an empty repository.
+func NewInMemoryCategoryRepository() *InMemoryCategoryRepository {
+	return &InMemoryCategoryRepository{categories: map[string]model.Category{}}
+}
+
+// Create stores the category after checking that its parent exists.
+func (r *InMemoryCategoryRepository) Create(_ context.Context, category *model.Category) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.categories[category.Parent]; category.Parent != "" && !ok {
+		return ErrCategoryNotFound
+	}
+	if _, ok := r.categories[category.Code]; ok {
+		return ErrCategoryExists
+	}
+
+	r.categories[category.Code] = *category
+	return nil
+}
+
+// Get returns the category with the given code.
+func (r *InMemoryCategoryRepository) Get(_ context.Context, code string) (*model.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	category, ok := r.categories[code]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+
+	return &category, nil
+}
+
+// List returns every category.
+func (r *InMemoryCategoryRepository) List(_ context.Context) ([]model.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	categories := []model.Category{}
+	for _, category := range r.categories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Code < categories[j].Code })
+
+	return categories, nil
+}
+
+// Subtree returns the category and its descendants, level by level.
+func (r *InMemoryCategoryRepository) Subtree(_ context.Context, code string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.categories[code]; !ok {
+		return nil, ErrCategoryNotFound
+	}
+
+	codes := []string{code}
+	for level := codes; len(level) > 0; {
+		var next []string
+		for _, parent := range level {
+			for _, category := range r.categories {
+				if category.Parent == parent {
+					next = append(next, category.Code)
+				}
+			}
+		}
+		sort.Strings(next)
+		codes = append(codes, next...)
+		level = next
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var _ ConversionRepository = (*InMemoryConversionRepository)(nil)
+
+// InMemoryConversionRepository is a ConversionRepository keeping the conversions in memory.
+type InMemoryConversionRepository struct {
+	mu          sync.RWMutex
+	conversions []model.Conversion
+}
+
+// NewInMemoryConversionRepository creates an empty repository.
+func NewInMemoryConversionRepository() *InMemoryConversionRepository {
+	return &InMemoryConversionRepository{}
+}
+
+// Record appends the conversion.
+func (r *InMemoryConversionRepository) Record(_ context.Context, conversion *model.Conversion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conversion.ID = len(r.conversions) + 1
+	r.conversions = append(r.conversions, *conversion)
+	return nil
+}
+
+// ListByTransaction returns the conversions of the transaction.
+func (r *InMemoryConversionRepository) ListByTransaction(_ context.Context, transactionID int) ([]model.Conversion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conversions := []model.Conversion{}
+	for _, conversion := range r.conversions {
+		if conversion.TransactionID == transactionID {
+			conversions = append(conversions, conversion)
+		}
+	}
+	return conversions, nil
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var _ IdempotencyRepository = (*InMemoryIdempotencyRepository)(nil)
+
+// InMemoryIdempotencyRepository is an IdempotencyRepository keeping the keys in memory.
+type InMemoryIdempotencyRepository struct {
+	mu   sync.Mutex
+	keys map[string]model.IdempotencyKey
+}
+
+// NewInMemoryIdempotencyRepository creates an empty repository.
+func NewInMemoryIdempotencyRepository() *InMemoryIdempotencyRepository {
+	return &InMemoryIdempotencyRepository{keys: map[string]model.IdempotencyKey{}}
+}
+
+// Claim stores the key unless an unexpired record with the same key exists
+// that is completed or still within its lease.
+func (r *InMemoryIdempotencyRepository) Claim(_ context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.keys[key.Key]; ok && existing.ExpiresAt > key.CreatedAt &&
+		(existing.Completed() || existing.LockedUntil > key.CreatedAt) {
+		// The token stays with the request holding the claim
+		existing.Token = ""
+		return &existing, nil
+	}
+
+	r.keys[key.Key] = model.IdempotencyKey{
+		Key:         key.Key,
+		RequestHash: key.RequestHash,
+		CreatedAt:   key.CreatedAt,
+		ExpiresAt:   key.ExpiresAt,
+		LockedUntil: key.LockedUntil,
+		Token:       key.Token,
+	}
+	return nil, nil
+}
+
+// Complete stores the response of the key if it is still held by the claim.
+func (r *InMemoryIdempotencyRepository) Complete(_ context.Context, key string, token string, statusCode int, body []byte, etag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.keys[key]
+	if !ok || record.Token != token || record.Completed() {
+		return ErrClaimLost
+	}
+	record.StatusCode, record.ResponseBody, record.ETag = statusCode, body, etag
+	r.keys[key] = record
+	return nil
+}
+
+// Release deletes the key if it is still held by the claim and its response
+// has not been stored.
+func (r *InMemoryIdempotencyRepository) Release(_ context.Context, key string, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record, ok := r.keys[key]; ok && record.Token == token && !record.Completed() {
+		delete(r.keys, key)
+	}
+	return nil
+}
+
+// DeleteExpired deletes the keys expired at the given time.
+func (r *InMemoryIdempotencyRepository) DeleteExpired(_ context.Context, now string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, record := range r.keys {
+		if record.ExpiresAt <= now {
+			delete(r.keys, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var _ RefundRepository = (*InMemoryRefundRepository)(nil)
+
+// InMemoryRefundRepository is a RefundRepository keeping the refunds in memory.
+// The refunds are kept by the InMemoryTransactionRepository of the refunded
+// transactions, under its lock.
+type InMemoryRefundRepository struct {
+	transactions *InMemoryTransactionRepository
+}
+
+// NewInMemoryRefundRepository creates an empty repository refunding the transactions of the given repository.
+func NewInMemoryRefundRepository(transactions *InMemoryTransactionRepository) *InMemoryRefundRepository {
+	return &InMemoryRefundRepository{transactions: transactions}
+}
+
+// Create appends the refund after checking the transaction date and the refunds
+// already stored.
+func (r *InMemoryRefundRepository) Create(_ context.Context, refund *model.Refund) error {
+	t := r.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	transaction, ok := t.transactions[refund.TransactionID]
+	if !ok || t.deleted[refund.TransactionID] {
+		return ErrNotFound
+	}
+	if refund.RefundDate < transaction.TransactionDate {
+		return ErrRefundBeforePurchase
+	}
+	if t.refunded(refund.TransactionID)+refund.Amount.Amount > transaction.Amount.Amount {
+		return ErrRefundExceedsAmount
+	}
+
+	refund.ID = len(t.refunds) + 1
+	t.refunds = append(t.refunds, *refund)
+	return nil
+}
+
+// Get returns the refund of the transaction with the given ID.
+func (r *InMemoryRefundRepository) Get(_ context.Context, transactionID int, id int) (*model.Refund, error) {
+	t := r.transactions
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if id < 1 || id > len(t.refunds) || t.refunds[id-1].TransactionID != transactionID {
+		return nil, ErrRefundNotFound
+	}
+
+	refund := t.refunds[id-1]
+	return &refund, nil
+}
+
+// ListByTransaction returns the refunds of the transaction.
+func (r *InMemoryRefundRepository) ListByTransaction(_ context.Context, transactionID int) ([]model.Refund, error) {
+	t := r.transactions
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	refunds := []model.Refund{}
+	for _, refund := range t.refunds {
+		if refund.TransactionID == transactionID {
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
+
+// Totals sums the refunds of the transactions.
+func (r *InMemoryRefundRepository) Totals(_ context.Context, transactionIDs []int) (map[int]int64, error) {
+	t := r.transactions
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	totals := map[int]int64{}
+	for _, id := range transactionIDs {
+		if total := t.refunded(id); total > 0 {
+			totals[id] = total
+		}
+	}
+	return totals, nil
+}
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var _ TransactionRepository = (*InMemoryTransactionRepository)(nil)
+
+// InMemoryTransactionRepository is a TransactionRepository that keeps transactions
+// in memory. It is safe for concurrent use and is mainly intended for tests.
+//
+// It also keeps the refunds of an InMemoryRefundRepository, under the same
+// lock, so that updates and refunds are checked against each other atomically.
+type InMemoryTransactionRepository struct {
+	mu           sync.RWMutex
+	lastID       int
+	transactions map[int]model.Transaction
+	deleted      map[int]bool
+	refunds      []model.Refund
+}
+
+// NewInMemoryTransactionRepository creates an empty in-memory repository.
+func NewInMemoryTransactionRepository() *InMemoryTransactionRepository {
+	return &InMemoryTransactionRepository{
+		transactions: map[int]model.Transaction{},
+		deleted:      map[int]bool{},
+	}
+}
+
+// Create stores a new transaction and sets its ID and version.
+func (r *InMemoryTransactionRepository) Create(_ context.Context, transaction *model.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	transaction.ID = r.lastID
+	transaction.Version = 1
+	r.transactions[transaction.ID] = cloneTransaction(*transaction)
+	return nil
+}
+
+// CreateBatch stores several transactions at once. Storing in memory cannot fail,
+// so every item is stored whatever the mode.
+func (r *InMemoryTransactionRepository) CreateBatch(ctx context.Context, transactions []*model.Transaction, _ BatchMode) ([]error, error) {
+	for _, transaction := range transactions {
+		if err := r.Create(ctx, transaction); err != nil {
+			return nil, err
+		}
+	}
+
+	return make([]error, len(transactions)), nil
+}
+
+// GetByID returns the transaction with the given ID or ErrNotFound.
+func (r *InMemoryTransactionRepository) GetByID(_ context.Context, id int) (*model.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transaction, ok := r.transactions[id]
+	if !ok || r.deleted[id] {
+		return nil, ErrNotFound
+	}
+
+	transaction = cloneTransaction(transaction)
+	return &transaction, nil
+}
+
+// cloneTransaction copies the transaction so that its tags are not shared with the caller.
+func cloneTransaction(transaction model.Transaction) model.Transaction {
+	transaction.Tags = slices.Clone(transaction.Tags)
+	return transaction
+}
+
+// List returns a page of transactions matching the options.
+func (r *InMemoryTransactionRepository) List(_ context.Context, options ListOptions) (ListPage, error) {
+	if err := options.validateCursor(); err != nil {
+		return ListPage{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sortBy := options.sortByOrDefault()
+	transactions := []model.Transaction{}
+	for id, transaction := range r.transactions {
+		if !r.deleted[id] && matchesListOptions(transaction, options) &&
+			(options.After == nil || compareTransactions(cursorFor(transaction, sortBy, false), options.After, sortBy, options.Descending) > 0) {
+			transactions = append(transactions, cloneTransaction(transaction))
+		}
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		return compareTransactions(cursorFor(transactions[i], sortBy, false), cursorFor(transactions[j], sortBy, false), sortBy, options.Descending) < 0
+	})
+	if limit := options.limitOrDefault(); len(transactions) > limit+1 {
+		transactions = transactions[:limit+1]
+	}
+
+	return newListPage(transactions, options), nil
+}
+
+// matchesListOptions reports whether the transaction passes the filters of the options.
+func matchesListOptions(transaction model.Transaction, options ListOptions) bool {
+	switch {
+	case options.FromDate != "" && transaction.TransactionDate < options.FromDate,
+		options.ToDate != "" && transaction.TransactionDate > options.ToDate,
+		options.MinAmount != nil && transaction.Amount.Amount < *options.MinAmount,
+		options.MaxAmount != nil && transaction.Amount.Amount > *options.MaxAmount,
+		options.Description != "" && !strings.Contains(strings.ToLower(transaction.Description), strings.ToLower(options.Description)),
+		options.Merchant != "" && !strings.Contains(strings.ToLower(transaction.MerchantName), strings.ToLower(options.Merchant)),
+		options.MerchantCategoryCode != "" && transaction.MerchantCategoryCode != options.MerchantCategoryCode,
+		len(options.Categories) > 0 && !slices.Contains(options.Categories, transaction.Category):
+		return false
+	}
+	for _, tag := range options.Tags {
+		if !slices.Contains(transaction.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareTransactions compares the sort keys of two cursors in listing order,
+// returning a negative number when a comes first.
+func compareTransactions(a *Cursor, b *Cursor, sortBy SortField, descending bool) int {
+	result := 0
+	switch sortBy {
+	case SortByDate:
+		result = strings.Compare(a.Date, b.Date)
+	case SortByAmount:
+		result = cmp.Compare(a.Amount, b.Amount)
+	}
+	if result == 0 {
+		result = cmp.Compare(a.ID, b.ID)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// Update replaces the stored transaction with the same ID and version and
+// increments the version.
+func (r *InMemoryTransactionRepository) Update(_ context.Context, transaction *model.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkVersion(transaction.ID, transaction.Version, false); err != nil {
+		return err
+	}
+	if err := refundsConflict(transaction, r.refunded(transaction.ID), r.firstRefund(transaction.ID)); err != nil {
+		return err
+	}
+
+	transaction.Version++
+	r.transactions[transaction.ID] = cloneTransaction(*transaction)
+	return nil
+}
+
+// Delete soft deletes the transaction with the given ID and version and returns its new version.
+func (r *InMemoryTransactionRepository) Delete(_ context.Context, id int, version int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkVersion(id, version, false); err != nil {
+		return 0, err
+	}
+
+	r.bumpVersion(id)
+	r.deleted[id] = true
+	return version + 1, nil
+}
+
+// Restore undeletes the deleted transaction with the given ID and version.
+func (r *InMemoryTransactionRepository) Restore(_ context.Context, id int, version int) (*model.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkVersion(id, version, true); err != nil {
+		return nil, err
+	}
+
+	transaction := cloneTransaction(r.bumpVersion(id))
+	delete(r.deleted, id)
+	return &transaction, nil
+}
+
+// checkVersion returns ErrNotFound when there is no transaction with the given ID
+// in the given deleted state, and ErrVersionConflict when its version differs.
+func (r *InMemoryTransactionRepository) checkVersion(id int, version int, deleted bool) error {
+	transaction, ok := r.transactions[id]
+	if !ok || r.deleted[id] != deleted {
+		return ErrNotFound
+	}
+	if transaction.Version != version {
+		return ErrVersionConflict
+	}
+
+	return nil
+}
+
+// bumpVersion increments the version of the stored transaction and returns it.
+func (r *InMemoryTransactionRepository) bumpVersion(id int) model.Transaction {
+	transaction := r.transactions[id]
+	transaction.Version++
+	r.transactions[id] = transaction
+	return transaction
+}
+
+// refunded sums the refunds of the transaction. The caller must hold the lock.
+func (r *InMemoryTransactionRepository) refunded(transactionID int) int64 {
+	var total int64
+	for _, refund := range r.refunds {
+		if refund.TransactionID == transactionID {
+			total += refund.Amount.Amount
+		}
+	}
+	return total
+}
+
+// firstRefund returns the earliest refund date of the transaction, "" without
+// refunds. The caller must hold the lock.
+func (r *InMemoryTransactionRepository) firstRefund(transactionID int) string {
+	first := ""
+	for _, refund := range r.refunds {
+		if refund.TransactionID == transactionID && (first == "" || refund.RefundDate < first) {
+			first = refund.RefundDate
+		}
+	}
+	return first
+}
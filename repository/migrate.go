@@ -0,0 +1,324 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrChecksumMismatch is returned when an applied migration was edited after being applied.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// ErrDataLoss is returned when reverting a migration would delete rows and the
+// loss was not allowed.
+var ErrDataLoss = errors.New("migration would delete data")
+
+// dataLossCheck counts the rows the down step of a migration deletes.
+type dataLossCheck struct {
+	rows  string
+	query string
+}
+
+// dataLossChecks are the checks of the migrations whose down step deletes rows,
+// by version:
+//   - reverting 0004 drops the deleted_at column, so the soft-deleted
+//     transactions, which could still be restored, are deleted with it;
+//   - reverting 0006 drops the audit trail of conversions;
+//   - reverting 0009 drops the categories, the tags of the transactions and
+//     their merchant and category columns;
+//   - reverting 0010 drops the refunds.
+var dataLossChecks = map[int]dataLossCheck{
+	4: {"soft-deleted transaction(s)", "SELECT COUNT(*) FROM transactions WHERE deleted_at IS NOT NULL"},
+	6: {"recorded conversion(s)", "SELECT COUNT(*) FROM conversions"},
+	9: {"category, transaction tag or transaction merchant and category row(s)", `SELECT
+		(SELECT COUNT(*) FROM categories) + (SELECT COUNT(*) FROM transaction_tags) +
+		(SELECT COUNT(*) FROM transactions WHERE merchant_name <> '' OR merchant_category_code <> '' OR category_code IS NOT NULL)`},
+	10: {"refund(s)", "SELECT COUNT(*) FROM refunds"},
+}
+
+const createSchemaMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);
+`
+
+// Migration is a single reversible schema change.
+//
+// Migrations are read from the embedded migrations directory, where each version
+// has a `<version>_<name>.up.sql` and a `<version>_<name>.down.sql` file.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus describes whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	version   int
+	checksum  string
+	appliedAt string
+}
+
+// LoadMigrations returns the embedded migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := splitMigrationFileName(fileName)
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s", fileName)
+		}
+
+		versionText, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", fileName, err)
+		}
+
+		content, err := migrationFiles.ReadFile("migrations/" + fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) must have both up and down steps", migration.Version, migration.Name)
+		}
+
+		sum := sha256.Sum256([]byte(migration.Up + "\x00" + migration.Down))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration in version order.
+func MigrateUp(db *sql.DB) error {
+	migrations, applied, err := prepareMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if _, done := applied[migration.Version]; done {
+			continue
+		}
+
+		if err := runMigration(db, migration, nil, migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(
+				"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+				migration.Version, migration.Name, migration.Checksum, time.Now().UTC().Format(time.RFC3339),
+			)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations, or
+// every applied one when there are fewer, and returns how many it reverted.
+//
+// Unless allowDataLoss is set, it stops with ErrDataLoss before reverting a
+// migration whose down step would delete rows, such as the soft-deleted
+// transactions dropped by reverting 0004.
+func MigrateDown(db *sql.DB, steps int, allowDataLoss bool) (int, error) {
+	migrations, applied, err := prepareMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+		migration := migrations[i]
+		if _, done := applied[migration.Version]; !done {
+			continue
+		}
+
+		var guard func(tx *sql.Tx) error
+		if check, ok := dataLossChecks[migration.Version]; ok && !allowDataLoss {
+			guard = func(tx *sql.Tx) error {
+				var count int
+				if err := tx.QueryRow(check.query).Scan(&count); err != nil {
+					return err
+				}
+				if count > 0 {
+					return fmt.Errorf("%w: reverting migration %d (%s) deletes %d %s", ErrDataLoss, migration.Version, migration.Name, count, check.rows)
+				}
+				return nil
+			}
+		}
+
+		if err := runMigration(db, migration, guard, migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		}); err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// GetMigrationStatus reports, for every known migration, whether it has been applied.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, applied, err := prepareMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, done := applied[migration.Version]; done {
+			status.Applied = true
+			status.AppliedAt = row.appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// prepareMigrations loads the migrations and the applied versions, verifying that
+// every applied migration is still known and unchanged.
+func prepareMigrations(db *sql.DB) ([]Migration, map[int]appliedMigration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := db.Exec(createSchemaMigrationsTable); err != nil {
+		return nil, nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied, err := loadAppliedMigrations(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known := map[int]Migration{}
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, nil, fmt.Errorf("applied migration %d is unknown to this build", version)
+		}
+		if migration.Checksum != row.checksum {
+			return nil, nil, fmt.Errorf("%w: migration %d (%s) was modified after being applied", ErrChecksumMismatch, version, migration.Name)
+		}
+	}
+
+	return migrations, applied, nil
+}
+
+// loadAppliedMigrations reads the schema_migrations table.
+func loadAppliedMigrations(db *sql.DB) (map[int]appliedMigration, error) {
+	rows, err := db.Query("SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.version, &row.checksum, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[row.version] = row
+	}
+
+	return applied, rows.Err()
+}
+
+// runMigration executes the guard, when given, the SQL and the bookkeeping step
+// inside a single transaction. The migration is not run when the guard fails.
+func runMigration(db *sql.DB, migration Migration, guard func(tx *sql.Tx) error, statements string, bookkeeping func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if guard != nil {
+		if err := guard(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(statements); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+	}
+
+	if err := bookkeeping(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) bookkeeping failed: %w", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// splitMigrationFileName splits "0001_name.up.sql" into "0001_name" and "up".
+func splitMigrationFileName(fileName string) (string, string, bool) {
+	for _, direction := range []string{"up", "down"} {
+		if base, ok := strings.CutSuffix(fileName, "."+direction+".sql"); ok {
+			return base, direction, true
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "migrations must be numbered consecutively")
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+		assert.Len(t, migration.Checksum, 64)
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	latest := migrations[len(migrations)-1]
+
+	require.NoError(t, MigrateUp(db))
+	// Applying again is a no-op.
+	require.NoError(t, MigrateUp(db))
+
+	statuses, err := GetMigrationStatus(db)
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrations))
+	for _, status := range statuses {
+		assert.True(t, status.Applied, "migration %d should be applied", status.Version)
+		assert.NotEmpty(t, status.AppliedAt)
+	}
+
+	reverted, err := MigrateDown(db, 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	statuses, err = GetMigrationStatus(db)
+	require.NoError(t, err)
+	assert.False(t, statuses[len(statuses)-1].Applied)
+	assert.Equal(t, latest.Version, statuses[len(statuses)-1].Version)
+
+	// Asking for more steps than applied migrations reverts the applied ones.
+	reverted, err = MigrateDown(db, len(migrations), false)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations)-1, reverted)
+	var tables int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='transactions'").Scan(&tables))
+	assert.Equal(t, 0, tables)
+
+	reverted, err = MigrateDown(db, 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, reverted)
+
+	require.NoError(t, MigrateUp(db))
+}
+
+func TestMigrateDetectsEditedMigrations(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	require.NoError(t, MigrateUp(db))
+	_, err = db.Exec("UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, MigrateUp(db), ErrChecksumMismatch)
+	_, err = MigrateDown(db, 1, false)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestMigrateDownRefusesToDeleteData(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+
+	createTransaction := func(t *testing.T, db *sql.DB) *model.Transaction {
+		transaction := &model.Transaction{Description: "Shoes", Amount: util.USD(1234), TransactionDate: "2020-01-01"}
+		require.NoError(t, NewSQLiteTransactionRepository(db).Create(context.Background(), transaction))
+		return transaction
+	}
+
+	for _, test := range []struct {
+		version int
+		loss    string
+		store   func(t *testing.T, db *sql.DB)
+	}{
+		{4, "deletes 1 soft-deleted transaction(s)", func(t *testing.T, db *sql.DB) {
+			transaction := createTransaction(t, db)
+			_, err := NewSQLiteTransactionRepository(db).Delete(context.Background(), transaction.ID, transaction.Version)
+			require.NoError(t, err)
+		}},
+		{6, "deletes 1 recorded conversion(s)", func(t *testing.T, db *sql.DB) {
+			transaction := createTransaction(t, db)
+			require.NoError(t, NewSQLiteConversionRepository(db).Record(context.Background(), &model.Conversion{
+				TransactionID: transaction.ID, TransactionVersion: 1, TransactionDate: "2020-01-01", Country: "Canada",
+				USDAmount: transaction.Amount, ExchangeRate: 1.3, ConvertedAmount: util.Money{Amount: 1604, Currency: "CAD"}, Currency: "CAD",
+				Rounding: "half_up", RateSource: "treasury", RateEffectiveDate: "2019-12-31", ConvertedAt: "2020-01-02T00:00:00Z",
+			}))
+		}},
+		{9, "deletes 1 category, transaction tag or transaction merchant and category row(s)", func(t *testing.T, db *sql.DB) {
+			require.NoError(t, NewSQLiteCategoryRepository(db).Create(context.Background(), &model.Category{Code: "travel", Name: "Travel"}))
+		}},
+		{10, "deletes 1 refund(s)", func(t *testing.T, db *sql.DB) {
+			transaction := createTransaction(t, db)
+			require.NoError(t, NewSQLiteRefundRepository(db).Create(context.Background(), &model.Refund{
+				TransactionID: transaction.ID, Kind: model.RefundKindRefund, Amount: util.USD(100), RefundDate: "2020-01-02", CreatedAt: "2020-01-02T00:00:00Z",
+			}))
+		}},
+	} {
+		t.Run(strconv.Itoa(test.version), func(t *testing.T) {
+			db, err := sql.Open("sqlite3", ":memory:")
+			require.NoError(t, err)
+			db.SetMaxOpenConns(1)
+			defer db.Close()
+
+			require.NoError(t, MigrateUp(db))
+			test.store(t, db)
+
+			// The migrations after the one deleting data are reverted, then it is refused.
+			reverted, err := MigrateDown(db, len(migrations), false)
+			assert.ErrorIs(t, err, ErrDataLoss)
+			assert.ErrorContains(t, err, test.loss)
+			assert.Equal(t, len(migrations)-test.version, reverted)
+			statuses, err := GetMigrationStatus(db)
+			require.NoError(t, err)
+			assert.True(t, statuses[test.version-1].Applied)
+
+			reverted, err = MigrateDown(db, 1, true)
+			require.NoError(t, err)
+			assert.Equal(t, 1, reverted)
+			statuses, err = GetMigrationStatus(db)
+			require.NoError(t, err)
+			assert.False(t, statuses[test.version-1].Applied)
+		})
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS transactions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	description TEXT NOT NULL CHECK(length(description) <= 50),
+	amount DECIMAL(10, 2) NOT NULL,
+	transaction_date TEXT NOT NULL
+);
@@ -0,0 +1,13 @@
+CREATE TABLE transactions_decimal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	description TEXT NOT NULL CHECK(length(description) <= 50),
+	amount DECIMAL(10, 2) NOT NULL,
+	transaction_date TEXT NOT NULL
+);
+
+INSERT INTO transactions_decimal (id, description, amount, transaction_date)
+	SELECT id, description, amount_minor / 100.0, transaction_date FROM transactions;
+
+DROP TABLE transactions;
+
+ALTER TABLE transactions_decimal RENAME TO transactions;
@@ -0,0 +1,15 @@
+-- Store amounts as integer minor units instead of a floating point value.
+CREATE TABLE transactions_minor (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	description TEXT NOT NULL CHECK(length(description) <= 50),
+	amount_minor INTEGER NOT NULL,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	transaction_date TEXT NOT NULL
+);
+
+INSERT INTO transactions_minor (id, description, amount_minor, currency, transaction_date)
+	SELECT id, description, CAST(ROUND(amount * 100) AS INTEGER), 'USD', transaction_date FROM transactions;
+
+DROP TABLE transactions;
+
+ALTER TABLE transactions_minor RENAME TO transactions;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_transactions_date_id ON transactions (transaction_date, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_amount_id ON transactions (amount_minor, id);
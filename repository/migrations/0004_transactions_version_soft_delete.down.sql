@@ -0,0 +1,3 @@
+DELETE FROM transactions WHERE deleted_at IS NOT NULL;
+ALTER TABLE transactions DROP COLUMN deleted_at;
+ALTER TABLE transactions DROP COLUMN version;
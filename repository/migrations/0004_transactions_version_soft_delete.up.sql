@@ -0,0 +1,2 @@
+ALTER TABLE transactions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE transactions ADD COLUMN deleted_at TEXT;
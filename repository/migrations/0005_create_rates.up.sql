@@ -0,0 +1,13 @@
+CREATE TABLE rates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	country TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	country_currency_desc TEXT NOT NULL,
+	exchange_rate REAL NOT NULL,
+	effective_date TEXT NOT NULL,
+	record_date TEXT NOT NULL,
+	UNIQUE (country_currency_desc, record_date)
+);
+
+CREATE INDEX idx_rates_country_effective_date ON rates (country, effective_date);
+CREATE INDEX idx_rates_record_date ON rates (record_date);
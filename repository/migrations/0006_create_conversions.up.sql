@@ -0,0 +1,20 @@
+CREATE TABLE conversions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id INTEGER NOT NULL,
+	transaction_version INTEGER NOT NULL,
+	transaction_date TEXT NOT NULL,
+	country TEXT NOT NULL,
+	usd_amount_minor INTEGER NOT NULL,
+	exchange_rate REAL NOT NULL,
+	converted_amount_minor INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	scale INTEGER NOT NULL,
+	rounding TEXT NOT NULL,
+	rate_source TEXT NOT NULL,
+	rate_currency TEXT NOT NULL,
+	rate_effective_date TEXT NOT NULL,
+	rate_record_date TEXT NOT NULL,
+	converted_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_conversions_transaction_id ON conversions (transaction_id, id);
@@ -0,0 +1,5 @@
+ALTER TABLE conversions DROP COLUMN rate_match;
+ALTER TABLE conversions DROP COLUMN fallback_to_latest;
+ALTER TABLE conversions DROP COLUMN exact_date;
+ALTER TABLE conversions DROP COLUMN allow_later_rates;
+ALTER TABLE conversions DROP COLUMN lookback;
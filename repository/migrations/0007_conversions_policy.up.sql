@@ -0,0 +1,5 @@
+ALTER TABLE conversions ADD COLUMN lookback TEXT NOT NULL DEFAULT '6m';
+ALTER TABLE conversions ADD COLUMN allow_later_rates INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE conversions ADD COLUMN exact_date INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE conversions ADD COLUMN fallback_to_latest INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE conversions ADD COLUMN rate_match TEXT NOT NULL DEFAULT 'lookback';
@@ -0,0 +1,11 @@
+CREATE TABLE idempotency_keys (
+	key TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	response_body BLOB,
+	etag TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
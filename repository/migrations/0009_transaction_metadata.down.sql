@@ -0,0 +1,7 @@
+DROP TABLE transaction_tags;
+DROP TABLE tags;
+DROP TABLE categories;
+DROP INDEX idx_transactions_category_code;
+ALTER TABLE transactions DROP COLUMN category_code;
+ALTER TABLE transactions DROP COLUMN merchant_category_code;
+ALTER TABLE transactions DROP COLUMN merchant_name;
@@ -0,0 +1,26 @@
+ALTER TABLE transactions ADD COLUMN merchant_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN merchant_category_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN category_code TEXT;
+
+CREATE INDEX idx_transactions_category_code ON transactions (category_code);
+
+CREATE TABLE categories (
+	code TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	parent_code TEXT
+);
+
+CREATE INDEX idx_categories_parent_code ON categories (parent_code);
+
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE transaction_tags (
+	transaction_id INTEGER NOT NULL,
+	tag_id INTEGER NOT NULL,
+	PRIMARY KEY (transaction_id, tag_id)
+);
+
+CREATE INDEX idx_transaction_tags_tag_id ON transaction_tags (tag_id, transaction_id);
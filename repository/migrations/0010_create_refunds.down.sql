@@ -0,0 +1,2 @@
+ALTER TABLE conversions DROP COLUMN refund_id;
+DROP TABLE refunds;
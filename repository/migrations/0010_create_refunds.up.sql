@@ -0,0 +1,14 @@
+CREATE TABLE refunds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	amount_minor INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	refund_date TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_refunds_transaction_id ON refunds (transaction_id, id);
+
+ALTER TABLE conversions ADD COLUMN refund_id INTEGER;
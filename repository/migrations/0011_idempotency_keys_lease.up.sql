@@ -0,0 +1 @@
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TEXT NOT NULL DEFAULT '';
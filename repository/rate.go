@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+// RateRepository stores a local copy of exchange rates.
+type RateRepository interface {
+	// UpsertRates inserts the rates, replacing the stored ones with the same
+	// country_currency_desc and record_date.
+	UpsertRates(ctx context.Context, rates []model.ExchangeRate) error
+	// LatestRate returns the rate of the country with the most recent effective
+	// date between from and to (inclusive, YYYY-MM-DD), or ErrNotFound.
+	LatestRate(ctx context.Context, country string, from string, to string) (*model.ExchangeRate, error)
+	// EarliestRate returns the rate of the country with the earliest effective
+	// date between from and to (inclusive, YYYY-MM-DD), or ErrNotFound.
+	EarliestRate(ctx context.Context, country string, from string, to string) (*model.ExchangeRate, error)
+	// LastRecordDate returns the most recent record_date stored, or "" when there are no rates.
+	LastRecordDate(ctx context.Context) (string, error)
+}
+
+var _ RateRepository = (*SQLiteRateRepository)(nil)
+
+// SQLiteRateRepository is a RateRepository backed by the rates table.
+type SQLiteRateRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRateRepository creates a repository using the given database connection.
+func NewSQLiteRateRepository(db *sql.DB) *SQLiteRateRepository {
+	return &SQLiteRateRepository{db: db}
+}
+
+// UpsertRates inserts or replaces the rates inside a single database transaction.
+func (r *SQLiteRateRepository) UpsertRates(ctx context.Context, rates []model.ExchangeRate) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO rates (country, currency, country_currency_desc, exchange_rate, effective_date, record_date)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (country_currency_desc, record_date) DO UPDATE SET
+			country = excluded.country,
+			currency = excluded.currency,
+			exchange_rate = excluded.exchange_rate,
+			effective_date = excluded.effective_date`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rate := range rates {
+		if _, err := stmt.ExecContext(ctx, rate.Country, rate.Currency, rate.CountryCurrencyDesc, rate.ExchangeRate, rate.EffectiveDate, rate.RecordDate); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LatestRate returns the most recently effective rate of the country within the dates.
+func (r *SQLiteRateRepository) LatestRate(ctx context.Context, country string, from string, to string) (*model.ExchangeRate, error) {
+	return r.findRate(ctx, `SELECT country, currency, country_currency_desc, exchange_rate, effective_date, record_date
+		FROM rates WHERE country = ? AND effective_date >= ? AND effective_date <= ?
+		ORDER BY effective_date DESC, record_date DESC LIMIT 1`, country, from, to)
+}
+
+// EarliestRate returns the earliest effective rate of the country within the dates.
+func (r *SQLiteRateRepository) EarliestRate(ctx context.Context, country string, from string, to string) (*model.ExchangeRate, error) {
+	return r.findRate(ctx, `SELECT country, currency, country_currency_desc, exchange_rate, effective_date, record_date
+		FROM rates WHERE country = ? AND effective_date >= ? AND effective_date <= ?
+		ORDER BY effective_date ASC, record_date DESC LIMIT 1`, country, from, to)
+}
+
+// findRate returns the single rate selected by the query, or ErrNotFound.
+func (r *SQLiteRateRepository) findRate(ctx context.Context, query string, country string, from string, to string) (*model.ExchangeRate, error) {
+	var rate model.ExchangeRate
+	err := r.db.QueryRowContext(ctx, query, country, from, to).
+		Scan(&rate.Country, &rate.Currency, &rate.CountryCurrencyDesc, &rate.ExchangeRate, &rate.EffectiveDate, &rate.RecordDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// LastRecordDate returns the most recent record_date stored.
+func (r *SQLiteRateRepository) LastRecordDate(ctx context.Context) (string, error) {
+	var recordDate sql.NullString
+	if err := r.db.QueryRowContext(ctx, "SELECT MAX(record_date) FROM rates").Scan(&recordDate); err != nil {
+		return "", err
+	}
+
+	return recordDate.String, nil
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+func TestSQLiteRateRepository(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	ApplyMigrations(db)
+
+	ctx := context.Background()
+	repo := NewSQLiteRateRepository(db)
+
+	last, err := repo.LastRecordDate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "", last)
+
+	rates := []model.ExchangeRate{
+		{Country: "Canada", Currency: "Dollar", CountryCurrencyDesc: "Canada-Dollar", ExchangeRate: 1.30, EffectiveDate: "2024-09-30", RecordDate: "2024-09-30"},
+		{Country: "Canada", Currency: "Dollar", CountryCurrencyDesc: "Canada-Dollar", ExchangeRate: 1.35, EffectiveDate: "2024-12-31", RecordDate: "2024-12-31"},
+		{Country: "Mexico", Currency: "Peso", CountryCurrencyDesc: "Mexico-Peso", ExchangeRate: 20.1, EffectiveDate: "2024-12-31", RecordDate: "2024-12-31"},
+	}
+	require.NoError(t, repo.UpsertRates(ctx, rates))
+
+	// Upserting the same record again replaces it.
+	corrected := rates[1]
+	corrected.ExchangeRate = 1.36
+	require.NoError(t, repo.UpsertRates(ctx, []model.ExchangeRate{corrected}))
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM rates").Scan(&count))
+	assert.Equal(t, 3, count)
+
+	rate, err := repo.LatestRate(ctx, "Canada", "2024-07-13", "2025-01-13")
+	require.NoError(t, err)
+	assert.Equal(t, corrected, *rate)
+
+	rate, err = repo.LatestRate(ctx, "Canada", "2024-06-30", "2024-12-30")
+	require.NoError(t, err)
+	assert.Equal(t, rates[0], *rate)
+
+	_, err = repo.LatestRate(ctx, "Canada", "2025-01-01", "2025-06-30")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	rate, err = repo.EarliestRate(ctx, "Canada", "2024-07-13", "2025-01-13")
+	require.NoError(t, err)
+	assert.Equal(t, rates[0], *rate)
+
+	_, err = repo.EarliestRate(ctx, "Canada", "2025-01-01", "2025-06-30")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	last, err = repo.LastRecordDate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-12-31", last)
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var (
+	// ErrRefundNotFound is returned when the requested refund does not exist.
+	ErrRefundNotFound = errors.New("refund not found")
+	// ErrRefundExceedsAmount is returned when a refund would make the refunds of
+	// a transaction total more than its amount.
+	ErrRefundExceedsAmount = errors.New("refunds exceed the transaction amount")
+	// ErrRefundBeforePurchase is returned when a refund is dated before the
+	// transaction date.
+	ErrRefundBeforePurchase = errors.New("refund dated before the transaction")
+)
+
+// RefundRepository stores the refunds of transactions. Refunds are only ever
+// added, and only to transactions that are not deleted.
+type RefundRepository interface {
+	// Create stores the refund and sets its ID. It returns ErrNotFound when the
+	// transaction does not exist, ErrRefundBeforePurchase when the refund is
+	// dated before the transaction and ErrRefundExceedsAmount when the refunds of
+	// the transaction would total more than its amount.
+	Create(ctx context.Context, refund *model.Refund) error
+	// Get returns the refund of the transaction with the given ID or ErrRefundNotFound.
+	Get(ctx context.Context, transactionID int, id int) (*model.Refund, error)
+	// ListByTransaction returns the refunds of a transaction, oldest first.
+	ListByTransaction(ctx context.Context, transactionID int) ([]model.Refund, error)
+	// Totals returns the amount refunded, in minor units, of each of the given
+	// transactions having refunds.
+	Totals(ctx context.Context, transactionIDs []int) (map[int]int64, error)
+}
+
+var _ RefundRepository = (*SQLiteRefundRepository)(nil)
+
+// SQLiteRefundRepository is a RefundRepository backed by the refunds table.
+type SQLiteRefundRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRefundRepository creates a repository using the given database connection.
+func NewSQLiteRefundRepository(db *sql.DB) *SQLiteRefundRepository {
+	return &SQLiteRefundRepository{db: db}
+}
+
+// Create inserts the refund with a single statement checking the transaction
+// date and the refunds already stored, so that concurrent refunds cannot exceed
+// the amount together and a concurrent update cannot date the transaction after
+// the refund.
+func (r *SQLiteRefundRepository) Create(ctx context.Context, refund *model.Refund) error {
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO refunds (transaction_id, kind, amount_minor, currency, refund_date, reason, created_at)
+		SELECT id, ?, ?, ?, ?, ?, ? FROM transactions
+		WHERE id = ? AND deleted_at IS NULL AND transaction_date <= ?
+			AND amount_minor >= ? + (SELECT COALESCE(SUM(amount_minor), 0) FROM refunds WHERE transaction_id = transactions.id)`,
+		refund.Kind, refund.Amount.Amount, refund.Amount.Currency, refund.RefundDate, refund.Reason, refund.CreatedAt,
+		refund.TransactionID, refund.RefundDate, refund.Amount.Amount)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var transactionDate string
+		err := r.db.QueryRowContext(ctx, "SELECT transaction_date FROM transactions WHERE id = ? AND deleted_at IS NULL", refund.TransactionID).Scan(&transactionDate)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if refund.RefundDate < transactionDate {
+			return ErrRefundBeforePurchase
+		}
+		return ErrRefundExceedsAmount
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	refund.ID = int(id)
+	return nil
+}
+
+// refundColumns are the columns read by scanRefund.
+const refundColumns = "id, transaction_id, kind, amount_minor, currency, refund_date, reason, created_at"
+
+// Get returns the refund of the transaction with the given ID.
+func (r *SQLiteRefundRepository) Get(ctx context.Context, transactionID int, id int) (*model.Refund, error) {
+	query := "SELECT " + refundColumns + " FROM refunds WHERE id = ? AND transaction_id = ?"
+	refund, err := scanRefund(r.db.QueryRowContext(ctx, query, id, transactionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefundNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+// ListByTransaction returns the refunds of the transaction.
+func (r *SQLiteRefundRepository) ListByTransaction(ctx context.Context, transactionID int) ([]model.Refund, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+refundColumns+" FROM refunds WHERE transaction_id = ? ORDER BY id", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []model.Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// Totals sums the refunds of the transactions.
+func (r *SQLiteRefundRepository) Totals(ctx context.Context, transactionIDs []int) (map[int]int64, error) {
+	totals := map[int]int64{}
+	if len(transactionIDs) == 0 {
+		return totals, nil
+	}
+
+	placeholders := make([]string, len(transactionIDs))
+	args := make([]any, len(transactionIDs))
+	for i, id := range transactionIDs {
+		placeholders[i], args[i] = "?", id
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT transaction_id, SUM(amount_minor) FROM refunds
+		WHERE transaction_id IN (`+strings.Join(placeholders, ", ")+`) GROUP BY transaction_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var total int64
+		if err := rows.Scan(&id, &total); err != nil {
+			return nil, err
+		}
+		totals[id] = total
+	}
+
+	return totals, rows.Err()
+}
+
+// scanRefund reads a row selected with refundColumns.
+func scanRefund(row rowScanner) (*model.Refund, error) {
+	var refund model.Refund
+	err := row.Scan(&refund.ID, &refund.TransactionID, &refund.Kind, &refund.Amount.Amount, &refund.Amount.Currency,
+		&refund.RefundDate, &refund.Reason, &refund.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &refund, nil
+}
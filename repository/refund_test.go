@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestRefundRepository(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	ApplyMigrations(db)
+
+	memoryTransactions := NewInMemoryTransactionRepository()
+	repositories := map[string]struct {
+		transactions TransactionRepository
+		refunds      RefundRepository
+	}{
+		"sqlite":    {NewSQLiteTransactionRepository(db), NewSQLiteRefundRepository(db)},
+		"in-memory": {memoryTransactions, NewInMemoryRefundRepository(memoryTransactions)},
+	}
+
+	for name, repos := range repositories {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			purchase := &model.Transaction{Description: "Shoes", Amount: util.USD(10000), TransactionDate: "2024-03-01"}
+			other := &model.Transaction{Description: "Socks", Amount: util.USD(500), TransactionDate: "2024-03-01"}
+			require.NoError(t, repos.transactions.Create(ctx, purchase))
+			require.NoError(t, repos.transactions.Create(ctx, other))
+
+			refund := func(transactionID int, cents int64) *model.Refund {
+				return &model.Refund{TransactionID: transactionID, Kind: model.RefundKindRefund, Amount: util.USD(cents),
+					RefundDate: "2024-03-10", Reason: "Wrong size", CreatedAt: "2024-03-10T09:00:00Z"}
+			}
+
+			first, second := refund(purchase.ID, 6000), refund(purchase.ID, 4000)
+			require.NoError(t, repos.refunds.Create(ctx, first))
+			require.NoError(t, repos.refunds.Create(ctx, refund(other.ID, 100)))
+			// The refunds may total the whole amount, but not more.
+			assert.ErrorIs(t, repos.refunds.Create(ctx, refund(purchase.ID, 4001)), ErrRefundExceedsAmount)
+			require.NoError(t, repos.refunds.Create(ctx, second))
+			assert.ErrorIs(t, repos.refunds.Create(ctx, refund(purchase.ID, 1)), ErrRefundExceedsAmount)
+			assert.ErrorIs(t, repos.refunds.Create(ctx, refund(999, 1)), ErrNotFound)
+			early := refund(other.ID, 1)
+			early.RefundDate = "2024-02-29"
+			assert.ErrorIs(t, repos.refunds.Create(ctx, early), ErrRefundBeforePurchase)
+
+			got, err := repos.refunds.Get(ctx, purchase.ID, first.ID)
+			require.NoError(t, err)
+			assert.Equal(t, first, got)
+			_, err = repos.refunds.Get(ctx, other.ID, first.ID)
+			assert.ErrorIs(t, err, ErrRefundNotFound)
+
+			refunds, err := repos.refunds.ListByTransaction(ctx, purchase.ID)
+			require.NoError(t, err)
+			assert.Equal(t, []model.Refund{*first, *second}, refunds)
+
+			totals, err := repos.refunds.Totals(ctx, []int{purchase.ID, other.ID, 999})
+			require.NoError(t, err)
+			assert.Equal(t, map[int]int64{purchase.ID: 10000, other.ID: 100}, totals)
+
+			// Updates keep the amount and date of a transaction compatible with its refunds.
+			lowered := *purchase
+			lowered.Amount = util.USD(9999)
+			assert.ErrorIs(t, repos.transactions.Update(ctx, &lowered), ErrBelowRefunds)
+			postponed := *purchase
+			postponed.TransactionDate = "2024-03-11"
+			assert.ErrorIs(t, repos.transactions.Update(ctx, &postponed), ErrAfterRefunds)
+			postponed.Amount = util.USD(1)
+			err = repos.transactions.Update(ctx, &postponed)
+			assert.ErrorIs(t, err, ErrBelowRefunds)
+			assert.ErrorIs(t, err, ErrAfterRefunds)
+			stale := *purchase
+			stale.Version = 99
+			stale.Amount = util.USD(1)
+			assert.ErrorIs(t, repos.transactions.Update(ctx, &stale), ErrVersionConflict)
+			unchanged := *purchase
+			unchanged.TransactionDate = "2024-03-10"
+			require.NoError(t, repos.transactions.Update(ctx, &unchanged))
+
+			// Deleted transactions cannot be refunded.
+			_, err = repos.transactions.Delete(ctx, other.ID, other.Version)
+			require.NoError(t, err)
+			assert.ErrorIs(t, repos.refunds.Create(ctx, refund(other.ID, 1)), ErrNotFound)
+		})
+	}
+}
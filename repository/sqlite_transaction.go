@@ -0,0 +1,477 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+const transactionColumns = "id, description, amount_minor, currency, transaction_date, merchant_name, merchant_category_code, COALESCE(category_code, ''), version"
+
+// insertTransaction is the statement storing a new transaction, without its tags.
+const insertTransaction = `INSERT INTO transactions (description, amount_minor, currency, transaction_date, merchant_name, merchant_category_code, category_code)
+	VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''))`
+
+var _ TransactionRepository = (*SQLiteTransactionRepository)(nil)
+
+// SQLiteTransactionRepository is a TransactionRepository backed by a SQL database.
+type SQLiteTransactionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteTransactionRepository creates a repository using the given database connection.
+func NewSQLiteTransactionRepository(db *sql.DB) *SQLiteTransactionRepository {
+	return &SQLiteTransactionRepository{db: db}
+}
+
+// Create stores a new transaction and its tags and sets its ID and version.
+func (r *SQLiteTransactionRepository) Create(ctx context.Context, transaction *model.Transaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, insertTransaction, insertArgs(transaction)...)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := saveTags(ctx, tx, id, transaction.Tags); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	transaction.ID = int(id)
+	transaction.Version = 1
+	return nil
+}
+
+// insertArgs returns the arguments of insertTransaction for the transaction.
+func insertArgs(transaction *model.Transaction) []any {
+	return []any{transaction.Description, transaction.Amount.Amount, transaction.Amount.Currency, transaction.TransactionDate,
+		transaction.MerchantName, transaction.MerchantCategoryCode, transaction.Category}
+}
+
+// saveTags replaces the tags of the transaction, creating the tags not used before.
+func saveTags(ctx context.Context, tx *sql.Tx, transactionID int64, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM transaction_tags WHERE transaction_id = ?", transactionID); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING", tag); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO transaction_tags (transaction_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", transactionID, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadTags sets the tags of the transactions, in name order.
+func (r *SQLiteTransactionRepository) loadTags(ctx context.Context, transactions []model.Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	index := map[int]int{}
+	placeholders := make([]string, len(transactions))
+	args := make([]any, len(transactions))
+	for i, transaction := range transactions {
+		index[transaction.ID] = i
+		placeholders[i], args[i] = "?", transaction.ID
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT transaction_tags.transaction_id, tags.name
+		FROM transaction_tags JOIN tags ON tags.id = transaction_tags.tag_id
+		WHERE transaction_tags.transaction_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY tags.name`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		transactions[index[id]].Tags = append(transactions[index[id]].Tags, tag)
+	}
+
+	return rows.Err()
+}
+
+// CreateBatch stores several transactions inside a single database transaction,
+// using one prepared statement. In BatchPartial mode every item runs in its own
+// savepoint so that a failing item does not undo the others.
+func (r *SQLiteTransactionRepository) CreateBatch(ctx context.Context, transactions []*model.Transaction, mode BatchMode) ([]error, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertTransaction)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	itemErrs := make([]error, len(transactions))
+	ids := make([]int64, len(transactions))
+	for i, transaction := range transactions {
+		if mode == BatchPartial {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+				return nil, err
+			}
+		}
+
+		res, err := stmt.ExecContext(ctx, insertArgs(transaction)...)
+		if err == nil {
+			ids[i], err = res.LastInsertId()
+		}
+		if err == nil {
+			err = saveTags(ctx, tx, ids[i], transaction.Tags)
+		}
+
+		if err != nil {
+			itemErrs[i] = err
+			if mode != BatchPartial {
+				return itemErrs, ErrBatchAborted
+			}
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO batch_item"); err != nil {
+				return nil, err
+			}
+		}
+
+		if mode == BatchPartial {
+			if _, err := tx.ExecContext(ctx, "RELEASE batch_item"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for i, transaction := range transactions {
+		if itemErrs[i] == nil {
+			transaction.ID, transaction.Version = int(ids[i]), 1
+		}
+	}
+
+	return itemErrs, nil
+}
+
+// GetByID returns the transaction with the given ID or ErrNotFound.
+func (r *SQLiteTransactionRepository) GetByID(ctx context.Context, id int) (*model.Transaction, error) {
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE id = ? AND deleted_at IS NULL"
+	transaction, err := scanTransaction(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []model.Transaction{*transaction}
+	if err := r.loadTags(ctx, transactions); err != nil {
+		return nil, err
+	}
+
+	return &transactions[0], nil
+}
+
+// List returns a page of transactions matching the options.
+func (r *SQLiteTransactionRepository) List(ctx context.Context, options ListOptions) (ListPage, error) {
+	if err := options.validateCursor(); err != nil {
+		return ListPage{}, err
+	}
+
+	where, args := listConditions(options)
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE " + strings.Join(where, " AND ")
+
+	column, direction := sortColumns[options.sortByOrDefault()], "ASC"
+	if options.Descending {
+		direction = "DESC"
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ?", column, direction, direction)
+	args = append(args, options.limitOrDefault()+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ListPage{}, err
+	}
+	defer rows.Close()
+
+	transactions := []model.Transaction{}
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return ListPage{}, err
+		}
+		transactions = append(transactions, *transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return ListPage{}, err
+	}
+
+	page := newListPage(transactions, options)
+	if err := r.loadTags(ctx, page.Transactions); err != nil {
+		return ListPage{}, err
+	}
+
+	return page, nil
+}
+
+// sortColumns maps each sort field to its database column.
+var sortColumns = map[SortField]string{
+	SortByID:     "id",
+	SortByDate:   "transaction_date",
+	SortByAmount: "amount_minor",
+}
+
+// listConditions builds the WHERE conditions and arguments for the given options.
+func listConditions(options ListOptions) ([]string, []any) {
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+
+	if options.FromDate != "" {
+		where = append(where, "transaction_date >= ?")
+		args = append(args, options.FromDate)
+	}
+	if options.ToDate != "" {
+		where = append(where, "transaction_date <= ?")
+		args = append(args, options.ToDate)
+	}
+	if options.MinAmount != nil {
+		where = append(where, "amount_minor >= ?")
+		args = append(args, *options.MinAmount)
+	}
+	if options.MaxAmount != nil {
+		where = append(where, "amount_minor <= ?")
+		args = append(args, *options.MaxAmount)
+	}
+	if options.Description != "" {
+		where = append(where, "instr(lower(description), lower(?)) > 0")
+		args = append(args, options.Description)
+	}
+	if options.Merchant != "" {
+		where = append(where, "instr(lower(merchant_name), lower(?)) > 0")
+		args = append(args, options.Merchant)
+	}
+	if options.MerchantCategoryCode != "" {
+		where = append(where, "merchant_category_code = ?")
+		args = append(args, options.MerchantCategoryCode)
+	}
+	if len(options.Categories) > 0 {
+		where = append(where, "category_code IN (?"+strings.Repeat(", ?", len(options.Categories)-1)+")")
+		for _, category := range options.Categories {
+			args = append(args, category)
+		}
+	}
+	for _, tag := range options.Tags {
+		where = append(where, `EXISTS (SELECT 1 FROM transaction_tags JOIN tags ON tags.id = transaction_tags.tag_id
+			WHERE transaction_tags.transaction_id = transactions.id AND tags.name = ?)`)
+		args = append(args, tag)
+	}
+
+	if after := options.After; after != nil {
+		operator := ">"
+		if options.Descending {
+			operator = "<"
+		}
+
+		switch after.SortBy {
+		case SortByDate:
+			where = append(where, fmt.Sprintf("(transaction_date %s ? OR (transaction_date = ? AND id %s ?))", operator, operator))
+			args = append(args, after.Date, after.Date, after.ID)
+		case SortByAmount:
+			where = append(where, fmt.Sprintf("(amount_minor %s ? OR (amount_minor = ? AND id %s ?))", operator, operator))
+			args = append(args, after.Amount, after.Amount, after.ID)
+		default:
+			where = append(where, fmt.Sprintf("id %s ?", operator))
+			args = append(args, after.ID)
+		}
+	}
+
+	return where, args
+}
+
+// Update replaces the stored transaction with the same ID and version, with its
+// tags, and increments the version.
+func (r *SQLiteTransactionRepository) Update(ctx context.Context, transaction *model.Transaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The refunds are checked by the statement itself, so that a refund stored
+	// concurrently cannot end up exceeding the amount or predating the purchase.
+	query := `UPDATE transactions SET description = ?, amount_minor = ?, currency = ?, transaction_date = ?,
+			merchant_name = ?, merchant_category_code = ?, category_code = NULLIF(?, ''), version = version + 1
+		WHERE id = ? AND version = ? AND deleted_at IS NULL
+			AND ? >= (SELECT COALESCE(SUM(amount_minor), 0) FROM refunds WHERE transaction_id = transactions.id)
+			AND NOT EXISTS (SELECT 1 FROM refunds WHERE transaction_id = transactions.id AND refund_date < ?)`
+	args := append(insertArgs(transaction), transaction.ID, transaction.Version, transaction.Amount.Amount, transaction.TransactionDate)
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		// Release the connection before the transaction is looked up.
+		tx.Rollback()
+		return r.updateRefused(ctx, transaction)
+	}
+	if err := saveTags(ctx, tx, int64(transaction.ID), transaction.Tags); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	transaction.Version++
+	return nil
+}
+
+// Delete soft deletes the transaction with the given ID and version and returns its new version.
+func (r *SQLiteTransactionRepository) Delete(ctx context.Context, id int, version int) (int, error) {
+	query := `UPDATE transactions SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND version = ? AND deleted_at IS NULL`
+	res, err := r.db.ExecContext(ctx, query, time.Now().UTC().Format(time.RFC3339), id, version)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := r.requireAffected(ctx, res, id, false); err != nil {
+		return 0, err
+	}
+
+	return version + 1, nil
+}
+
+// Restore undeletes the deleted transaction with the given ID and version.
+func (r *SQLiteTransactionRepository) Restore(ctx context.Context, id int, version int) (*model.Transaction, error) {
+	query := `UPDATE transactions SET deleted_at = NULL, version = version + 1
+		WHERE id = ? AND version = ? AND deleted_at IS NOT NULL`
+	res, err := r.db.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.requireAffected(ctx, res, id, true); err != nil {
+		return nil, err
+	}
+
+	return r.GetByID(ctx, id)
+}
+
+// requireAffected checks that a versioned statement changed a row. When it did
+// not, it tells apart a missing transaction (ErrNotFound) from a stale version
+// (ErrVersionConflict). deleted selects whether the statement targeted deleted
+// transactions.
+func (r *SQLiteTransactionRepository) requireAffected(ctx context.Context, res sql.Result, id int, deleted bool) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	query := "SELECT COUNT(*) FROM transactions WHERE id = ? AND deleted_at IS NULL"
+	if deleted {
+		query = "SELECT COUNT(*) FROM transactions WHERE id = ? AND deleted_at IS NOT NULL"
+	}
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+
+	return ErrVersionConflict
+}
+
+// updateRefused tells why Update changed no row: the transaction is missing,
+// its version changed, or its refunds do not fit the new amount or date.
+func (r *SQLiteTransactionRepository) updateRefused(ctx context.Context, transaction *model.Transaction) error {
+	var version int
+	var refunded int64
+	var firstRefund string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT version,
+			(SELECT COALESCE(SUM(amount_minor), 0) FROM refunds WHERE transaction_id = transactions.id),
+			(SELECT COALESCE(MIN(refund_date), '') FROM refunds WHERE transaction_id = transactions.id)
+		FROM transactions WHERE id = ? AND deleted_at IS NULL`, transaction.ID).
+		Scan(&version, &refunded, &firstRefund)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if version != transaction.Version {
+		return ErrVersionConflict
+	}
+
+	if err := refundsConflict(transaction, refunded, firstRefund); err != nil {
+		return err
+	}
+	// The refunds fit now: the transaction was changed in the meantime.
+	return ErrVersionConflict
+}
+
+// refundsConflict returns ErrBelowRefunds and ErrAfterRefunds, joined, when the
+// refunds of the transaction, totalling refunded and the earliest dated
+// firstRefund ("" without refunds), do not fit its amount and date.
+func refundsConflict(transaction *model.Transaction, refunded int64, firstRefund string) error {
+	var errs []error
+	if transaction.Amount.Amount < refunded {
+		errs = append(errs, ErrBelowRefunds)
+	}
+	if firstRefund != "" && firstRefund < transaction.TransactionDate {
+		errs = append(errs, ErrAfterRefunds)
+	}
+	return errors.Join(errs...)
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTransaction reads a row selected with transactionColumns.
+func scanTransaction(row rowScanner) (*model.Transaction, error) {
+	var transaction model.Transaction
+	err := row.Scan(&transaction.ID, &transaction.Description, &transaction.Amount.Amount, &transaction.Amount.Currency, &transaction.TransactionDate,
+		&transaction.MerchantName, &transaction.MerchantCategoryCode, &transaction.Category, &transaction.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mvfavila/transactions/model"
+)
+
+var (
+	// ErrNotFound is returned when the requested transaction does not exist.
+	ErrNotFound = errors.New("transaction not found")
+	// ErrBatchAborted is returned by CreateBatch in BatchAtomic mode when an item
+	// could not be stored, in which case none of them is.
+	ErrBatchAborted = errors.New("batch aborted")
+	// ErrVersionConflict is returned when a transaction was changed by someone else
+	// since the version the caller based its change on.
+	ErrVersionConflict = errors.New("transaction version conflict")
+	// ErrBelowRefunds is returned when an update would make the amount of a
+	// transaction lower than its refunds.
+	ErrBelowRefunds = errors.New("transaction amount below its refunds")
+	// ErrAfterRefunds is returned when an update would date a transaction after
+	// one of its refunds.
+	ErrAfterRefunds = errors.New("transaction date after its refunds")
+)
+
+// defaultListLimit is the number of transactions returned by List when no limit is given.
+const defaultListLimit = 100
+
+// ListOptions controls which transactions are returned by TransactionRepository.List.
+// Zero values disable the corresponding filter.
+type ListOptions struct {
+	// Limit is the maximum number of transactions returned. Zero means defaultListLimit.
+	Limit int
+	// FromDate and ToDate bound the transaction date (inclusive, YYYY-MM-DD).
+	FromDate string
+	ToDate   string
+	// MinAmount and MaxAmount bound the amount in minor units (inclusive).
+	MinAmount *int64
+	MaxAmount *int64
+	// Description only matches transactions whose description contains it, ignoring case.
+	Description string
+	// Merchant only matches transactions whose merchant name contains it, ignoring case.
+	Merchant string
+	// MerchantCategoryCode only matches transactions with this merchant category code.
+	MerchantCategoryCode string
+	// Categories only matches transactions in any of these categories. Callers
+	// expand a category to its subtree with CategoryRepository.Subtree.
+	Categories []string
+	// Tags only matches transactions having every one of these tags.
+	Tags []string
+	// SortBy is the field transactions are ordered by. Empty means SortByID.
+	SortBy SortField
+	// Descending reverses the sort order.
+	Descending bool
+	// After continues a previous listing right after the transaction it points to.
+	After *Cursor
+}
+
+// ListPage is a page of transactions returned by TransactionRepository.List.
+type ListPage struct {
+	Transactions []model.Transaction
+	// Next points to the last transaction of the page when more transactions
+	// follow, nil otherwise.
+	Next *Cursor
+}
+
+// BatchMode selects how CreateBatch handles items that cannot be stored.
+type BatchMode string
+
+const (
+	// BatchAtomic stores either every item or none of them.
+	BatchAtomic BatchMode = "atomic"
+	// BatchPartial stores every item it can and reports the others.
+	BatchPartial BatchMode = "partial"
+)
+
+// TransactionRepository stores and retrieves purchase transactions.
+//
+// Every stored transaction has a version, starting at 1 and incremented by each
+// change, used for optimistic concurrency control. Deleted transactions are kept
+// (soft delete) and can be restored, but are otherwise treated as missing.
+type TransactionRepository interface {
+	// Create stores a new transaction and sets its ID and version.
+	Create(ctx context.Context, transaction *model.Transaction) error
+	// CreateBatch stores several transactions at once, setting their IDs and
+	// versions. It returns one error per item, nil for the stored ones. In
+	// BatchAtomic mode it returns ErrBatchAborted when any item failed.
+	CreateBatch(ctx context.Context, transactions []*model.Transaction, mode BatchMode) ([]error, error)
+	// GetByID returns the transaction with the given ID or ErrNotFound.
+	GetByID(ctx context.Context, id int) (*model.Transaction, error)
+	// List returns a page of transactions matching the options. It returns
+	// ErrInvalidCursor when options.After does not match the requested sort order.
+	List(ctx context.Context, options ListOptions) (ListPage, error)
+	// Update replaces the stored transaction with the same ID, provided its
+	// version is still transaction.Version, and increments the version. It
+	// returns ErrNotFound or ErrVersionConflict otherwise, and ErrBelowRefunds
+	// or ErrAfterRefunds (or both) when the refunds of the transaction would no
+	// longer fit its amount or date.
+	Update(ctx context.Context, transaction *model.Transaction) error
+	// Delete soft deletes the transaction with the given ID and version and
+	// returns its new version, or ErrNotFound or ErrVersionConflict.
+	Delete(ctx context.Context, id int, version int) (int, error)
+	// Restore undeletes the deleted transaction with the given ID and version,
+	// or returns ErrNotFound or ErrVersionConflict.
+	Restore(ctx context.Context, id int, version int) (*model.Transaction, error)
+}
+
+// limitOrDefault returns the limit to apply for the given options.
+func (o ListOptions) limitOrDefault() int {
+	if o.Limit <= 0 {
+		return defaultListLimit
+	}
+	return o.Limit
+}
+
+// sortByOrDefault returns the sort field to apply for the given options.
+func (o ListOptions) sortByOrDefault() SortField {
+	if o.SortBy == "" {
+		return SortByID
+	}
+	return o.SortBy
+}
+
+// validateCursor checks that the cursor, if any, was created for the same sort order.
+func (o ListOptions) validateCursor() error {
+	if o.After != nil && (o.After.SortBy != o.sortByOrDefault() || o.After.Descending != o.Descending) {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// newListPage trims the transactions fetched with one extra row to the limit and
+// sets the cursor of the next page when the extra row was present.
+func newListPage(transactions []model.Transaction, options ListOptions) ListPage {
+	limit := options.limitOrDefault()
+	if len(transactions) <= limit {
+		return ListPage{Transactions: transactions}
+	}
+
+	transactions = transactions[:limit]
+	return ListPage{
+		Transactions: transactions,
+		Next:         cursorFor(transactions[limit-1], options.sortByOrDefault(), options.Descending),
+	}
+}
@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+// newTestRepositories returns every TransactionRepository implementation, backed by empty storage.
+func newTestRepositories(t *testing.T) map[string]TransactionRepository {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	ApplyMigrations(db)
+
+	return map[string]TransactionRepository{
+		"sqlite":    NewSQLiteTransactionRepository(db),
+		"in-memory": NewInMemoryTransactionRepository(),
+	}
+}
+
+func TestTransactionRepository(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			first := &model.Transaction{Description: "First", Amount: util.USD(1234), TransactionDate: "2020-01-01"}
+			second := &model.Transaction{Description: "Second", Amount: util.USD(1), TransactionDate: "2020-02-01"}
+			require.NoError(t, repo.Create(ctx, first))
+			require.NoError(t, repo.Create(ctx, second))
+			assert.NotZero(t, first.ID)
+			assert.Greater(t, second.ID, first.ID)
+
+			got, err := repo.GetByID(ctx, first.ID)
+			require.NoError(t, err)
+			assert.Equal(t, first, got)
+
+			page, err := repo.List(ctx, ListOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, []model.Transaction{*first, *second}, page.Transactions)
+			assert.Nil(t, page.Next)
+
+			second.Description = "Second, edited"
+			require.NoError(t, repo.Update(ctx, second))
+			assert.Equal(t, 2, second.Version)
+			got, err = repo.GetByID(ctx, second.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "Second, edited", got.Description)
+			assert.Equal(t, 2, got.Version)
+
+			// A change based on a stale version is rejected.
+			stale := *second
+			stale.Version = 1
+			assert.ErrorIs(t, repo.Update(ctx, &stale), ErrVersionConflict)
+			_, err = repo.Delete(ctx, second.ID, 1)
+			assert.ErrorIs(t, err, ErrVersionConflict)
+
+			version, err := repo.Delete(ctx, first.ID, first.Version)
+			require.NoError(t, err)
+			assert.Equal(t, 2, version)
+			_, err = repo.GetByID(ctx, first.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = repo.Delete(ctx, first.ID, version)
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.ErrorIs(t, repo.Update(ctx, first), ErrNotFound)
+			page, err = repo.List(ctx, ListOptions{})
+			require.NoError(t, err)
+			assert.Len(t, page.Transactions, 1)
+
+			_, err = repo.Restore(ctx, first.ID, 1)
+			assert.ErrorIs(t, err, ErrVersionConflict)
+			_, err = repo.Restore(ctx, second.ID, second.Version)
+			assert.ErrorIs(t, err, ErrNotFound)
+			restored, err := repo.Restore(ctx, first.ID, version)
+			require.NoError(t, err)
+			assert.Equal(t, 3, restored.Version)
+			assert.Equal(t, "First", restored.Description)
+		})
+	}
+}
+
+func TestTransactionRepositoryList(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			fixtures := []*model.Transaction{
+				{Description: "Coffee beans", Amount: util.USD(1500), TransactionDate: "2024-01-10"},
+				{Description: "Office chair", Amount: util.USD(25000), TransactionDate: "2024-01-05"},
+				{Description: "COFFEE machine", Amount: util.USD(1500), TransactionDate: "2024-02-01"},
+				{Description: "Train ticket", Amount: util.USD(4200), TransactionDate: "2024-03-15"},
+			}
+			for _, transaction := range fixtures {
+				require.NoError(t, repo.Create(ctx, transaction))
+			}
+
+			ids := func(transactions []model.Transaction) []int {
+				result := []int{}
+				for _, transaction := range transactions {
+					result = append(result, transaction.ID)
+				}
+				return result
+			}
+
+			minAmount, maxAmount := int64(1000), int64(5000)
+			page, err := repo.List(ctx, ListOptions{
+				FromDate:    "2024-01-06",
+				ToDate:      "2024-03-31",
+				MinAmount:   &minAmount,
+				MaxAmount:   &maxAmount,
+				Description: "coffee",
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []int{fixtures[0].ID, fixtures[2].ID}, ids(page.Transactions))
+
+			// Walk every page sorted by amount, descending, two transactions at a time.
+			options := ListOptions{SortBy: SortByAmount, Descending: true, Limit: 2}
+			var walked []int
+			for {
+				page, err := repo.List(ctx, options)
+				require.NoError(t, err)
+				walked = append(walked, ids(page.Transactions)...)
+				if page.Next == nil {
+					break
+				}
+				decoded, err := DecodeCursor(page.Next.Encode())
+				require.NoError(t, err)
+				options.After = decoded
+			}
+			assert.Equal(t, []int{fixtures[1].ID, fixtures[3].ID, fixtures[2].ID, fixtures[0].ID}, walked)
+
+			page, err = repo.List(ctx, ListOptions{SortBy: SortByDate, Limit: 3})
+			require.NoError(t, err)
+			assert.Equal(t, []int{fixtures[1].ID, fixtures[0].ID, fixtures[2].ID}, ids(page.Transactions))
+			require.NotNil(t, page.Next)
+
+			_, err = repo.List(ctx, ListOptions{SortBy: SortByAmount, After: page.Next})
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestTransactionRepositoryMetadata(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			fixtures := []*model.Transaction{
+				{Description: "Groceries", Amount: util.USD(5000), TransactionDate: "2024-01-10", MerchantName: "Corner Market",
+					MerchantCategoryCode: "5411", Category: "food-groceries", Tags: []string{"home", "weekly"}},
+				{Description: "Dinner", Amount: util.USD(3000), TransactionDate: "2024-01-11", MerchantName: "Supermarket Bistro",
+					MerchantCategoryCode: "5812", Category: "food-restaurants", Tags: []string{"weekly"}},
+				{Description: "Taxi", Amount: util.USD(2000), TransactionDate: "2024-01-12"},
+			}
+			require.NoError(t, repo.Create(ctx, fixtures[0]))
+			_, err := repo.CreateBatch(ctx, fixtures[1:], BatchAtomic)
+			require.NoError(t, err)
+
+			for _, transaction := range fixtures {
+				got, err := repo.GetByID(ctx, transaction.ID)
+				require.NoError(t, err)
+				assert.Equal(t, transaction, got)
+			}
+
+			ids := func(options ListOptions) []int {
+				page, err := repo.List(ctx, options)
+				require.NoError(t, err)
+				result := []int{}
+				for _, transaction := range page.Transactions {
+					result = append(result, transaction.ID)
+				}
+				return result
+			}
+			assert.Equal(t, []int{fixtures[0].ID, fixtures[1].ID}, ids(ListOptions{Merchant: "MARKET"}))
+			assert.Equal(t, []int{fixtures[1].ID}, ids(ListOptions{MerchantCategoryCode: "5812"}))
+			assert.Equal(t, []int{fixtures[0].ID, fixtures[1].ID}, ids(ListOptions{Categories: []string{"food-groceries", "food-restaurants"}}))
+			assert.Equal(t, []int{fixtures[0].ID, fixtures[1].ID}, ids(ListOptions{Tags: []string{"weekly"}}))
+			assert.Equal(t, []int{fixtures[0].ID}, ids(ListOptions{Tags: []string{"weekly", "home"}}))
+			assert.Equal(t, []int{}, ids(ListOptions{Tags: []string{"unused"}}))
+
+			// Updating replaces the tags and clears the category.
+			fixtures[0].Tags = []string{"monthly"}
+			fixtures[0].Category = ""
+			require.NoError(t, repo.Update(ctx, fixtures[0]))
+			got, err := repo.GetByID(ctx, fixtures[0].ID)
+			require.NoError(t, err)
+			assert.Equal(t, fixtures[0], got)
+			assert.Equal(t, []int{fixtures[1].ID}, ids(ListOptions{Tags: []string{"weekly"}}))
+		})
+	}
+}
+
+func TestTransactionRepositoryCreateBatch(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			batch := []*model.Transaction{
+				{Description: "First", Amount: util.USD(100), TransactionDate: "2020-01-01"},
+				{Description: "Second", Amount: util.USD(200), TransactionDate: "2020-01-02"},
+			}
+			itemErrs, err := repo.CreateBatch(ctx, batch, BatchAtomic)
+			require.NoError(t, err)
+			assert.Equal(t, []error{nil, nil}, itemErrs)
+			assert.NotZero(t, batch[0].ID)
+			assert.Equal(t, batch[0].ID+1, batch[1].ID)
+			assert.Equal(t, 1, batch[1].Version)
+		})
+	}
+}
+
+func TestSQLiteTransactionRepositoryCreateBatchFailures(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepositories(t)["sqlite"]
+
+	// The description column rejects more than 50 characters, so the second item fails to insert.
+	newBatch := func() []*model.Transaction {
+		return []*model.Transaction{
+			{Description: "Valid", Amount: util.USD(100), TransactionDate: "2020-01-01"},
+			{Description: "This is exactly 51 chars long. Oooooops, too long!!", Amount: util.USD(200), TransactionDate: "2020-01-02"},
+			{Description: "Also valid", Amount: util.USD(300), TransactionDate: "2020-01-03"},
+		}
+	}
+
+	itemErrs, err := repo.CreateBatch(ctx, newBatch(), BatchAtomic)
+	assert.ErrorIs(t, err, ErrBatchAborted)
+	assert.Error(t, itemErrs[1])
+	page, err := repo.List(ctx, ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Transactions)
+
+	batch := newBatch()
+	itemErrs, err = repo.CreateBatch(ctx, batch, BatchPartial)
+	require.NoError(t, err)
+	assert.NoError(t, itemErrs[0])
+	assert.Error(t, itemErrs[1])
+	assert.NoError(t, itemErrs[2])
+	assert.Zero(t, batch[1].ID)
+
+	page, err = repo.List(ctx, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 2)
+	assert.Equal(t, batch[0].ID, page.Transactions[0].ID)
+	assert.Equal(t, batch[2].ID, page.Transactions[1].ID)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ConversionLeg is a conversion to or from the US dollar, one of the steps of a
+// CrossConversion.
+type ConversionLeg struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// ExchangeRate is the rate of the foreign currency of the leg, in units per US dollar.
+	ExchangeRate      float64    `json:"exchange_rate"`
+	Amount            util.Money `json:"amount"`
+	Country           string     `json:"country"`
+	RateCurrency      string     `json:"rate_currency"`
+	RateEffectiveDate string     `json:"rate_effective_date"`
+	RateRecordDate    string     `json:"rate_record_date"`
+	RateSource        string     `json:"rate_source"`
+	RateMatch         RateMatch  `json:"rate_match"`
+}
+
+// CrossConversion is an amount converted from one currency to another by
+// triangulating through the US dollar, the currency Treasury rates are quoted against.
+type CrossConversion struct {
+	Amount          util.Money `json:"amount"`
+	ConvertedAmount util.Money `json:"converted_amount"`
+	// ExchangeRate is the cross rate, in units of the target currency per unit of the source currency.
+	ExchangeRate float64           `json:"exchange_rate"`
+	Date         string            `json:"date"`
+	Policy       ConversionPolicy  `json:"policy"`
+	Rounding     util.RoundingMode `json:"rounding"`
+	// Legs lists the conversion from the source currency to the US dollar and
+	// from the US dollar to the target currency, leaving out the US dollar itself.
+	Legs []ConversionLeg `json:"legs"`
+}
+
+// CrossConvert converts the amount, in the source currency, to the target
+// currency with the rates of both currencies selected by the policy on the date:
+//   - foreign to US dollar divides by the rate of the source currency;
+//   - US dollar to foreign multiplies by the rate of the target currency;
+//   - foreign to foreign does both, triangulating through the US dollar.
+//
+// The converted amount is computed from the exact cross rate and rounded once
+// with the mode; the US dollar amount of the first leg is rounded to the cent
+// for reporting only. It returns ErrNoRate when a currency has no rate under the policy.
+func CrossConvert(ctx context.Context, provider ExchangeRateProvider, amount util.Money, source util.Currency, target util.Currency, date time.Time, policy ConversionPolicy, rounding util.RoundingMode) (*CrossConversion, error) {
+	conversion := &CrossConversion{
+		Amount:   amount,
+		Date:     date.Format(config.AppConfig.ExpectedDateFormat),
+		Policy:   policy,
+		Rounding: rounding,
+		Legs:     []ConversionLeg{},
+	}
+
+	if source.Code == target.Code {
+		conversion.ConvertedAmount, conversion.ExchangeRate = amount, 1
+		return conversion, nil
+	}
+
+	// Look up the rate of each foreign currency
+	fromRate, toRate := 1.0, 1.0
+	var toUSD, fromUSD *ConversionLeg
+	if source.Code != util.USDCurrency {
+		leg, err := conversionLeg(ctx, provider, source, date, policy)
+		if err != nil {
+			return nil, err
+		}
+		leg.From, leg.To = source.Code, util.USDCurrency
+		fromRate, toUSD = leg.ExchangeRate, &leg
+	}
+	if target.Code != util.USDCurrency {
+		leg, err := conversionLeg(ctx, provider, target, date, policy)
+		if err != nil {
+			return nil, err
+		}
+		leg.From, leg.To = util.USDCurrency, target.Code
+		toRate, fromUSD = leg.ExchangeRate, &leg
+	}
+
+	var err error
+	if conversion.ConvertedAmount, err = amount.Convert(fromRate, toRate, target.Code, rounding); err != nil {
+		return nil, err
+	}
+	conversion.ExchangeRate = toRate / fromRate
+
+	if toUSD != nil {
+		if toUSD.Amount, err = amount.Convert(fromRate, 1, util.USDCurrency, rounding); err != nil {
+			return nil, err
+		}
+		conversion.Legs = append(conversion.Legs, *toUSD)
+	}
+	if fromUSD != nil {
+		fromUSD.Amount = conversion.ConvertedAmount
+		conversion.Legs = append(conversion.Legs, *fromUSD)
+	}
+
+	return conversion, nil
+}
+
+// conversionLeg looks up the rate of the currency on the date under the policy.
+func conversionLeg(ctx context.Context, provider ExchangeRateProvider, currency util.Currency, date time.Time, policy ConversionPolicy) (ConversionLeg, error) {
+	rate, match, err := ResolveRate(ctx, provider, currency.Country, date, policy)
+	if err != nil {
+		return ConversionLeg{}, fmt.Errorf("%s: %w", currency.Code, err)
+	}
+
+	return ConversionLeg{
+		ExchangeRate:      rate.ExchangeRate,
+		Country:           currency.Country,
+		RateCurrency:      rate.Currency,
+		RateEffectiveDate: rate.EffectiveDate,
+		RateRecordDate:    rate.RecordDate,
+		RateSource:        rate.Source,
+		RateMatch:         match,
+	}, nil
+}
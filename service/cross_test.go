@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/util"
+)
+
+func TestCrossConvert(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	provider, err := LoadFileProvider(writeFile(t, "rates.csv", `country,currency,exchange_rate,effective_date
+Euro Zone,Euro,0.9,2024-03-31
+Canada,Dollar,1.35,2024-03-31
+Japan,Yen,149.55,2024-03-31
+`))
+	require.NoError(t, err)
+
+	currency := func(code string) util.Currency {
+		c, ok := util.LookupCurrency(code)
+		require.True(t, ok, code)
+		return c
+	}
+	convert := func(amount util.Money, target string) (*CrossConversion, error) {
+		return CrossConvert(context.Background(), provider, amount, currency(amount.Currency), currency(target), date("2024-04-15"), DefaultConversionPolicy, util.RoundHalfUp)
+	}
+
+	t.Run("foreign to foreign", func(t *testing.T) {
+		conversion, err := convert(util.Money{Amount: 12000, Currency: "EUR"}, "CAD")
+		require.NoError(t, err)
+		assert.Equal(t, util.Money{Amount: 18000, Currency: "CAD"}, conversion.ConvertedAmount)
+		assert.InDelta(t, 1.5, conversion.ExchangeRate, 1e-9)
+		assert.Equal(t, "2024-04-15", conversion.Date)
+
+		require.Len(t, conversion.Legs, 2)
+		assert.Equal(t, ConversionLeg{
+			From:              "EUR",
+			To:                "USD",
+			ExchangeRate:      0.9,
+			Amount:            util.USD(13333),
+			Country:           "Euro Zone",
+			RateCurrency:      "Euro",
+			RateEffectiveDate: "2024-03-31",
+			RateSource:        FileSource,
+			RateMatch:         MatchLookback,
+		}, conversion.Legs[0])
+		assert.Equal(t, "USD", conversion.Legs[1].From)
+		assert.Equal(t, "CAD", conversion.Legs[1].To)
+		assert.Equal(t, 1.35, conversion.Legs[1].ExchangeRate)
+		assert.Equal(t, conversion.ConvertedAmount, conversion.Legs[1].Amount)
+	})
+
+	t.Run("foreign to US dollar", func(t *testing.T) {
+		conversion, err := convert(util.Money{Amount: 10000, Currency: "JPY"}, "USD")
+		require.NoError(t, err)
+		assert.Equal(t, util.USD(6687), conversion.ConvertedAmount) // 10000 / 149.55 = 66.867
+		require.Len(t, conversion.Legs, 1)
+		assert.Equal(t, "JPY", conversion.Legs[0].From)
+	})
+
+	t.Run("US dollar to foreign", func(t *testing.T) {
+		conversion, err := convert(util.USD(1234), "JPY")
+		require.NoError(t, err)
+		assert.Equal(t, util.Money{Amount: 1845, Currency: "JPY"}, conversion.ConvertedAmount)
+		require.Len(t, conversion.Legs, 1)
+		assert.Equal(t, "USD", conversion.Legs[0].From)
+	})
+
+	t.Run("same currency", func(t *testing.T) {
+		conversion, err := convert(util.Money{Amount: 12000, Currency: "EUR"}, "EUR")
+		require.NoError(t, err)
+		assert.Equal(t, util.Money{Amount: 12000, Currency: "EUR"}, conversion.ConvertedAmount)
+		assert.Equal(t, 1.0, conversion.ExchangeRate)
+		assert.Empty(t, conversion.Legs)
+	})
+
+	t.Run("no rate", func(t *testing.T) {
+		_, err := convert(util.Money{Amount: 12000, Currency: "EUR"}, "MXN")
+		assert.ErrorIs(t, err, ErrNoRate)
+		assert.ErrorContains(t, err, "MXN")
+	})
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/util"
+)
+
+// ECBSource identifies rates derived from a European Central Bank reference rates file.
+const ECBSource = "ecb"
+
+// ecbRateDecimals is the number of decimals kept when deriving USD based rates from EUR based ones.
+const ecbRateDecimals = 6
+
+// ecbEnvelope is the structure of the ECB euro foreign exchange reference rates
+// XML files (eurofxref-daily.xml, eurofxref-hist.xml).
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ECBProvider is an ExchangeRateProvider serving rates from an ECB-style XML
+// file, keyed by ISO 4217 currency code and looked up through the currency catalogue.
+//
+// ECB reference rates are quoted in units of currency per euro, so they are
+// converted to units of currency per US dollar using the USD rate of the same day.
+type ECBProvider struct {
+	rates rateTable
+}
+
+// LoadECBProvider reads the rates of an ECB-style XML file.
+func LoadECBProvider(path string) (*ECBProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates file %s: %w", path, err)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.Unmarshal(content, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse ECB rates file %s: %w", path, err)
+	}
+
+	table := rateTable{}
+	for _, day := range envelope.Days {
+		if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, day.Time); err != nil {
+			return nil, fmt.Errorf("invalid ECB rates date %q in %s", day.Time, path)
+		}
+
+		perEUR := map[string]*big.Rat{"EUR": big.NewRat(1, 1)}
+		for _, rate := range day.Rates {
+			value, ok := new(big.Rat).SetString(strings.TrimSpace(rate.Rate))
+			if !ok || value.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid ECB rate %q for %s on %s", rate.Rate, rate.Currency, day.Time)
+			}
+			perEUR[strings.ToUpper(rate.Currency)] = value
+		}
+
+		usd, ok := perEUR["USD"]
+		if !ok {
+			// Without the USD quote of the day no rate of that day can be derived.
+			continue
+		}
+
+		for currency, value := range perEUR {
+			if currency == "USD" {
+				continue
+			}
+			perUSD, _ := strconv.ParseFloat(new(big.Rat).Quo(value, usd).FloatString(ecbRateDecimals), 64)
+			var country string
+			if known, ok := util.LookupCurrency(currency); ok {
+				country = known.Country
+			}
+			table.add(currency, Rate{
+				Country:       country,
+				Currency:      currency,
+				ExchangeRate:  perUSD,
+				EffectiveDate: day.Time,
+				RecordDate:    day.Time,
+				Source:        ECBSource,
+			})
+		}
+	}
+	table.sort()
+
+	return &ECBProvider{rates: table}, nil
+}
+
+// RateOn returns the most recent ECB derived rate for the currency within the
+// lookback window. The currency is a Treasury country name of the currency
+// catalogue or an ISO currency code.
+func (p *ECBProvider) RateOn(_ context.Context, currency string, date time.Time, lookback Lookback) (Rate, error) {
+	return p.rates.rateOn(ecbCurrencyCode(currency), lookback.From(date), date)
+}
+
+// RateAfter returns the earliest ECB derived rate for the currency effective
+// after the date, within the window.
+func (p *ECBProvider) RateAfter(_ context.Context, currency string, date time.Time, window Lookback) (Rate, error) {
+	return p.rates.rateAfter(ecbCurrencyCode(currency), date, window.Until(date))
+}
+
+// RatesBetween returns the ECB derived rates for the currency effective between the dates.
+func (p *ECBProvider) RatesBetween(_ context.Context, currency string, from time.Time, to time.Time) ([]Rate, error) {
+	return p.rates.between(ecbCurrencyCode(currency), from, to), nil
+}
+
+// ecbCurrencyCode returns the ISO code of a Treasury country name of the
+// currency catalogue or an ISO currency code.
+func ecbCurrencyCode(currency string) string {
+	if known, ok := util.LookupCurrency(currency); ok {
+		return known.Code
+	}
+	return strings.ToUpper(currency)
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+)
+
+// FileSource identifies rates read from a static CSV or JSON file.
+const FileSource = "file"
+
+// rateTable holds exchange rates in memory, indexed by currency identifier and
+// sorted by descending effective date.
+type rateTable map[string][]Rate
+
+// add stores a rate under the given currency identifier.
+func (t rateTable) add(key string, rate Rate) {
+	t[key] = append(t[key], rate)
+}
+
+// sort orders the rates of every currency by descending effective date.
+func (t rateTable) sort() {
+	for _, rates := range t {
+		sort.SliceStable(rates, func(i, j int) bool { return rates[i].EffectiveDate > rates[j].EffectiveDate })
+	}
+}
+
+// rateOn returns the most recent rate effective between from and to, inclusive.
+func (t rateTable) rateOn(key string, from time.Time, to time.Time) (Rate, error) {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	lower, upper := from.Format(dateFormat), to.Format(dateFormat)
+
+	for _, rate := range t[key] {
+		if rate.EffectiveDate > upper {
+			continue
+		}
+		if rate.EffectiveDate < lower {
+			break
+		}
+		return rate, nil
+	}
+
+	return Rate{}, ErrNoRate
+}
+
+// rateAfter returns the earliest rate effective after the date and on or before to.
+func (t rateTable) rateAfter(key string, date time.Time, to time.Time) (Rate, error) {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	lower, upper := date.Format(dateFormat), to.Format(dateFormat)
+
+	rates := t[key]
+	for i := len(rates) - 1; i >= 0; i-- {
+		if rates[i].EffectiveDate <= lower {
+			continue
+		}
+		if rates[i].EffectiveDate > upper {
+			break
+		}
+		return rates[i], nil
+	}
+
+	return Rate{}, ErrNoRate
+}
+
+// between returns the rates effective between from and to, inclusive, most recent first.
+func (t rateTable) between(key string, from time.Time, to time.Time) []Rate {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	lower, upper := from.Format(dateFormat), to.Format(dateFormat)
+
+	rates := []Rate{}
+	for _, rate := range t[key] {
+		if rate.EffectiveDate > upper {
+			continue
+		}
+		if rate.EffectiveDate < lower {
+			break
+		}
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+// FileProvider is an ExchangeRateProvider serving rates from a static file, in
+// the same shape as the Treasury dataset, keyed by Treasury country name.
+type FileProvider struct {
+	rates rateTable
+}
+
+// LoadFileProvider reads the rates of a .csv or .json file.
+//
+// A CSV file must have a header row with at least the columns country, currency,
+// exchange_rate and effective_date. A JSON file holds either an array of objects
+// with the same fields or a Treasury API response (an object with a data array).
+func LoadFileProvider(path string) (*FileProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates file %s: %w", path, err)
+	}
+
+	var rates []TreasuryRate
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rates, err = parseRatesCSV(bytes.NewReader(content))
+	case ".json":
+		rates, err = parseRatesJSON(content)
+	default:
+		err = fmt.Errorf("unsupported extension, expected .csv or .json")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rates file %s: %w", path, err)
+	}
+
+	table := rateTable{}
+	for _, rate := range rates {
+		table.add(rate.Country, Rate{
+			Country:       rate.Country,
+			Currency:      rate.Currency,
+			ExchangeRate:  rate.ExchangeRate,
+			EffectiveDate: rate.EffectiveDate,
+			RecordDate:    rate.RecordDate,
+			Source:        FileSource,
+		})
+	}
+	table.sort()
+
+	return &FileProvider{rates: table}, nil
+}
+
+// RateOn returns the most recent rate of the file for the country within the lookback window.
+func (p *FileProvider) RateOn(_ context.Context, country string, date time.Time, lookback Lookback) (Rate, error) {
+	return p.rates.rateOn(country, lookback.From(date), date)
+}
+
+// RateAfter returns the earliest rate of the file for the country effective after the date, within the window.
+func (p *FileProvider) RateAfter(_ context.Context, country string, date time.Time, window Lookback) (Rate, error) {
+	return p.rates.rateAfter(country, date, window.Until(date))
+}
+
+// RatesBetween returns the rates of the file for the country effective between the dates.
+func (p *FileProvider) RatesBetween(_ context.Context, country string, from time.Time, to time.Time) ([]Rate, error) {
+	return p.rates.between(country, from, to), nil
+}
+
+// parseRatesCSV reads rates from CSV with a header row.
+func parseRatesCSV(r io.Reader) ([]TreasuryRate, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"country", "currency", "exchange_rate", "effective_date"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	rates := make([]TreasuryRate, 0, len(records)-1)
+	for line, record := range records[1:] {
+		exchangeRate, err := strconv.ParseFloat(strings.TrimSpace(record[columns["exchange_rate"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid exchange_rate: %w", line+2, err)
+		}
+
+		effectiveDate := strings.TrimSpace(record[columns["effective_date"]])
+		if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, effectiveDate); err != nil {
+			return nil, fmt.Errorf("line %d: effective_date must be in YYYY-MM-DD format", line+2)
+		}
+
+		rate := TreasuryRate{
+			Country:       strings.TrimSpace(record[columns["country"]]),
+			Currency:      strings.TrimSpace(record[columns["currency"]]),
+			ExchangeRate:  exchangeRate,
+			EffectiveDate: effectiveDate,
+		}
+		if column, ok := columns["record_date"]; ok {
+			rate.RecordDate = strings.TrimSpace(record[column])
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+// parseRatesJSON reads rates from a JSON array or a Treasury API response.
+func parseRatesJSON(content []byte) ([]TreasuryRate, error) {
+	var rates []TreasuryRate
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &rates); err != nil {
+			return nil, err
+		}
+	} else {
+		var response TreasuryResponse
+		if err := json.Unmarshal(trimmed, &response); err != nil {
+			return nil, err
+		}
+		rates = response.Data
+	}
+
+	for i, rate := range rates {
+		if _, err := time.Parse(config.AppConfig.ExpectedDateFormat, rate.EffectiveDate); err != nil {
+			return nil, fmt.Errorf("rate %d: effective_date must be in YYYY-MM-DD format", i)
+		}
+	}
+
+	return rates, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+)
+
+// LocalSource identifies rates served from the local copy of the Treasury dataset.
+const LocalSource = "treasury-local"
+
+// LocalProvider is an ExchangeRateProvider answering from the rates synchronised
+// locally by RateSyncer, keyed by Treasury country name. It never calls the
+// Treasury API.
+type LocalProvider struct {
+	rates repository.RateRepository
+}
+
+// NewLocalProvider creates a provider reading from the given rate repository.
+func NewLocalProvider(rates repository.RateRepository) *LocalProvider {
+	return &LocalProvider{rates: rates}
+}
+
+// RateOn returns the most recent locally stored rate for the country within the lookback window.
+func (p *LocalProvider) RateOn(ctx context.Context, country string, date time.Time, lookback Lookback) (Rate, error) {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	return localRate(p.rates.LatestRate(ctx, country, lookback.From(date).Format(dateFormat), date.Format(dateFormat)))
+}
+
+// RateAfter returns the earliest locally stored rate for the country effective after the date, within the window.
+func (p *LocalProvider) RateAfter(ctx context.Context, country string, date time.Time, window Lookback) (Rate, error) {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	return localRate(p.rates.EarliestRate(ctx, country, date.AddDate(0, 0, 1).Format(dateFormat), window.Until(date).Format(dateFormat)))
+}
+
+// localRate converts a rate found in the repository.
+func localRate(rate *model.ExchangeRate, err error) (Rate, error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		return Rate{}, ErrNoRate
+	}
+	if err != nil {
+		return Rate{}, err
+	}
+
+	return Rate{
+		Country:       rate.Country,
+		Currency:      rate.Currency,
+		ExchangeRate:  rate.ExchangeRate,
+		EffectiveDate: rate.EffectiveDate,
+		RecordDate:    rate.RecordDate,
+		Source:        LocalSource,
+	}, nil
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/util"
+)
+
+// RateMatch tells how the rate applied to a conversion was found.
+type RateMatch string
+
+// Rate matches reported by ResolveRate.
+const (
+	// MatchExactDate is a rate effective on the transaction date, required by the exact date policy.
+	MatchExactDate RateMatch = "exact_date"
+	// MatchLookback is the most recent rate effective within the lookback window before the transaction.
+	MatchLookback RateMatch = "lookback"
+	// MatchLater is the earliest rate effective after the transaction, within the lookback window.
+	MatchLater RateMatch = "later"
+	// MatchLatest is the most recent rate effective before the lookback window.
+	MatchLatest RateMatch = "latest"
+	// MatchIdentity is the rate 1 of the US dollar, which rates are quoted against.
+	MatchIdentity RateMatch = "identity"
+)
+
+// IdentitySource is the source of the US dollar rate, which is never looked up.
+const IdentitySource = "identity"
+
+// latestLookback is the window searched when falling back to the latest available rate.
+var latestLookback = Lookback{Months: 100 * 12}
+
+// ConversionPolicy selects the exchange rate applied to a transaction. Accounting
+// standards differ on how old a rate may be and on whether rates published after
+// the purchase may be used.
+type ConversionPolicy struct {
+	// Lookback is how long before the transaction date a rate may have become effective.
+	Lookback Lookback `json:"lookback"`
+	// AllowLaterRates uses the earliest rate effective after the transaction
+	// date, within the lookback window, when there is none before it.
+	AllowLaterRates bool `json:"allow_later_rates"`
+	// ExactDate only accepts a rate effective on the transaction date.
+	ExactDate bool `json:"exact_date"`
+	// FallbackToLatest uses the most recent rate effective before the transaction
+	// date, however old, when there is none within the lookback window.
+	FallbackToLatest bool `json:"fallback_to_latest"`
+}
+
+// DefaultConversionPolicy applies the most recent rate within DefaultLookback.
+var DefaultConversionPolicy = ConversionPolicy{Lookback: DefaultLookback}
+
+// NewConversionPolicy reads the policy of the conversion configuration. An empty
+// lookback defaults to DefaultLookback.
+func NewConversionPolicy(cfg config.ConversionConfig) (ConversionPolicy, error) {
+	policy := ConversionPolicy{
+		Lookback:         DefaultLookback,
+		AllowLaterRates:  cfg.AllowLaterRates,
+		ExactDate:        cfg.ExactDate,
+		FallbackToLatest: cfg.FallbackToLatest,
+	}
+	if cfg.Lookback != "" {
+		var err error
+		if policy.Lookback, err = ParseLookback(cfg.Lookback); err != nil {
+			return ConversionPolicy{}, err
+		}
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate checks that the options of the policy can be combined.
+func (p ConversionPolicy) Validate() error {
+	if p.ExactDate && (p.AllowLaterRates || p.FallbackToLatest) {
+		return errors.New("exact_date cannot be combined with allow_later_rates or fallback_to_latest")
+	}
+	return nil
+}
+
+// Model returns the policy as recorded in the audit trail.
+func (p ConversionPolicy) Model() model.ConversionPolicy {
+	return model.ConversionPolicy{
+		Lookback:         p.Lookback.String(),
+		AllowLaterRates:  p.AllowLaterRates,
+		ExactDate:        p.ExactDate,
+		FallbackToLatest: p.FallbackToLatest,
+	}
+}
+
+// ResolveRate returns the rate of the currency to apply on the date under the
+// policy, and how it was found. In order, it looks for:
+//   - with ExactDate, a rate effective on the date, and nothing else;
+//   - the most recent rate effective within the lookback window before the date;
+//   - with AllowLaterRates, the earliest rate effective after the date, within
+//     the lookback window, when the provider is a LaterRateProvider;
+//   - with FallbackToLatest, the most recent rate effective before the window.
+//
+// The US dollar, which rates are quoted against, has no Treasury rate: its rate
+// is always 1 (MatchIdentity). It returns ErrNoRate when none of them exists.
+func ResolveRate(ctx context.Context, provider ExchangeRateProvider, currency string, date time.Time, policy ConversionPolicy) (Rate, RateMatch, error) {
+	if err := policy.Validate(); err != nil {
+		return Rate{}, "", err
+	}
+
+	if usd, _ := util.LookupCurrency(util.USDCurrency); currency == usd.Country {
+		return Rate{
+			Country:       usd.Country,
+			Currency:      usd.Name,
+			ExchangeRate:  1,
+			EffectiveDate: date.Format(config.AppConfig.ExpectedDateFormat),
+			Source:        IdentitySource,
+		}, MatchIdentity, nil
+	}
+
+	if policy.ExactDate {
+		rate, err := provider.RateOn(ctx, currency, date, Lookback{})
+		return rate, MatchExactDate, err
+	}
+
+	rate, err := provider.RateOn(ctx, currency, date, policy.Lookback)
+	if !errors.Is(err, ErrNoRate) {
+		return rate, MatchLookback, err
+	}
+
+	if later, ok := provider.(LaterRateProvider); ok && policy.AllowLaterRates {
+		rate, err = later.RateAfter(ctx, currency, date, policy.Lookback)
+		if !errors.Is(err, ErrNoRate) {
+			return rate, MatchLater, err
+		}
+	}
+
+	if policy.FallbackToLatest {
+		rate, err = provider.RateOn(ctx, currency, date, latestLookback)
+		if !errors.Is(err, ErrNoRate) {
+			return rate, MatchLatest, err
+		}
+	}
+
+	return Rate{}, "", fmt.Errorf("%w under the conversion policy", ErrNoRate)
+}
+
+// resolveRateIn selects the rate of the date among rates listed by a
+// RangeRateProvider, most recent first, like ResolveRate but without the
+// FallbackToLatest step: the rates must cover the lookback window before the
+// date and, with AllowLaterRates, the window following it. It returns ErrNoRate
+// when none of them applies.
+func resolveRateIn(rates []Rate, date time.Time, policy ConversionPolicy) (Rate, RateMatch, error) {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	day := date.Format(dateFormat)
+
+	if policy.ExactDate {
+		for _, rate := range rates {
+			if rate.EffectiveDate == day {
+				return rate, MatchExactDate, nil
+			}
+		}
+		return Rate{}, "", ErrNoRate
+	}
+
+	from := policy.Lookback.From(date).Format(dateFormat)
+	for _, rate := range rates {
+		if rate.EffectiveDate <= day && rate.EffectiveDate >= from {
+			return rate, MatchLookback, nil
+		}
+	}
+
+	if policy.AllowLaterRates {
+		until := policy.Lookback.Until(date).Format(dateFormat)
+		for i := len(rates) - 1; i >= 0; i-- {
+			if rates[i].EffectiveDate > day && rates[i].EffectiveDate <= until {
+				return rates[i], MatchLater, nil
+			}
+		}
+	}
+
+	return Rate{}, "", ErrNoRate
+}
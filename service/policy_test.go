@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+)
+
+func TestParseLookback(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Lookback
+		text     string
+	}{
+		{"6m", Lookback{Months: 6}, "6m"},
+		{"90d", Lookback{Days: 90}, "90d"},
+		{"1y", Lookback{Months: 12}, "12m"},
+		{"1Y2m15d", Lookback{Months: 14, Days: 15}, "14m15d"},
+		{"0d", Lookback{}, "0d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			lookback, err := ParseLookback(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, lookback)
+			assert.Equal(t, tt.text, lookback.String())
+		})
+	}
+
+	for _, value := range []string{"", "6", "m", "6w", "2d1m", "-6m", "1000y"} {
+		_, err := ParseLookback(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNewConversionPolicy(t *testing.T) {
+	policy, err := NewConversionPolicy(config.ConversionConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConversionPolicy, policy)
+
+	policy, err = NewConversionPolicy(config.ConversionConfig{Lookback: "90d", AllowLaterRates: true})
+	require.NoError(t, err)
+	assert.Equal(t, ConversionPolicy{Lookback: Lookback{Days: 90}, AllowLaterRates: true}, policy)
+
+	_, err = NewConversionPolicy(config.ConversionConfig{Lookback: "six months"})
+	assert.Error(t, err)
+
+	_, err = NewConversionPolicy(config.ConversionConfig{ExactDate: true, FallbackToLatest: true})
+	assert.Error(t, err)
+}
+
+func TestResolveRate(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	provider, err := LoadFileProvider(writeFile(t, "rates.csv", `country,currency,exchange_rate,effective_date
+Canada,Dollar,1.25,2023-12-31
+Canada,Dollar,1.30,2024-09-30
+Canada,Dollar,1.35,2024-12-31
+Canada,Dollar,1.40,2025-03-31
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		date     string
+		policy   ConversionPolicy
+		expected string
+		match    RateMatch
+	}{
+		{"within lookback", "2025-01-13", DefaultConversionPolicy, "2024-12-31", MatchLookback},
+		{"shorter lookback", "2025-01-13", ConversionPolicy{Lookback: Lookback{Days: 10}}, "", ""},
+		{"exact date", "2024-12-31", ConversionPolicy{ExactDate: true}, "2024-12-31", MatchExactDate},
+		{"exact date missing", "2025-01-13", ConversionPolicy{Lookback: DefaultLookback, ExactDate: true}, "", ""},
+		{"earlier rate preferred to later one", "2025-01-13", ConversionPolicy{Lookback: DefaultLookback, AllowLaterRates: true}, "2024-12-31", MatchLookback},
+		{"later rate", "2025-01-13", ConversionPolicy{Lookback: Lookback{Days: 10}, AllowLaterRates: true}, "", ""},
+		{"later rate within lookback", "2025-03-01", ConversionPolicy{Lookback: Lookback{Days: 40}, AllowLaterRates: true}, "2025-03-31", MatchLater},
+		{"earliest later rate", "2024-09-01", ConversionPolicy{Lookback: Lookback{Months: 4}, AllowLaterRates: true}, "2024-09-30", MatchLater},
+		{"later rate beyond lookback", "2024-06-01", ConversionPolicy{Lookback: Lookback{Months: 3}, AllowLaterRates: true}, "", ""},
+		{"fallback to latest", "2024-06-01", ConversionPolicy{Lookback: Lookback{Months: 1}, FallbackToLatest: true}, "2023-12-31", MatchLatest},
+		{"later rate before fallback", "2024-06-01", ConversionPolicy{Lookback: Lookback{Months: 4}, AllowLaterRates: true, FallbackToLatest: true}, "2024-09-30", MatchLater},
+		{"nothing before", "2023-06-01", ConversionPolicy{Lookback: DefaultLookback, FallbackToLatest: true}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, match, err := ResolveRate(context.Background(), provider, "Canada", date(tt.date), tt.policy)
+			if tt.expected == "" {
+				assert.ErrorIs(t, err, ErrNoRate)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, rate.EffectiveDate)
+			assert.Equal(t, tt.match, match)
+		})
+	}
+
+	// The US dollar is never looked up
+	rate, match, err := ResolveRate(context.Background(), provider, "United States", date("2025-01-13"), DefaultConversionPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, rate.ExchangeRate)
+	assert.Equal(t, "2025-01-13", rate.EffectiveDate)
+	assert.Equal(t, MatchIdentity, match)
+
+	_, _, err = ResolveRate(context.Background(), provider, "Canada", date("2025-01-13"), ConversionPolicy{ExactDate: true, AllowLaterRates: true})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoRate)
+}
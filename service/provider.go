@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/repository"
+)
+
+// ErrNoRate is returned when no exchange rate is available within the lookback window.
+var ErrNoRate = errors.New("no exchange rate available")
+
+// ErrRangeUnsupported is returned by RangeRateProvider wrappers whose wrapped
+// provider cannot list the rates of a period.
+var ErrRangeUnsupported = errors.New("the provider cannot list the rates of a period")
+
+// Provider types selectable with the rate_provider.type setting.
+const (
+	ProviderTreasury = "treasury"
+	ProviderFile     = "file"
+	ProviderECB      = "ecb"
+	ProviderLocal    = "local"
+)
+
+// Rate is an exchange rate, in units of the target currency per US dollar.
+type Rate struct {
+	Country       string
+	Currency      string
+	ExchangeRate  float64
+	EffectiveDate string
+	// RecordDate is the date the rate was published, when the source provides it.
+	RecordDate string
+	Source     string
+}
+
+// Lookback is how far before a date an exchange rate may have become effective.
+type Lookback struct {
+	Months int
+	Days   int
+}
+
+// DefaultLookback is the window mandated by the Treasury Reporting Rates of Exchange rules.
+var DefaultLookback = Lookback{Months: 6}
+
+// From returns the earliest date, within the lookback window, that ends on the given date.
+func (l Lookback) From(date time.Time) time.Time {
+	return date.AddDate(0, -l.Months, -l.Days)
+}
+
+// Until returns the latest date, within the lookback window, that starts on the given date.
+func (l Lookback) Until(date time.Time) time.Time {
+	return date.AddDate(0, l.Months, l.Days)
+}
+
+// String returns the window in the format read by ParseLookback, e.g. "6m" or "1m15d".
+func (l Lookback) String() string {
+	var s string
+	if l.Months > 0 {
+		s += strconv.Itoa(l.Months) + "m"
+	}
+	if l.Days > 0 || s == "" {
+		s += strconv.Itoa(l.Days) + "d"
+	}
+	return s
+}
+
+// MarshalText encodes the window as its String representation.
+func (l Lookback) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// lookbackPattern matches windows such as "1y", "6m", "90d" or "1y2m15d".
+var lookbackPattern = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)m)?(?:(\d+)d)?$`)
+
+// ParseLookback parses a window given in years, months and days, in that order,
+// e.g. "6m", "90d" or "1y2m". A year counts as 12 months.
+func ParseLookback(value string) (Lookback, error) {
+	match := lookbackPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil || match[0] == "" {
+		return Lookback{}, fmt.Errorf("invalid lookback %q, expected years, months and days such as 6m, 90d or 1y2m", value)
+	}
+
+	var parts [3]int
+	for i, digits := range match[1:] {
+		if digits == "" {
+			continue
+		}
+		n, err := strconv.Atoi(digits)
+		if err != nil || n > maxLookbackDays {
+			return Lookback{}, fmt.Errorf("invalid lookback %q, the window is too long", value)
+		}
+		parts[i] = n
+	}
+
+	lookback := Lookback{Months: parts[0]*12 + parts[1], Days: parts[2]}
+	if lookback.Months*31+lookback.Days > maxLookbackDays {
+		return Lookback{}, fmt.Errorf("invalid lookback %q, the window is too long", value)
+	}
+	return lookback, nil
+}
+
+// maxLookbackDays bounds the windows accepted by ParseLookback to about 100 years.
+const maxLookbackDays = 100 * 366
+
+// ExchangeRateProvider is a source of official exchange rates.
+type ExchangeRateProvider interface {
+	// RateOn returns the most recent rate for the currency that became effective
+	// on or before the given date, but not earlier than the lookback window
+	// allows. It returns ErrNoRate when there is no such rate.
+	//
+	// The currency is identified by its Treasury country name (e.g. "Canada"),
+	// see util.LookupCurrency to resolve ISO 4217 codes. The ECB provider also
+	// accepts ISO codes.
+	RateOn(ctx context.Context, currency string, date time.Time, lookback Lookback) (Rate, error)
+}
+
+// LaterRateProvider is implemented by providers that can also look up rates
+// effective after a date, for conversion policies allowing later-dated rates.
+type LaterRateProvider interface {
+	// RateAfter returns the earliest rate for the currency that became effective
+	// after the given date, but not later than the window allows. It returns
+	// ErrNoRate when there is no such rate.
+	RateAfter(ctx context.Context, currency string, date time.Time, window Lookback) (Rate, error)
+}
+
+// RangeRateProvider is implemented by providers that can list every rate of a
+// currency effective within a period, so that the rates of many dates are found
+// with a single lookup.
+type RangeRateProvider interface {
+	// RatesBetween returns the rates of the currency effective between from and
+	// to (inclusive), most recent first.
+	RatesBetween(ctx context.Context, currency string, from time.Time, to time.Time) ([]Rate, error)
+}
+
+// NewExchangeRateProvider creates the provider selected in the configuration.
+// The Treasury provider is only used by the treasury type and the rate
+// repository only by the local provider.
+func NewExchangeRateProvider(cfg config.RateProviderConfig, treasury *TreasuryProvider, rates repository.RateRepository) (ExchangeRateProvider, error) {
+	switch cfg.Type {
+	case "", ProviderTreasury:
+		return treasury, nil
+	case ProviderFile:
+		return LoadFileProvider(cfg.Path)
+	case ProviderECB:
+		return LoadECBProvider(cfg.Path)
+	case ProviderLocal:
+		return NewLocalProvider(rates), nil
+	default:
+		return nil, fmt.Errorf("unknown rate provider type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+)
+
+func TestLookbackFrom(t *testing.T) {
+	tests := []struct {
+		lookback Lookback
+		date     string
+		expected string
+	}{
+		{DefaultLookback, "2020-01-01", "2019-07-01"},
+		{DefaultLookback, "2020-02-29", "2019-08-29"},
+		{Lookback{Days: 30}, "2020-03-01", "2020-01-31"},
+		{Lookback{}, "2020-03-01", "2020-03-01"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.lookback.From(date(tt.date)).Format("2006-01-02"))
+	}
+}
+
+// writeFile writes a temporary file with the given name and content and returns its path.
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestFileProvider(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	csvPath := writeFile(t, "rates.csv", `country,currency,exchange_rate,effective_date,record_date
+Canada,Dollar,1.30,2024-09-30,2024-09-30
+Canada,Dollar,1.35,2024-12-31,2024-12-31
+Mexico,Peso,20.1,2024-12-31,2024-12-31
+`)
+	jsonPath := writeFile(t, "rates.json", `{"data": [
+		{"country": "Canada", "currency": "Dollar", "exchange_rate": "1.30", "effective_date": "2024-09-30"},
+		{"country": "Canada", "currency": "Dollar", "exchange_rate": "1.35", "effective_date": "2024-12-31", "record_date": "2024-12-31"}
+	]}`)
+
+	for _, path := range []string{csvPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			provider, err := LoadFileProvider(path)
+			require.NoError(t, err)
+
+			rate, err := provider.RateOn(context.Background(), "Canada", date("2025-01-13"), DefaultLookback)
+			require.NoError(t, err)
+			assert.Equal(t, Rate{Country: "Canada", Currency: "Dollar", ExchangeRate: 1.35, EffectiveDate: "2024-12-31", RecordDate: "2024-12-31", Source: FileSource}, rate)
+
+			rate, err = provider.RateOn(context.Background(), "Canada", date("2024-12-30"), DefaultLookback)
+			require.NoError(t, err)
+			assert.Equal(t, "2024-09-30", rate.EffectiveDate)
+
+			_, err = provider.RateOn(context.Background(), "Canada", date("2024-09-29"), DefaultLookback)
+			assert.ErrorIs(t, err, ErrNoRate)
+			_, err = provider.RateOn(context.Background(), "Canada", date("2025-06-01"), Lookback{Days: 30})
+			assert.ErrorIs(t, err, ErrNoRate)
+		})
+	}
+
+	_, err := LoadFileProvider(writeFile(t, "rates.csv", "country,currency\nCanada,Dollar\n"))
+	assert.ErrorContains(t, err, "missing column exchange_rate")
+	_, err = LoadFileProvider(writeFile(t, "rates.txt", ""))
+	assert.ErrorContains(t, err, "unsupported extension")
+}
+
+func TestECBProvider(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	path := writeFile(t, "eurofxref-hist.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2024-01-03">
+			<Cube currency="USD" rate="1.25"/>
+			<Cube currency="GBP" rate="0.86"/>
+		</Cube>
+		<Cube time="2024-01-02">
+			<Cube currency="USD" rate="1.10"/>
+			<Cube currency="JPY" rate="155.5"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`)
+
+	provider, err := LoadECBProvider(path)
+	require.NoError(t, err)
+
+	rate, err := provider.RateOn(context.Background(), "eur", date("2024-01-05"), DefaultLookback)
+	require.NoError(t, err)
+	assert.Equal(t, Rate{Country: "Euro Zone", Currency: "EUR", ExchangeRate: 0.8, EffectiveDate: "2024-01-03", RecordDate: "2024-01-03", Source: ECBSource}, rate)
+
+	rate, err = provider.RateOn(context.Background(), "GBP", date("2024-01-03"), DefaultLookback)
+	require.NoError(t, err)
+	assert.Equal(t, 0.688, rate.ExchangeRate)
+
+	rate, err = provider.RateOn(context.Background(), "JPY", date("2024-01-05"), DefaultLookback)
+	require.NoError(t, err)
+	assert.Equal(t, 141.363636, rate.ExchangeRate)
+
+	// Treasury country names are resolved through the currency catalogue
+	rate, err = provider.RateOn(context.Background(), "Japan", date("2024-01-05"), DefaultLookback)
+	require.NoError(t, err)
+	assert.Equal(t, 141.363636, rate.ExchangeRate)
+
+	_, err = provider.RateOn(context.Background(), "GBP", date("2024-01-02"), DefaultLookback)
+	assert.ErrorIs(t, err, ErrNoRate)
+}
+
+func TestNewExchangeRateProvider(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	treasury := NewTreasuryProvider(http.DefaultClient, config.AppConfig.TreasuryAPIBaseURL)
+	provider, err := NewExchangeRateProvider(config.RateProviderConfig{}, treasury, nil)
+	require.NoError(t, err)
+	assert.Same(t, treasury, provider)
+
+	_, err = NewExchangeRateProvider(config.RateProviderConfig{Type: "oracle"}, treasury, nil)
+	assert.ErrorContains(t, err, `unknown rate provider type "oracle"`)
+}
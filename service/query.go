@@ -0,0 +1,105 @@
+package service
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Query builds the query string of a fiscaldata.treasury.gov API request.
+//
+// Filters, fields and sort orders are joined with commas as the API expects and
+// every value is URL encoded, so values such as "Euro Zone" or "Bosnia-Herzegovina"
+// are sent verbatim. The API has no escape for commas inside filter values.
+type Query struct {
+	filters    []string
+	fields     []string
+	sort       []string
+	format     string
+	pageNumber int
+	pageSize   int
+}
+
+// NewQuery returns an empty query.
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Eq adds a filter keeping the records whose field equals the value.
+func (q *Query) Eq(field, value string) *Query {
+	return q.filter(field, "eq", value)
+}
+
+// In adds a filter keeping the records whose field equals one of the values.
+func (q *Query) In(field string, values ...string) *Query {
+	return q.filter(field, "in", "("+strings.Join(values, ",")+")")
+}
+
+// Gte adds a filter keeping the records whose field is greater than or equal to the value.
+func (q *Query) Gte(field, value string) *Query {
+	return q.filter(field, "gte", value)
+}
+
+// Lte adds a filter keeping the records whose field is less than or equal to the value.
+func (q *Query) Lte(field, value string) *Query {
+	return q.filter(field, "lte", value)
+}
+
+// Fields restricts the columns returned by the API.
+func (q *Query) Fields(fields ...string) *Query {
+	q.fields = append(q.fields, fields...)
+	return q
+}
+
+// Sort orders the records by the fields, in descending order for fields prefixed with "-".
+func (q *Query) Sort(fields ...string) *Query {
+	q.sort = append(q.sort, fields...)
+	return q
+}
+
+// Page requests the page with the given number (starting at 1) and size.
+func (q *Query) Page(number, size int) *Query {
+	q.pageNumber = number
+	q.pageSize = size
+	return q
+}
+
+// Format sets the response format (json, csv or xml).
+func (q *Query) Format(format string) *Query {
+	q.format = format
+	return q
+}
+
+// Encode returns the URL encoded query string, without the leading "?".
+func (q *Query) Encode() string {
+	var params []string
+	add := func(key, value string) {
+		params = append(params, key+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+	}
+
+	if len(q.filters) > 0 {
+		add("filter", strings.Join(q.filters, ","))
+	}
+	if len(q.fields) > 0 {
+		add("fields", strings.Join(q.fields, ","))
+	}
+	if len(q.sort) > 0 {
+		add("sort", strings.Join(q.sort, ","))
+	}
+	if q.format != "" {
+		add("format", q.format)
+	}
+	if q.pageNumber > 0 {
+		add("page[number]", strconv.Itoa(q.pageNumber))
+	}
+	if q.pageSize > 0 {
+		add("page[size]", strconv.Itoa(q.pageSize))
+	}
+
+	return strings.Join(params, "&")
+}
+
+func (q *Query) filter(field, operator, value string) *Query {
+	q.filters = append(q.filters, field+":"+operator+":"+value)
+	return q
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryEncode(t *testing.T) {
+	var queryTests = []struct {
+		name     string
+		query    *Query
+		expected url.Values
+	}{
+		{"empty", NewQuery(), url.Values{}},
+		{
+			"filters",
+			NewQuery().Eq("country", "Euro Zone").In("currency", "Dollar", "Peso").Gte("record_date", "2024-01-01").Lte("record_date", "2024-12-31"),
+			url.Values{"filter": {"country:eq:Euro Zone,currency:in:(Dollar,Peso),record_date:gte:2024-01-01,record_date:lte:2024-12-31"}},
+		},
+		{
+			"fields, sort, format and page",
+			NewQuery().Fields("country", "exchange_rate").Sort("-effective_date", "country").Format("json").Page(3, 100),
+			url.Values{
+				"fields":       {"country,exchange_rate"},
+				"sort":         {"-effective_date,country"},
+				"format":       {"json"},
+				"page[number]": {"3"},
+				"page[size]":   {"100"},
+			},
+		},
+		{
+			"reserved characters",
+			NewQuery().Eq("country", "Côte d'Ivoire & co=1+1?#"),
+			url.Values{"filter": {"country:eq:Côte d'Ivoire & co=1+1?#"}},
+		},
+	}
+
+	for _, tt := range queryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := tt.query.Encode()
+			assert.NotContains(t, encoded, " ")
+
+			values, err := url.ParseQuery(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+)
+
+// CacheStats are the counters of a CachingProvider.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Coalesced uint64 `json:"coalesced"`
+	Entries   int    `json:"entries"`
+}
+
+// cacheKey identifies a lookup: the currency, the date window it covers and
+// whether the earliest rate after the window start was asked for.
+type cacheKey struct {
+	currency string
+	from     string
+	to       string
+	later    bool
+}
+
+// cacheEntry is a cached lookup result. err is only ever nil or ErrNoRate.
+type cacheEntry struct {
+	key     cacheKey
+	rate    Rate
+	err     error
+	expires time.Time
+}
+
+// inflightCall is a lookup being performed on behalf of every concurrent caller
+// asking for the same key.
+type inflightCall struct {
+	done chan struct{}
+	rate Rate
+	err  error
+}
+
+// CachingProvider is an ExchangeRateProvider that caches the results of another
+// provider. Published rates do not change, so lookups for the same currency and
+// date window are answered from memory until they expire.
+//
+// Concurrent identical lookups are coalesced into a single call to the wrapped
+// provider, "no rate" results are cached as well (usually for a shorter time),
+// and the least recently used entries are evicted beyond the size bound. Other
+// errors are never cached.
+type CachingProvider struct {
+	next        ExchangeRateProvider
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[cacheKey]*list.Element
+	lru      *list.List
+	inflight map[cacheKey]*inflightCall
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// NewCachingProvider wraps the provider with a cache. Rates are kept for ttl and
+// "no rate" results for negativeTTL; maxEntries bounds the number of entries
+// (zero means unbounded).
+func NewCachingProvider(next ExchangeRateProvider, ttl time.Duration, negativeTTL time.Duration, maxEntries int) *CachingProvider {
+	return &CachingProvider{
+		next:        next,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		now:         time.Now,
+		entries:     map[cacheKey]*list.Element{},
+		lru:         list.New(),
+		inflight:    map[cacheKey]*inflightCall{},
+	}
+}
+
+// RateOn returns the cached result for the currency and date window, or looks it
+// up with the wrapped provider.
+func (p *CachingProvider) RateOn(ctx context.Context, currency string, date time.Time, lookback Lookback) (Rate, error) {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	key := cacheKey{currency: currency, from: lookback.From(date).Format(dateFormat), to: date.Format(dateFormat)}
+
+	return p.lookup(ctx, key, func(ctx context.Context) (Rate, error) {
+		return p.next.RateOn(ctx, currency, date, lookback)
+	})
+}
+
+// RateAfter returns the cached result for the currency and date window, or looks
+// it up with the wrapped provider. It returns ErrNoRate when the wrapped provider
+// cannot look up later-dated rates.
+func (p *CachingProvider) RateAfter(ctx context.Context, currency string, date time.Time, window Lookback) (Rate, error) {
+	next, ok := p.next.(LaterRateProvider)
+	if !ok {
+		return Rate{}, ErrNoRate
+	}
+
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	key := cacheKey{currency: currency, from: date.Format(dateFormat), to: window.Until(date).Format(dateFormat), later: true}
+
+	return p.lookup(ctx, key, func(ctx context.Context) (Rate, error) {
+		return next.RateAfter(ctx, currency, date, window)
+	})
+}
+
+// RatesBetween lists the rates of the period with the wrapped provider, or
+// returns ErrRangeUnsupported when it cannot. Lists are not cached: they are
+// looked up once for a whole statement.
+func (p *CachingProvider) RatesBetween(ctx context.Context, currency string, from time.Time, to time.Time) ([]Rate, error) {
+	next, ok := p.next.(RangeRateProvider)
+	if !ok {
+		return nil, ErrRangeUnsupported
+	}
+	return next.RatesBetween(ctx, currency, from, to)
+}
+
+// errLookupPanicked is returned to the callers coalesced on a lookup that panicked.
+var errLookupPanicked = errors.New("exchange rate lookup panicked")
+
+// lookup returns the cached result for the key, or calls fetch once on behalf
+// of every concurrent caller asking for the same key.
+//
+// fetch runs detached from the cancellation of the caller that started it, with
+// the deadline of the Treasury client calls instead (treasury_client.timeout),
+// so that a caller going away does not fail the lookup of the others.
+func (p *CachingProvider) lookup(ctx context.Context, key cacheKey, fetch func(ctx context.Context) (Rate, error)) (Rate, error) {
+	p.mu.Lock()
+	if element, ok := p.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if p.now().Before(entry.expires) {
+			p.lru.MoveToFront(element)
+			p.mu.Unlock()
+			p.hits.Add(1)
+			return entry.rate, entry.err
+		}
+		p.removeElement(element)
+	}
+
+	if call, ok := p.inflight[key]; ok {
+		p.mu.Unlock()
+		p.coalesced.Add(1)
+		select {
+		case <-call.done:
+			return call.rate, call.err
+		case <-ctx.Done():
+			return Rate{}, ctx.Err()
+		}
+	}
+
+	call := &inflightCall{done: make(chan struct{}), err: errLookupPanicked}
+	p.inflight[key] = call
+	p.mu.Unlock()
+	p.misses.Add(1)
+
+	// Release the waiters even if fetch panics, in which case call.err is left
+	// to errLookupPanicked.
+	defer func() {
+		p.mu.Lock()
+		delete(p.inflight, key)
+		switch {
+		case call.err == nil:
+			p.store(&cacheEntry{key: key, rate: call.rate, expires: p.now().Add(p.ttl)})
+		case errors.Is(call.err, ErrNoRate) && p.negativeTTL > 0:
+			p.store(&cacheEntry{key: key, err: ErrNoRate, expires: p.now().Add(p.negativeTTL)})
+		}
+		p.mu.Unlock()
+		close(call.done)
+	}()
+
+	fetchCtx := context.WithoutCancel(ctx)
+	if timeout := config.AppConfig.TreasuryClient.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(fetchCtx, timeout)
+		defer cancel()
+	}
+	call.rate, call.err = fetch(fetchCtx)
+
+	return call.rate, call.err
+}
+
+// Stats returns the current counters of the cache.
+func (p *CachingProvider) Stats() CacheStats {
+	p.mu.Lock()
+	entries := p.lru.Len()
+	p.mu.Unlock()
+
+	return CacheStats{
+		Hits:      p.hits.Load(),
+		Misses:    p.misses.Load(),
+		Coalesced: p.coalesced.Load(),
+		Entries:   entries,
+	}
+}
+
+// store adds an entry, evicting the least recently used ones beyond maxEntries.
+// The caller must hold p.mu.
+func (p *CachingProvider) store(entry *cacheEntry) {
+	p.entries[entry.key] = p.lru.PushFront(entry)
+	for p.maxEntries > 0 && p.lru.Len() > p.maxEntries {
+		p.removeElement(p.lru.Back())
+	}
+}
+
+// removeElement removes an entry from the cache. The caller must hold p.mu.
+func (p *CachingProvider) removeElement(element *list.Element) {
+	p.lru.Remove(element)
+	delete(p.entries, element.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+)
+
+// countingProvider returns a fixed result and counts its calls. When release is
+// set, calls block until it is closed.
+type countingProvider struct {
+	calls   atomic.Int32
+	rate    Rate
+	err     error
+	release chan struct{}
+}
+
+func (p *countingProvider) RateOn(_ context.Context, _ string, _ time.Time, _ Lookback) (Rate, error) {
+	p.calls.Add(1)
+	if p.release != nil {
+		<-p.release
+	}
+	return p.rate, p.err
+}
+
+// blockingProvider waits for release, or for its context to end, and panics
+// when panics is set.
+type blockingProvider struct {
+	release chan struct{}
+	panics  bool
+}
+
+func (p *blockingProvider) RateOn(ctx context.Context, _ string, _ time.Time, _ Lookback) (Rate, error) {
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return Rate{}, ctx.Err()
+	}
+	if p.panics {
+		panic("provider failure")
+	}
+	return Rate{ExchangeRate: 1.35}, nil
+}
+
+func TestCachingProvider(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+	ctx := context.Background()
+
+	t.Run("caches rates until they expire", func(t *testing.T) {
+		next := &countingProvider{rate: Rate{Country: "Canada", ExchangeRate: 1.35}}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+
+		for i := 0; i < 3; i++ {
+			rate, err := cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+			require.NoError(t, err)
+			assert.Equal(t, 1.35, rate.ExchangeRate)
+		}
+		assert.Equal(t, int32(1), next.calls.Load())
+
+		// Another date window is another entry.
+		_, err := cache.RateOn(ctx, "Canada", date("2025-01-14"), DefaultLookback)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), next.calls.Load())
+
+		now = now.Add(2 * time.Hour)
+		_, err = cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), next.calls.Load())
+
+		assert.Equal(t, CacheStats{Hits: 2, Misses: 3, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("caches no rate results but not failures", func(t *testing.T) {
+		next := &countingProvider{err: ErrNoRate}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+
+		for i := 0; i < 2; i++ {
+			_, err := cache.RateOn(ctx, "Atlantis", date("2025-01-13"), DefaultLookback)
+			assert.ErrorIs(t, err, ErrNoRate)
+		}
+		assert.Equal(t, int32(1), next.calls.Load())
+
+		next = &countingProvider{err: errors.New("treasury down")}
+		cache = NewCachingProvider(next, time.Hour, time.Minute, 0)
+		for i := 0; i < 2; i++ {
+			_, err := cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+			assert.Error(t, err)
+		}
+		assert.Equal(t, int32(2), next.calls.Load())
+	})
+
+	t.Run("evicts least recently used entries", func(t *testing.T) {
+		next := &countingProvider{rate: Rate{ExchangeRate: 1}}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 2)
+
+		for _, currency := range []string{"A", "B", "A", "C", "A", "B"} {
+			_, err := cache.RateOn(ctx, currency, date("2025-01-13"), DefaultLookback)
+			require.NoError(t, err)
+		}
+		// B was evicted by C and had to be looked up again.
+		assert.Equal(t, int32(4), next.calls.Load())
+		assert.Equal(t, 2, cache.Stats().Entries)
+	})
+
+	t.Run("coalesces concurrent identical lookups", func(t *testing.T) {
+		next := &countingProvider{rate: Rate{ExchangeRate: 1.35}, release: make(chan struct{})}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+
+		const callers = 20
+		var wg sync.WaitGroup
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rate, err := cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+				assert.NoError(t, err)
+				assert.Equal(t, 1.35, rate.ExchangeRate)
+			}()
+		}
+
+		require.Eventually(t, func() bool { return cache.Stats().Coalesced == callers-1 }, time.Second, time.Millisecond)
+		close(next.release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), next.calls.Load())
+	})
+	t.Run("completes coalesced lookups when the first caller goes away", func(t *testing.T) {
+		next := &blockingProvider{release: make(chan struct{})}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		leader := make(chan error)
+		go func() {
+			_, err := cache.RateOn(leaderCtx, "Canada", date("2025-01-13"), DefaultLookback)
+			leader <- err
+		}()
+		require.Eventually(t, func() bool { return cache.Stats().Misses == 1 }, time.Second, time.Millisecond)
+
+		waiter := make(chan error)
+		go func() {
+			rate, err := cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+			assert.Equal(t, 1.35, rate.ExchangeRate)
+			waiter <- err
+		}()
+		require.Eventually(t, func() bool { return cache.Stats().Coalesced == 1 }, time.Second, time.Millisecond)
+
+		cancel()
+		close(next.release)
+		assert.NoError(t, <-waiter)
+		assert.NoError(t, <-leader)
+	})
+
+	t.Run("releases coalesced lookups when the lookup panics", func(t *testing.T) {
+		next := &blockingProvider{release: make(chan struct{}), panics: true}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+
+		go func() {
+			defer func() { recover() }()
+			cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		}()
+		require.Eventually(t, func() bool { return cache.Stats().Misses == 1 }, time.Second, time.Millisecond)
+
+		waiter := make(chan error)
+		go func() {
+			_, err := cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+			waiter <- err
+		}()
+		require.Eventually(t, func() bool { return cache.Stats().Coalesced == 1 }, time.Second, time.Millisecond)
+
+		close(next.release)
+		assert.ErrorIs(t, <-waiter, errLookupPanicked)
+
+		// The lookup is not left in flight.
+		next.panics = false
+		rate, err := cache.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		require.NoError(t, err)
+		assert.Equal(t, 1.35, rate.ExchangeRate)
+	})
+
+	t.Run("caches later-dated rates separately", func(t *testing.T) {
+		next, err := LoadFileProvider(writeFile(t, "rates.csv", `country,currency,exchange_rate,effective_date
+Canada,Dollar,1.30,2024-09-30
+Canada,Dollar,1.35,2024-12-31
+`))
+		require.NoError(t, err)
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+
+		for i := 0; i < 2; i++ {
+			rate, err := cache.RateAfter(ctx, "Canada", date("2024-09-01"), DefaultLookback)
+			require.NoError(t, err)
+			assert.Equal(t, "2024-09-30", rate.EffectiveDate)
+		}
+		rate, err := cache.RateOn(ctx, "Canada", date("2024-12-31"), DefaultLookback)
+		require.NoError(t, err)
+		assert.Equal(t, "2024-12-31", rate.EffectiveDate)
+		assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("has no later-dated rates without provider support", func(t *testing.T) {
+		next := &countingProvider{rate: Rate{Country: "Canada", ExchangeRate: 1.35}}
+		cache := NewCachingProvider(next, time.Hour, time.Minute, 0)
+
+		_, err := cache.RateAfter(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		assert.ErrorIs(t, err, ErrNoRate)
+		assert.Equal(t, int32(0), next.calls.Load())
+	})
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// defaultSyncPageSize is the number of Treasury records requested per page when syncing.
+const defaultSyncPageSize = 1000
+
+// SyncResult summarises a rate synchronisation.
+type SyncResult struct {
+	Pages          int
+	Rates          int
+	LastRecordDate string
+}
+
+// RateSyncer copies the Treasury Reporting Rates of Exchange dataset into a RateRepository.
+type RateSyncer struct {
+	treasury *TreasuryProvider
+	rates    repository.RateRepository
+	pageSize int
+}
+
+// NewRateSyncer creates a syncer reading from the Treasury provider. A page size
+// of zero means defaultSyncPageSize.
+func NewRateSyncer(treasury *TreasuryProvider, rates repository.RateRepository, pageSize int) *RateSyncer {
+	if pageSize <= 0 {
+		pageSize = defaultSyncPageSize
+	}
+	return &RateSyncer{treasury: treasury, rates: rates, pageSize: pageSize}
+}
+
+// Sync pages through the Treasury records published since the last record_date
+// stored locally (the whole dataset on the first run) and upserts them.
+//
+// The last record date itself is requested again, so that records of a quarter
+// that was only partially published are completed.
+func (s *RateSyncer) Sync(ctx context.Context) (SyncResult, error) {
+	since, err := s.rates.LastRecordDate(ctx)
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("failed to read last synced record date: %w", err)
+	}
+
+	result := SyncResult{LastRecordDate: since}
+	query := NewQuery().
+		Fields(treasuryRateFields...).
+		Sort("record_date", "country_currency_desc")
+	if since != "" {
+		query.Gte("record_date", since)
+	}
+
+	err = s.treasury.forEachPage(ctx, query, s.pageSize, 0, func(page TreasuryResponse) error {
+		rates := make([]model.ExchangeRate, 0, len(page.Data))
+		for _, rate := range page.Data {
+			rates = append(rates, model.ExchangeRate{
+				Country:             rate.Country,
+				Currency:            rate.Currency,
+				CountryCurrencyDesc: rate.CountryCurrencyDesc,
+				ExchangeRate:        rate.ExchangeRate,
+				EffectiveDate:       rate.EffectiveDate,
+				RecordDate:          rate.RecordDate,
+			})
+			if rate.RecordDate > result.LastRecordDate {
+				result.LastRecordDate = rate.RecordDate
+			}
+		}
+
+		if err := s.rates.UpsertRates(ctx, rates); err != nil {
+			return fmt.Errorf("failed to store page %d of Treasury rates: %w", result.Pages+1, err)
+		}
+
+		result.Pages++
+		result.Rates += len(rates)
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to sync Treasury rates: %w", err)
+	}
+
+	return result, nil
+}
+
+// RunPeriodically syncs immediately and then at every interval until the context is done.
+func (s *RateSyncer) RunPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if result, err := s.Sync(ctx); err != nil {
+			util.ErrorLogger.Println("exchange rate sync failed:", err)
+		} else {
+			util.InfoLogger.Printf("exchange rate sync done: %d rates in %d pages, last record date %s", result.Rates, result.Pages, result.LastRecordDate)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
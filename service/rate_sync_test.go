@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/repository"
+)
+
+// newRateRepository returns a rate repository backed by an empty in-memory database.
+func newRateRepository(t *testing.T) *repository.SQLiteRateRepository {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	repository.ApplyMigrations(db)
+	return repository.NewSQLiteRateRepository(db)
+}
+
+func TestRateSyncerSync(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	pages := []string{
+		`{"data": [
+			{"record_date": "2024-09-30", "country": "Canada", "currency": "Dollar", "country_currency_desc": "Canada-Dollar", "exchange_rate": "1.30", "effective_date": "2024-09-30"},
+			{"record_date": "2024-09-30", "country": "Mexico", "currency": "Peso", "country_currency_desc": "Mexico-Peso", "exchange_rate": "19.6", "effective_date": "2024-09-30"}
+		], "meta": {"total-pages": 2}}`,
+		`{"data": [
+			{"record_date": "2024-12-31", "country": "Canada", "currency": "Dollar", "country_currency_desc": "Canada-Dollar", "exchange_rate": "1.35", "effective_date": "2024-12-31"}
+		], "meta": {"total-pages": 2}}`,
+	}
+
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := url.QueryUnescape(r.URL.RawQuery)
+		require.NoError(t, err)
+		queries = append(queries, query)
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page[number]"), &page)
+		if r.URL.Query().Get("filter") != "" {
+			// Incremental sync: only the last quarter is returned again.
+			fmt.Fprint(w, `{"data": [`+
+				`{"record_date": "2024-12-31", "country": "Canada", "currency": "Dollar", "country_currency_desc": "Canada-Dollar", "exchange_rate": "1.36", "effective_date": "2024-12-31"}`+
+				`], "meta": {"total-pages": 1}}`)
+			return
+		}
+		fmt.Fprint(w, pages[page-1])
+	}))
+	defer server.Close()
+
+	rates := newRateRepository(t)
+	syncer := NewRateSyncer(NewTreasuryProvider(server.Client(), server.URL), rates, 2)
+
+	result, err := syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, SyncResult{Pages: 2, Rates: 3, LastRecordDate: "2024-12-31"}, result)
+	assert.Equal(t, []string{
+		"fields=country,currency,country_currency_desc,exchange_rate,effective_date,record_date&sort=record_date,country_currency_desc&page[number]=1&page[size]=2",
+		"fields=country,currency,country_currency_desc,exchange_rate,effective_date,record_date&sort=record_date,country_currency_desc&page[number]=2&page[size]=2",
+	}, queries)
+
+	result, err = syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, SyncResult{Pages: 1, Rates: 1, LastRecordDate: "2024-12-31"}, result)
+	assert.Equal(t, "filter=record_date:gte:2024-12-31&fields=country,currency,country_currency_desc,exchange_rate,effective_date,record_date&sort=record_date,country_currency_desc&page[number]=1&page[size]=2", queries[2])
+
+	// Conversions are answered from the local copy.
+	provider := NewLocalProvider(rates)
+	rate, err := provider.RateOn(context.Background(), "Canada", date("2025-01-13"), DefaultLookback)
+	require.NoError(t, err)
+	assert.Equal(t, Rate{Country: "Canada", Currency: "Dollar", ExchangeRate: 1.36, EffectiveDate: "2024-12-31", RecordDate: "2024-12-31", Source: LocalSource}, rate)
+
+	_, err = provider.RateOn(context.Background(), "Mexico", date("2025-06-01"), DefaultLookback)
+	assert.ErrorIs(t, err, ErrNoRate)
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// MaxStatementTransactions is the largest number of transactions in a statement.
+const MaxStatementTransactions = 10000
+
+// statementPageSize is the number of transactions read at a time to build a statement.
+const statementPageSize = 500
+
+// ErrStatementTooLarge is returned when the period of a statement holds more than MaxStatementTransactions.
+var ErrStatementTooLarge = fmt.Errorf("statement exceeds %d transactions", MaxStatementTransactions)
+
+// StatementLine is a transaction of a statement and its converted amount, with
+// the ID of the conversion recorded in the audit trail. When the policy finds no
+// exchange rate for the transaction, the converted amount and the rate fields
+// are empty and Error tells why.
+type StatementLine struct {
+	TransactionID     int         `json:"transaction_id"`
+	ConversionID      int         `json:"conversion_id,omitempty"`
+	Description       string      `json:"description"`
+	TransactionDate   string      `json:"transaction_date"`
+	USDAmount         util.Money  `json:"usd_amount"`
+	ExchangeRate      float64     `json:"exchange_rate,omitempty"`
+	ConvertedAmount   *util.Money `json:"converted_amount,omitempty"`
+	RateEffectiveDate string      `json:"rate_effective_date,omitempty"`
+	RateRecordDate    string      `json:"rate_record_date,omitempty"`
+	RateSource        string      `json:"rate_source,omitempty"`
+	RateMatch         RateMatch   `json:"rate_match,omitempty"`
+	Error             string      `json:"error,omitempty"`
+}
+
+// Statement lists the transactions of a period converted to one currency, with
+// the totals in US dollars and in the currency.
+type Statement struct {
+	Currency string            `json:"currency"`
+	Country  string            `json:"country"`
+	FromDate string            `json:"from_date"`
+	ToDate   string            `json:"to_date"`
+	Policy   ConversionPolicy  `json:"policy"`
+	Rounding util.RoundingMode `json:"rounding"`
+	Lines    []StatementLine   `json:"lines"`
+	// Converted and Failed count the lines with and without a converted amount.
+	Converted int `json:"converted"`
+	Failed    int `json:"failed"`
+	// TotalUSD is the sum of every line; ConvertedUSD and TotalConverted are the
+	// sums of the converted lines, in US dollars and in the currency.
+	TotalUSD       util.Money `json:"total_usd"`
+	ConvertedUSD   util.Money `json:"converted_usd"`
+	TotalConverted util.Money `json:"total_converted"`
+}
+
+// ValidatePeriod checks that from and to are dates in YYYY-MM-DD format and that
+// from is not after to.
+func ValidatePeriod(from string, to string) error {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	fromDate, err := time.Parse(dateFormat, from)
+	if err != nil {
+		return errors.New("from_date must be in YYYY-MM-DD format")
+	}
+	toDate, err := time.Parse(dateFormat, to)
+	if err != nil {
+		return errors.New("to_date must be in YYYY-MM-DD format")
+	}
+	if fromDate.After(toDate) {
+		return errors.New("from_date must not be after to_date")
+	}
+	return nil
+}
+
+// StatementBuilder converts every transaction of a period to one currency.
+type StatementBuilder struct {
+	transactions repository.TransactionRepository
+	provider     ExchangeRateProvider
+	conversions  repository.ConversionRepository
+	rounding     util.RoundingMode
+}
+
+// NewStatementBuilder creates a builder reading the transactions of the
+// repository and the rates of the provider, rounding the converted amounts with
+// the mode and recording every conversion in the audit trail of conversions.
+func NewStatementBuilder(transactions repository.TransactionRepository, provider ExchangeRateProvider, conversions repository.ConversionRepository, rounding util.RoundingMode) *StatementBuilder {
+	return &StatementBuilder{transactions: transactions, provider: provider, conversions: conversions, rounding: rounding}
+}
+
+// Build returns the statement of the transactions dated between from and to
+// (inclusive, YYYY-MM-DD), ordered by date, converted to the currency with the
+// rate selected by the policy and rounded with the mode of the builder.
+//
+// When the provider is a RangeRateProvider, the rates of the currency covering
+// the whole period, with its lookback window (and the window following it when
+// the policy allows later rates), are listed with a single lookup and every
+// date is resolved from them. Otherwise the rate of each transaction date is
+// looked up once, however many transactions share it. Transactions without a
+// rate are reported on their line; any other lookup error, such as the Treasury
+// API being unavailable, fails the statement. Every converted line is recorded
+// in the audit trail, like a conversion of a single transaction, and a failure
+// to record it fails the statement.
+func (b *StatementBuilder) Build(ctx context.Context, from string, to string, currency util.Currency, policy ConversionPolicy) (*Statement, error) {
+	transactions, err := b.listTransactions(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{
+		Currency:       currency.Code,
+		Country:        currency.Country,
+		FromDate:       from,
+		ToDate:         to,
+		Policy:         policy,
+		Rounding:       b.rounding,
+		Lines:          make([]StatementLine, 0, len(transactions)),
+		TotalUSD:       util.USD(0),
+		ConvertedUSD:   util.USD(0),
+		TotalConverted: util.Money{Currency: currency.Code},
+	}
+
+	if len(transactions) == 0 {
+		return statement, nil
+	}
+	resolve, err := b.rateResolver(ctx, from, to, currency, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	type resolved struct {
+		rate  Rate
+		match RateMatch
+		err   error
+	}
+	rates := map[string]resolved{}
+	convertedAt := time.Now().UTC().Format(time.RFC3339)
+
+	for _, transaction := range transactions {
+		line := StatementLine{
+			TransactionID:   transaction.ID,
+			Description:     transaction.Description,
+			TransactionDate: transaction.TransactionDate,
+			USDAmount:       transaction.Amount,
+		}
+		statement.TotalUSD.Amount += transaction.Amount.Amount
+
+		result, ok := rates[transaction.TransactionDate]
+		if !ok {
+			date, err := time.Parse(config.AppConfig.ExpectedDateFormat, transaction.TransactionDate)
+			if err != nil {
+				return nil, fmt.Errorf("transaction %d has an invalid date: %w", transaction.ID, err)
+			}
+			result.rate, result.match, result.err = resolve(date)
+			if result.err != nil && !errors.Is(result.err, ErrNoRate) {
+				return nil, result.err
+			}
+			rates[transaction.TransactionDate] = result
+		}
+
+		if result.err != nil {
+			line.Error = "the purchase cannot be converted to the target currency"
+			statement.Failed++
+			statement.Lines = append(statement.Lines, line)
+			continue
+		}
+
+		converted, err := transaction.Amount.Mul(result.rate.ExchangeRate, currency.Code, b.rounding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert transaction %d: %w", transaction.ID, err)
+		}
+		conversion := &model.Conversion{
+			TransactionID:      transaction.ID,
+			TransactionVersion: transaction.Version,
+			TransactionDate:    transaction.TransactionDate,
+			Country:            currency.Country,
+			USDAmount:          transaction.Amount,
+			ExchangeRate:       result.rate.ExchangeRate,
+			ConvertedAmount:    converted,
+			Currency:           currency.Code,
+			Rounding:           string(b.rounding),
+			RateSource:         result.rate.Source,
+			RateCurrency:       result.rate.Currency,
+			RateEffectiveDate:  result.rate.EffectiveDate,
+			RateRecordDate:     result.rate.RecordDate,
+			Policy:             policy.Model(),
+			RateMatch:          string(result.match),
+			ConvertedAt:        convertedAt,
+		}
+		if err := b.conversions.Record(ctx, conversion); err != nil {
+			return nil, fmt.Errorf("failed to record conversion of transaction %d: %w", transaction.ID, err)
+		}
+		line.ConversionID = conversion.ID
+		line.ExchangeRate = result.rate.ExchangeRate
+		line.ConvertedAmount = &converted
+		line.RateEffectiveDate = result.rate.EffectiveDate
+		line.RateRecordDate = result.rate.RecordDate
+		line.RateSource = result.rate.Source
+		line.RateMatch = result.match
+
+		statement.Converted++
+		statement.ConvertedUSD.Amount += transaction.Amount.Amount
+		statement.TotalConverted.Amount += converted.Amount
+		statement.Lines = append(statement.Lines, line)
+	}
+
+	return statement, nil
+}
+
+// rateResolver returns the function resolving the rate of the currency on a
+// date of the period under the policy. With a RangeRateProvider it lists the
+// rates covering the period once; dates left without a rate only fall back to
+// a lookup of their own under FallbackToLatest.
+func (b *StatementBuilder) rateResolver(ctx context.Context, from string, to string, currency util.Currency, policy ConversionPolicy) (func(date time.Time) (Rate, RateMatch, error), error) {
+	lookup := func(date time.Time) (Rate, RateMatch, error) {
+		return ResolveRate(ctx, b.provider, currency.Country, date, policy)
+	}
+
+	provider, ok := b.provider.(RangeRateProvider)
+	if !ok || currency.Code == util.USDCurrency {
+		return lookup, nil
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	fromDate, err := time.Parse(dateFormat, from)
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := time.Parse(dateFormat, to)
+	if err != nil {
+		return nil, err
+	}
+	lower, upper := policy.Lookback.From(fromDate), toDate
+	if policy.ExactDate {
+		lower = fromDate
+	} else if policy.AllowLaterRates {
+		upper = policy.Lookback.Until(toDate)
+	}
+
+	rates, err := provider.RatesBetween(ctx, currency.Country, lower, upper)
+	if errors.Is(err, ErrRangeUnsupported) {
+		return lookup, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func(date time.Time) (Rate, RateMatch, error) {
+		rate, match, err := resolveRateIn(rates, date, policy)
+		if errors.Is(err, ErrNoRate) && policy.FallbackToLatest {
+			return lookup(date)
+		}
+		if errors.Is(err, ErrNoRate) {
+			err = fmt.Errorf("%w under the conversion policy", ErrNoRate)
+		}
+		return rate, match, err
+	}, nil
+}
+
+// listTransactions returns every transaction dated between from and to, ordered by date.
+func (b *StatementBuilder) listTransactions(ctx context.Context, from string, to string) ([]model.Transaction, error) {
+	options := repository.ListOptions{FromDate: from, ToDate: to, SortBy: repository.SortByDate, Limit: statementPageSize}
+
+	var transactions []model.Transaction
+	for {
+		page, err := b.transactions.List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page.Transactions...)
+		if len(transactions) > MaxStatementTransactions {
+			return nil, ErrStatementTooLarge
+		}
+		if page.Next == nil {
+			return transactions, nil
+		}
+		options.After = page.Next
+	}
+}
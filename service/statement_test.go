@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/model"
+	"github.com/mvfavila/transactions/repository"
+	"github.com/mvfavila/transactions/util"
+)
+
+// countedProvider counts the lookups made to a FileProvider, of single rates
+// and of the rates of a period.
+type countedProvider struct {
+	*FileProvider
+	calls  atomic.Int32
+	ranges atomic.Int32
+}
+
+func (p *countedProvider) RateOn(ctx context.Context, currency string, date time.Time, lookback Lookback) (Rate, error) {
+	p.calls.Add(1)
+	return p.FileProvider.RateOn(ctx, currency, date, lookback)
+}
+
+func (p *countedProvider) RatesBetween(ctx context.Context, currency string, from time.Time, to time.Time) ([]Rate, error) {
+	p.ranges.Add(1)
+	return p.FileProvider.RatesBetween(ctx, currency, from, to)
+}
+
+// singleRateProvider hides every method of a provider but RateOn.
+type singleRateProvider struct {
+	ExchangeRateProvider
+}
+
+func TestStatementBuilder(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+	ctx := context.Background()
+
+	rates, err := LoadFileProvider(writeFile(t, "rates.csv", `country,currency,exchange_rate,effective_date
+Canada,Dollar,1.30,2023-12-31
+Canada,Dollar,1.32,2024-01-25
+Canada,Dollar,1.35,2024-03-31
+`))
+	require.NoError(t, err)
+	provider := &countedProvider{FileProvider: rates}
+
+	transactions := repository.NewInMemoryTransactionRepository()
+	for _, transaction := range []model.Transaction{
+		{Description: "late", Amount: util.USD(1000), TransactionDate: "2024-01-20"},
+		{Description: "first", Amount: util.USD(1234), TransactionDate: "2024-01-05"},
+		{Description: "same day", Amount: util.USD(100), TransactionDate: "2024-01-05"},
+		{Description: "outside", Amount: util.USD(999), TransactionDate: "2024-02-01"},
+	} {
+		require.NoError(t, transactions.Create(ctx, &transaction))
+	}
+
+	canadianDollar, ok := util.LookupCurrency("CAD")
+	require.True(t, ok)
+	conversions := repository.NewInMemoryConversionRepository()
+	builder := NewStatementBuilder(transactions, provider, conversions, util.RoundHalfUp)
+
+	statement, err := builder.Build(ctx, "2024-01-01", "2024-01-31", canadianDollar, ConversionPolicy{Lookback: Lookback{Days: 10}})
+	require.NoError(t, err)
+
+	// A single lookup of the rates of the period
+	assert.Equal(t, int32(1), provider.ranges.Load())
+	assert.Equal(t, int32(0), provider.calls.Load())
+
+	require.Len(t, statement.Lines, 3)
+	assert.Equal(t, []string{"first", "same day", "late"}, []string{statement.Lines[0].Description, statement.Lines[1].Description, statement.Lines[2].Description})
+	assert.Equal(t, util.Money{Amount: 1604, Currency: "CAD"}, *statement.Lines[0].ConvertedAmount) // 12.34 * 1.30 = 16.042
+	assert.Equal(t, "2023-12-31", statement.Lines[1].RateEffectiveDate)
+	assert.Equal(t, MatchLookback, statement.Lines[1].RateMatch)
+	assert.Nil(t, statement.Lines[2].ConvertedAmount)
+	assert.Equal(t, "the purchase cannot be converted to the target currency", statement.Lines[2].Error)
+
+	assert.Equal(t, 2, statement.Converted)
+	assert.Equal(t, 1, statement.Failed)
+	assert.Equal(t, util.USD(2334), statement.TotalUSD)
+	assert.Equal(t, util.USD(1334), statement.ConvertedUSD)
+	assert.Equal(t, util.Money{Amount: 1734, Currency: "CAD"}, statement.TotalConverted)
+
+	// Every converted line is recorded in the audit trail
+	recorded, err := conversions.ListByTransaction(ctx, statement.Lines[0].TransactionID)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, statement.Lines[0].ConversionID, recorded[0].ID)
+	assert.Equal(t, util.Money{Amount: 1604, Currency: "CAD"}, recorded[0].ConvertedAmount)
+	assert.Equal(t, 1.30, recorded[0].ExchangeRate)
+	assert.Equal(t, "2023-12-31", recorded[0].RateEffectiveDate)
+	assert.Equal(t, "half_up", recorded[0].Rounding)
+	assert.Equal(t, model.ConversionPolicy{Lookback: "10d"}, recorded[0].Policy)
+	assert.Equal(t, string(MatchLookback), recorded[0].RateMatch)
+	recorded, err = conversions.ListByTransaction(ctx, statement.Lines[2].TransactionID)
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+	assert.Zero(t, statement.Lines[2].ConversionID)
+
+	// Rates after the purchase are listed too when the policy allows them
+	statement, err = builder.Build(ctx, "2024-01-01", "2024-01-31", canadianDollar, ConversionPolicy{Lookback: Lookback{Days: 10}, AllowLaterRates: true})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), provider.ranges.Load())
+	assert.Equal(t, 3, statement.Converted)
+	assert.Equal(t, "2024-01-25", statement.Lines[2].RateEffectiveDate)
+	assert.Equal(t, MatchLater, statement.Lines[2].RateMatch)
+
+	// Providers that cannot list rates are asked once per transaction date
+	counted := &countedProvider{FileProvider: rates}
+	statement, err = NewStatementBuilder(transactions, singleRateProvider{counted}, conversions, util.RoundHalfUp).Build(ctx, "2024-01-01", "2024-01-31", canadianDollar, ConversionPolicy{Lookback: Lookback{Days: 10}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), counted.calls.Load())
+	assert.Equal(t, 2, statement.Converted)
+
+	statement, err = builder.Build(ctx, "2023-01-01", "2023-01-31", canadianDollar, DefaultConversionPolicy)
+	require.NoError(t, err)
+	assert.Empty(t, statement.Lines)
+	assert.Equal(t, util.Money{Currency: "CAD"}, statement.TotalConverted)
+}
+
+func TestValidatePeriod(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	assert.NoError(t, ValidatePeriod("2024-01-01", "2024-01-31"))
+	assert.NoError(t, ValidatePeriod("2024-01-01", "2024-01-01"))
+	assert.EqualError(t, ValidatePeriod("", "2024-01-31"), "from_date must be in YYYY-MM-DD format")
+	assert.EqualError(t, ValidatePeriod("2024-01-01", "31/01/2024"), "to_date must be in YYYY-MM-DD format")
+	assert.EqualError(t, ValidatePeriod("2024-02-01", "2024-01-31"), "from_date must not be after to_date")
+}
@@ -1,89 +1,252 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
 	"github.com/mvfavila/transactions/config"
-	"github.com/mvfavila/transactions/model"
 )
 
+// TreasurySource identifies rates obtained from the Treasury Reporting Rates of Exchange API.
+const TreasurySource = "treasury"
+
+// fetchPageSize is the number of records requested per page when fetching rates.
+const fetchPageSize = 1000
+
+// maxFetchRows is the maximum number of records a rate lookup may download.
+const maxFetchRows = 10000
+
+// ErrTooManyRows is returned when a query matches more records than allowed.
+var ErrTooManyRows = errors.New("Treasury API query matches too many records")
+
+// treasuryRateFields are the columns of the Treasury dataset read into a TreasuryRate.
+var treasuryRateFields = []string{"country", "currency", "country_currency_desc", "exchange_rate", "effective_date", "record_date"}
+
 // TreasuryRate represents a single exchange rate entry
 type TreasuryRate struct {
-	Currency      string  `json:"currency"`
-	Country       string  `json:"country"`
-	ExchangeRate  float64 `json:"exchange_rate,string"`
-	EffectiveDate string  `json:"effective_date"`
+	Currency            string  `json:"currency"`
+	Country             string  `json:"country"`
+	CountryCurrencyDesc string  `json:"country_currency_desc"`
+	ExchangeRate        float64 `json:"exchange_rate,string"`
+	EffectiveDate       string  `json:"effective_date"`
+	RecordDate          string  `json:"record_date"`
 }
 
 // TreasuryResponse represents the API response structure
 type TreasuryResponse struct {
-	Data []TreasuryRate `json:"data"`
+	Data  []TreasuryRate `json:"data"`
+	Meta  TreasuryMeta   `json:"meta"`
+	Links TreasuryLinks  `json:"links"`
+}
+
+// TreasuryMeta is the pagination metadata of a Treasury API response.
+type TreasuryMeta struct {
+	Count      int `json:"count"`
+	TotalCount int `json:"total-count"`
+	TotalPages int `json:"total-pages"`
+}
+
+// TreasuryLinks are the pagination links of a Treasury API response, as query
+// string fragments. The links that do not apply (e.g. next on the last page) are empty.
+type TreasuryLinks struct {
+	Self  string `json:"self"`
+	First string `json:"first"`
+	Prev  string `json:"prev"`
+	Next  string `json:"next"`
+	Last  string `json:"last"`
+}
+
+// hasNext reports whether there is a page after the page with the given number.
+func (r TreasuryResponse) hasNext(number int) bool {
+	if r.Meta.TotalPages > 0 {
+		return number < r.Meta.TotalPages
+	}
+	return r.Links.Next != ""
+}
+
+// TreasuryProvider is an ExchangeRateProvider backed by the Treasury Reporting
+// Rates of Exchange API.
+//
+// Calls are bound to the context of the caller. Without options every call is
+// made once; WithRetryPolicy and WithCircuitBreaker add deadlines, retries and
+// fail fast behaviour.
+type TreasuryProvider struct {
+	client  *http.Client
+	baseURL string
+	retry   RetryPolicy
+	breaker *CircuitBreaker
+}
+
+// NewTreasuryProvider creates a provider calling the Treasury API at the given base URL.
+func NewTreasuryProvider(client *http.Client, baseURL string, options ...TreasuryOption) *TreasuryProvider {
+	provider := &TreasuryProvider{client: client, baseURL: baseURL}
+	for _, option := range options {
+		option(provider)
+	}
+	return provider
+}
+
+// RateOn returns the most recent Treasury rate for the country within the lookback window.
+func (p *TreasuryProvider) RateOn(ctx context.Context, country string, date time.Time, lookback Lookback) (Rate, error) {
+	rates, err := p.FetchExchangeRates(ctx, country, lookback.From(date), date)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	if len(rates) == 0 {
+		return Rate{}, ErrNoRate
+	}
+
+	// Rates are sorted by descending effective date
+	return treasuryRate(rates[0]), nil
+}
+
+// RateAfter returns the earliest Treasury rate for the country effective after the date, within the window.
+func (p *TreasuryProvider) RateAfter(ctx context.Context, country string, date time.Time, window Lookback) (Rate, error) {
+	rates, err := p.FetchExchangeRates(ctx, country, date.AddDate(0, 0, 1), window.Until(date))
+	if err != nil {
+		return Rate{}, err
+	}
+
+	if len(rates) == 0 {
+		return Rate{}, ErrNoRate
+	}
+
+	// Rates are sorted by descending effective date
+	return treasuryRate(rates[len(rates)-1]), nil
 }
 
-// FetchExchangeRates fetches exchange rates from the Treasury API
-func FetchExchangeRates(client *http.Client, country string, transaction *model.Transaction) ([]TreasuryRate, error) {
+// RatesBetween returns the Treasury rates for the country effective between the dates, most recent first.
+func (p *TreasuryProvider) RatesBetween(ctx context.Context, country string, from time.Time, to time.Time) ([]Rate, error) {
+	rates, err := p.FetchExchangeRates(ctx, country, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Rate, 0, len(rates))
+	for _, rate := range rates {
+		result = append(result, treasuryRate(rate))
+	}
+	return result, nil
+}
+
+// treasuryRate converts a rate of the Treasury dataset.
+func treasuryRate(rate TreasuryRate) Rate {
+	return Rate{
+		Country:       rate.Country,
+		Currency:      rate.Currency,
+		ExchangeRate:  rate.ExchangeRate,
+		EffectiveDate: rate.EffectiveDate,
+		RecordDate:    rate.RecordDate,
+		Source:        TreasurySource,
+	}
+}
+
+// FetchExchangeRates fetches the exchange rates of a country effective between
+// the given dates (inclusive) from the Treasury API, most recent first.
+func (p *TreasuryProvider) FetchExchangeRates(ctx context.Context, country string, from time.Time, to time.Time) ([]TreasuryRate, error) {
 	if country == "" {
 		return nil, fmt.Errorf("country is required")
 	}
 
-	var query, err = getRequestQuery(country, transaction)
+	var rates []TreasuryRate
+	err := p.forEachPage(ctx, exchangeRatesQuery(country, from, to), fetchPageSize, maxFetchRows, func(page TreasuryResponse) error {
+		rates = append(rates, page.Data...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	return rates, nil
+}
+
+// forEachPage calls the Treasury API for every page of the query, in order, and
+// hands each response to handle. It fails with ErrTooManyRows, before handling
+// any page, when the query matches more than maxRows records (zero means no limit).
+func (p *TreasuryProvider) forEachPage(ctx context.Context, query *Query, pageSize int, maxRows int, handle func(page TreasuryResponse) error) error {
+	rows := 0
+	for number := 1; ; number++ {
+		var page TreasuryResponse
+		if err := p.get(ctx, query.Page(number, pageSize), &page); err != nil {
+			return fmt.Errorf("failed to fetch page %d: %w", number, err)
+		}
+
+		// The total count may grow while paging, so the rows received are checked as well.
+		rows += len(page.Data)
+		if maxRows > 0 && (page.Meta.TotalCount > maxRows || rows > maxRows) {
+			return fmt.Errorf("%w: %d records, at most %d allowed", ErrTooManyRows, max(page.Meta.TotalCount, rows), maxRows)
+		}
+
+		if err := handle(page); err != nil {
+			return err
+		}
+
+		if len(page.Data) == 0 || !page.hasNext(number) {
+			return nil
+		}
+	}
+}
+
+// get calls the Treasury API with the given query and decodes the JSON response into out.
+// Failures of the API are retried according to the retry policy of the provider.
+func (p *TreasuryProvider) get(ctx context.Context, query *Query, out any) error {
+	rawQuery := query.Encode()
+	return p.callWithRetries(ctx, func(ctx context.Context) error {
+		return p.getOnce(ctx, rawQuery, out)
+	})
+}
+
+// getOnce makes a single call to the Treasury API.
+func (p *TreasuryProvider) getOnce(ctx context.Context, rawQuery string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", p.baseURL, rawQuery), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request to Treasury API: %w", err)
+	}
+
 	// Make an HTTP GET request
-	resp, err := client.Get(fmt.Sprintf("%s?filter=%s", config.AppConfig.TreasuryAPIBaseURL, query))
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request to Treasury API: %w", err)
+		return &upstreamError{err: fmt.Errorf("failed to make request to Treasury API: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	// Check if the response status code is successful
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		err := fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) {
+			return &upstreamError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+		}
+		return err
 	}
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return &upstreamError{err: fmt.Errorf("failed to read response body: %w", err)}
 	}
 
 	// Parse JSON response
-	var treasuryResponse TreasuryResponse
-	if err := json.Unmarshal(body, &treasuryResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON response: %w", err)
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON response: %w", err)
 	}
 
-	return treasuryResponse.Data, nil
+	return nil
 }
 
-// getDateMinusSixMonths calculates the date that is six months prior to the given currentDate.
-func getDateMinusSixMonths(currentDate string) (string, error) {
-	if d, err := time.Parse(config.AppConfig.ExpectedDateFormat, currentDate); err == nil {
-		return d.AddDate(0, -6, 0).Format(config.AppConfig.ExpectedDateFormat), nil
-	}
-
-	return "", fmt.Errorf("transaction date must be in YYYY-MM-DD format")
-}
-
-// getRequestQuery generates a filter and sort string for the Treasury API based on the given country and transaction.
-//
-// The filter string is based on the following criteria:
-// - country: the country of the transaction
-// - effective_date: the date of the transaction or the date 6 months prior to the transaction date, whichever is later.
-//
-// If the transaction date is invalid, an error is returned.
-func getRequestQuery(country string, transaction *model.Transaction) (string, error) {
-	var bottomDate string
-	var err error
-	if bottomDate, err = getDateMinusSixMonths(transaction.TransactionDate); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("country:eq:%s,effective_date:gte:%s,effective_date:lte:%s&sort=-effective_date", country, bottomDate, transaction.TransactionDate), nil
+// exchangeRatesQuery returns the query of the rates of a country that became
+// effective between the given dates (inclusive), most recent first.
+func exchangeRatesQuery(country string, from time.Time, to time.Time) *Query {
+	dateFormat := config.AppConfig.ExpectedDateFormat
+	return NewQuery().
+		Eq("country", country).
+		Gte("effective_date", from.Format(dateFormat)).
+		Lte("effective_date", to.Format(dateFormat)).
+		Fields(treasuryRateFields...).
+		Sort("-effective_date")
 }
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mvfavila/transactions/util"
+)
+
+// ErrCircuitOpen is returned without calling the Treasury API while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("Treasury API is unavailable, circuit breaker is open")
+
+// RetryPolicy controls the deadlines and retries of the calls to the Treasury API.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a call, including the first one.
+	MaxAttempts int
+	// Timeout is the deadline of a call, including all its attempts. Zero means none.
+	Timeout time.Duration
+	// AttemptTimeout is the deadline of a single attempt. Zero means none.
+	AttemptTimeout time.Duration
+	// BaseBackoff is the wait before the first retry; it doubles at every retry.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the wait between attempts, unless the API asks for more with Retry-After.
+	MaxBackoff time.Duration
+}
+
+// backoff returns the wait before the given retry (1 for the first one), with
+// jitter so that concurrent callers do not retry in lockstep.
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	wait := r.BaseBackoff
+	for i := 1; i < retry && (r.MaxBackoff <= 0 || wait < r.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if r.MaxBackoff > 0 && wait > r.MaxBackoff {
+		wait = r.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// TreasuryOption configures a TreasuryProvider.
+type TreasuryOption func(*TreasuryProvider)
+
+// WithRetryPolicy makes the provider apply the deadlines and retries of the policy.
+func WithRetryPolicy(policy RetryPolicy) TreasuryOption {
+	return func(p *TreasuryProvider) {
+		p.retry = policy
+	}
+}
+
+// WithCircuitBreaker makes the provider fail fast with ErrCircuitOpen while the breaker is open.
+// The breaker may be shared by several providers calling the same API.
+func WithCircuitBreaker(breaker *CircuitBreaker) TreasuryOption {
+	return func(p *TreasuryProvider) {
+		p.breaker = breaker
+	}
+}
+
+// upstreamError is a failure of the Treasury API itself (network error, timeout,
+// 5xx or 429 response), as opposed to a bad request or an abandoned call. Only
+// upstream errors are retried and counted by the circuit breaker.
+type upstreamError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *upstreamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.err
+}
+
+// isRetryableStatus reports whether a response status is worth retrying.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// parseRetryAfter returns the wait requested by a Retry-After header, given in
+// seconds or as an HTTP date, or zero when there is none.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// CircuitBreaker stops calls to the Treasury API after too many consecutive
+// failures. Once open, calls fail with ErrCircuitOpen until the cooldown has
+// elapsed; then a single trial call is let through, which closes the breaker
+// when it succeeds and opens it again when it fails.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a breaker opening after threshold consecutive failures for the cooldown duration.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow returns ErrCircuitOpen when a call must not be made.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.probing || b.now().Before(b.openedAt.Add(b.cooldown)) {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// onSuccess records a call answered by the API.
+func (b *CircuitBreaker) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// onFailure records a failure of the API.
+func (b *CircuitBreaker) onFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// onAbandon records a call given up by the caller, which says nothing about the API.
+func (b *CircuitBreaker) onAbandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// callWithRetries runs attempt until it succeeds, fails with an error that is
+// not an upstream error, or the attempts or the deadline of the policy run out.
+func (p *TreasuryProvider) callWithRetries(ctx context.Context, attempt func(ctx context.Context) error) error {
+	if p.breaker != nil {
+		if err := p.breaker.allow(); err != nil {
+			return err
+		}
+	}
+
+	err := p.retry.run(ctx, attempt)
+
+	if p.breaker != nil {
+		var upstream *upstreamError
+		switch {
+		case err == nil:
+			p.breaker.onSuccess()
+		case ctx.Err() != nil:
+			p.breaker.onAbandon()
+		case errors.As(err, &upstream):
+			p.breaker.onFailure()
+		default:
+			p.breaker.onSuccess()
+		}
+	}
+
+	return err
+}
+
+// run calls attempt according to the policy.
+func (r RetryPolicy) run(ctx context.Context, attempt func(ctx context.Context) error) error {
+	callCtx := ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	for try := 1; ; try++ {
+		err := r.runAttempt(callCtx, attempt)
+
+		var upstream *upstreamError
+		if err == nil || !errors.As(err, &upstream) || try >= r.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		wait := max(r.backoff(try), upstream.retryAfter)
+		if deadline, ok := callCtx.Deadline(); ok && time.Until(deadline) < wait {
+			// The call would time out before the next attempt could even start.
+			return err
+		}
+
+		util.WarningLogger.Printf("Treasury API call failed (attempt %d of %d), retrying in %s: %v", try, r.MaxAttempts, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-callCtx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// runAttempt calls attempt with the per-attempt deadline of the policy.
+func (r RetryPolicy) runAttempt(ctx context.Context, attempt func(ctx context.Context) error) error {
+	if r.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.AttemptTimeout)
+		defer cancel()
+	}
+	return attempt(ctx)
+}
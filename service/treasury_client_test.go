@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvfavila/transactions/config"
+	"github.com/mvfavila/transactions/util"
+)
+
+// newMisbehavingServer returns a Treasury API stub answering each call with the
+// next handler; the last handler answers every remaining call.
+func newMisbehavingServer(t *testing.T, handlers ...http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		handlers[min(call, len(handlers))-1](w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func respondStatus(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+func respondRates(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte(`{"data": [{"country": "Canada", "currency": "Dollar", "exchange_rate": "1.35", "effective_date": "2024-12-31"}]}`))
+}
+
+// hang blocks until the client gives up on the request.
+func hang(_ http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	Timeout:        5 * time.Second,
+	AttemptTimeout: time.Second,
+	BaseBackoff:    time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+}
+
+func TestTreasuryProviderRetries(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	// Initialize logger with in-memory buffer
+	var buf bytes.Buffer
+	util.InitLogger(&buf)
+
+	ctx := context.Background()
+
+	t.Run("retries server errors and rate limiting", func(t *testing.T) {
+		server, calls := newMisbehavingServer(t, respondStatus(http.StatusBadGateway), respondStatus(http.StatusTooManyRequests), respondRates)
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(testRetryPolicy))
+
+		rate, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		require.NoError(t, err)
+		assert.Equal(t, 1.35, rate.ExchangeRate)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		server, calls := newMisbehavingServer(t, respondStatus(http.StatusServiceUnavailable))
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(testRetryPolicy))
+
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		assert.ErrorContains(t, err, "received non-200 status code: 503")
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		server, calls := newMisbehavingServer(t, respondStatus(http.StatusBadRequest))
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(testRetryPolicy))
+
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		assert.ErrorContains(t, err, "received non-200 status code: 400")
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("honors Retry-After", func(t *testing.T) {
+		server, calls := newMisbehavingServer(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, respondRates)
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(testRetryPolicy))
+
+		start := time.Now()
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("does not wait for a Retry-After beyond the deadline", func(t *testing.T) {
+		server, calls := newMisbehavingServer(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(testRetryPolicy))
+
+		start := time.Now()
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		assert.ErrorContains(t, err, "received non-200 status code: 503")
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("retries a hung attempt", func(t *testing.T) {
+		server, calls := newMisbehavingServer(t, hang, respondRates)
+		policy := testRetryPolicy
+		policy.AttemptTimeout = 50 * time.Millisecond
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(policy))
+
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("applies the overall deadline", func(t *testing.T) {
+		server, _ := newMisbehavingServer(t, hang)
+		policy := testRetryPolicy
+		policy.MaxAttempts = 100
+		policy.Timeout = 200 * time.Millisecond
+		policy.AttemptTimeout = 0
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(policy))
+
+		start := time.Now()
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("stops when the caller goes away", func(t *testing.T) {
+		server, _ := newMisbehavingServer(t, hang)
+		provider := NewTreasuryProvider(server.Client(), server.URL, WithRetryPolicy(testRetryPolicy))
+
+		callerCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := provider.RateOn(callerCtx, "Canada", date("2025-01-13"), DefaultLookback)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestTreasuryProviderCircuitBreaker(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	// Initialize logger with in-memory buffer
+	var buf bytes.Buffer
+	util.InitLogger(&buf)
+
+	ctx := context.Background()
+	server, calls := newMisbehavingServer(t,
+		respondStatus(http.StatusInternalServerError),
+		respondStatus(http.StatusInternalServerError),
+		respondStatus(http.StatusInternalServerError),
+		respondRates,
+	)
+
+	breaker := NewCircuitBreaker(2, time.Minute)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker.now = func() time.Time { return now }
+	provider := NewTreasuryProvider(server.Client(), server.URL, WithCircuitBreaker(breaker))
+
+	rateOn := func() error {
+		_, err := provider.RateOn(ctx, "Canada", date("2025-01-13"), DefaultLookback)
+		return err
+	}
+
+	// Two consecutive failures open the breaker.
+	assert.ErrorContains(t, rateOn(), "status code: 500")
+	assert.ErrorContains(t, rateOn(), "status code: 500")
+	assert.ErrorIs(t, rateOn(), ErrCircuitOpen)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// A failed trial after the cooldown opens it again.
+	now = now.Add(time.Minute)
+	assert.ErrorContains(t, rateOn(), "status code: 500")
+	assert.ErrorIs(t, rateOn(), ErrCircuitOpen)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// A successful trial closes it.
+	now = now.Add(time.Minute)
+	assert.NoError(t, rateOn())
+	assert.NoError(t, rateOn())
+	assert.Equal(t, int32(5), calls.Load())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Wed, 01 Jan 2025 00:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Tue, 31 Dec 2024 23:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for retry, expected := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+		wait := policy.backoff(retry)
+		assert.GreaterOrEqual(t, wait, expected/2)
+		assert.LessOrEqual(t, wait, expected)
+	}
+}
@@ -2,15 +2,18 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/mvfavila/transactions/config"
-	"github.com/mvfavila/transactions/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockRoundTripper struct {
@@ -25,6 +28,15 @@ func (m *mockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 	return m.mockResponse, nil
 }
 
+// date parses a YYYY-MM-DD date for tests.
+func date(value string) time.Time {
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
 func TestFetchExchangeRates(t *testing.T) {
 	// Load default config for testing
 	config.LoadDefaultConfig()
@@ -63,7 +75,8 @@ func TestFetchExchangeRates(t *testing.T) {
 	}
 
 	// Call the function
-	rates, err := FetchExchangeRates(mockClient, "Brazil", &model.Transaction{TransactionDate: "2025-01-13"})
+	provider := NewTreasuryProvider(mockClient, config.AppConfig.TreasuryAPIBaseURL)
+	rates, err := provider.FetchExchangeRates(context.Background(), "Brazil", date("2024-07-13"), date("2025-01-13"))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -88,74 +101,148 @@ func TestFetchExchangeRates_Error(t *testing.T) {
 	}
 
 	// Call the function
-	_, err := FetchExchangeRates(mockClient, "Brazil", &model.Transaction{TransactionDate: "2025-01-13"})
+	provider := NewTreasuryProvider(mockClient, config.AppConfig.TreasuryAPIBaseURL)
+	_, err := provider.FetchExchangeRates(context.Background(), "Brazil", date("2024-07-13"), date("2025-01-13"))
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to make request to Treasury API")
 	assert.Contains(t, err.Error(), "mock error")
 }
 
-func TestGetDateMinusSixMonths(t *testing.T) {
-	tests := []struct {
-		inputDate       string
-		expectedOutput  string
-		expectedSuccess bool
+func TestTreasuryProviderRateOn(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	newProvider := func(body string) *TreasuryProvider {
+		return NewTreasuryProvider(&http.Client{
+			Transport: &mockRoundTripper{
+				mockResponse: &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(body)),
+				},
+			},
+		}, config.AppConfig.TreasuryAPIBaseURL)
+	}
+
+	rate, err := newProvider(`{"data": [
+		{"country": "Canada", "currency": "Dollar", "exchange_rate": "1.35", "effective_date": "2024-12-31", "record_date": "2024-12-31"},
+		{"country": "Canada", "currency": "Dollar", "exchange_rate": "1.30", "effective_date": "2024-09-30", "record_date": "2024-09-30"}
+	]}`).RateOn(context.Background(), "Canada", date("2025-01-13"), DefaultLookback)
+	assert.NoError(t, err)
+	assert.Equal(t, Rate{Country: "Canada", Currency: "Dollar", ExchangeRate: 1.35, EffectiveDate: "2024-12-31", RecordDate: "2024-12-31", Source: TreasurySource}, rate)
+
+	_, err = newProvider(`{"data": []}`).RateOn(context.Background(), "Canada", date("2025-01-13"), DefaultLookback)
+	assert.ErrorIs(t, err, ErrNoRate)
+}
+
+func TestExchangeRatesQuery(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	const fields = "&fields=country%2Ccurrency%2Ccountry_currency_desc%2Cexchange_rate%2Ceffective_date%2Crecord_date&sort=-effective_date"
+
+	var exchangeRatesQueryTests = []struct {
+		country       string
+		from          string
+		to            string
+		expectedQuery string
+	}{
+		{"Autralia", "2019-07-01", "2020-01-01", "filter=country%3Aeq%3AAutralia%2Ceffective_date%3Agte%3A2019-07-01%2Ceffective_date%3Alte%3A2020-01-01" + fields},
+		{"Euro Zone", "2019-08-29", "2020-02-29", "filter=country%3Aeq%3AEuro%20Zone%2Ceffective_date%3Agte%3A2019-08-29%2Ceffective_date%3Alte%3A2020-02-29" + fields},
+		{"Bosnia-Herzegovina", "2019-08-29", "2020-02-29", "filter=country%3Aeq%3ABosnia-Herzegovina%2Ceffective_date%3Agte%3A2019-08-29%2Ceffective_date%3Alte%3A2020-02-29" + fields},
+	}
+
+	for _, tt := range exchangeRatesQueryTests {
+		assert.Equal(t, tt.expectedQuery, exchangeRatesQuery(tt.country, date(tt.from), date(tt.to)).Encode())
+	}
+}
+
+func TestFetchExchangeRatesEncodesQuery(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	var filter string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("filter")
+		_, _ = w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	provider := NewTreasuryProvider(server.Client(), server.URL)
+	_, err := provider.FetchExchangeRates(context.Background(), "Euro Zone", date("2024-07-13"), date("2025-01-13"))
+	assert.NoError(t, err)
+	assert.Equal(t, "country:eq:Euro Zone,effective_date:gte:2024-07-13,effective_date:lte:2025-01-13", filter)
+}
+
+func TestFetchExchangeRatesPagination(t *testing.T) {
+	// Load default config for testing
+	config.LoadDefaultConfig()
+
+	rate := func(effectiveDate string) string {
+		return `{"country": "Canada", "currency": "Dollar", "exchange_rate": "1.35", "effective_date": "` + effectiveDate + `"}`
+	}
+
+	var paginationTests = []struct {
+		name          string
+		pages         []string
+		expectedDates []string
+		expectedErr   error
 	}{
 		{
-			inputDate:       "2020-01-01",
-			expectedOutput:  "2019-07-01",
-			expectedSuccess: true,
-		},
-		{
-			inputDate:       "2020-02-29",
-			expectedOutput:  "2019-08-29",
-			expectedSuccess: true,
+			"follows total-pages",
+			[]string{
+				`{"data": [` + rate("2024-12-31") + `], "meta": {"count": 1, "total-count": 2, "total-pages": 2}}`,
+				`{"data": [` + rate("2024-09-30") + `], "meta": {"count": 1, "total-count": 2, "total-pages": 2}}`,
+			},
+			[]string{"2024-12-31", "2024-09-30"},
+			nil,
 		},
 		{
-			inputDate:       "abc",
-			expectedOutput:  "",
-			expectedSuccess: false,
+			"follows links.next without meta",
+			[]string{
+				`{"data": [` + rate("2024-12-31") + `], "links": {"next": "&page%5Bnumber%5D=2&page%5Bsize%5D=1"}}`,
+				`{"data": [` + rate("2024-09-30") + `], "links": {"next": "&page%5Bnumber%5D=3&page%5Bsize%5D=1"}}`,
+				`{"data": [` + rate("2024-06-30") + `], "links": {"next": null}}`,
+			},
+			[]string{"2024-12-31", "2024-09-30", "2024-06-30"},
+			nil,
 		},
 		{
-			inputDate:       "",
-			expectedOutput:  "",
-			expectedSuccess: false,
+			"refuses too many rows",
+			[]string{
+				`{"data": [` + rate("2024-12-31") + `], "meta": {"count": 1, "total-count": 20000, "total-pages": 20000}}`,
+			},
+			nil,
+			ErrTooManyRows,
 		},
 	}
 
-	for _, test := range tests {
-		got, err := getDateMinusSixMonths(test.inputDate)
-		if test.expectedSuccess {
-			assert.NoError(t, err)
-			assert.Equal(t, test.expectedOutput, got)
-		} else {
-			assert.Error(t, err)
-			assert.Equal(t, "", got)
-		}
-	}
-}
-
-func TestGetRequestQuery(t *testing.T) {
-	var getRequestFilterTests = []struct {
-		country         string
-		transactionDate string
-		expectedFilter  string
-		expectedError   error
-	}{
-		{"Autralia", "2020-01-01", "country:eq:Autralia,effective_date:gte:2019-07-01,effective_date:lte:2020-01-01&sort=-effective_date", nil},
-		{"Austria", "2020-02-29", "country:eq:Austria,effective_date:gte:2019-08-29,effective_date:lte:2020-02-29&sort=-effective_date", nil},
-		{"Brazil", "abc", "", fmt.Errorf("transaction date must be in YYYY-MM-DD format")},
-		{"Czech. Republic", "", "", fmt.Errorf("transaction date must be in YYYY-MM-DD format")},
-	}
+	for _, tt := range paginationTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requestedPages []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				number := r.URL.Query().Get("page[number]")
+				requestedPages = append(requestedPages, number)
+				var page int
+				fmt.Sscan(number, &page)
+				_, _ = w.Write([]byte(tt.pages[page-1]))
+			}))
+			defer server.Close()
+
+			provider := NewTreasuryProvider(server.Client(), server.URL)
+			rates, err := provider.FetchExchangeRates(context.Background(), "Canada", date("2024-01-01"), date("2025-01-13"))
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
 
-	for _, tt := range getRequestFilterTests {
-		gotFilter, gotErr := getRequestQuery(tt.country, &model.Transaction{TransactionDate: tt.transactionDate})
-		if tt.expectedError == nil {
-			assert.NoError(t, gotErr)
-			assert.Equal(t, tt.expectedFilter, gotFilter)
-		} else {
-			assert.EqualError(t, gotErr, tt.expectedError.Error())
-			assert.Equal(t, "", gotFilter)
-		}
+			var dates []string
+			for _, rate := range rates {
+				dates = append(dates, rate.EffectiveDate)
+			}
+			assert.Equal(t, tt.expectedDates, dates)
+			assert.Len(t, requestedPages, len(tt.pages))
+		})
 	}
 }
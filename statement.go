@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mvfavila/transactions/service"
+	"github.com/mvfavila/transactions/util"
+)
+
+const statementUsage = "usage: transactions statement -from YYYY-MM-DD -to YYYY-MM-DD -currency CODE [-format json|csv]"
+
+// runStatement executes the `statement` command with the given arguments. It
+// writes the statement of the period in the currency, built with the conversion
+// policy, to out:
+//
+//   - json (default): the statement as returned by GET /statements
+//   - csv: one row per transaction followed by a row with the totals
+func runStatement(ctx context.Context, builder *service.StatementBuilder, policy service.ConversionPolicy, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("statement", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	from := flags.String("from", "", "first transaction date of the period")
+	to := flags.String("to", "", "last transaction date of the period")
+	currencyName := flags.String("currency", "", "ISO 4217 code or country name of the target currency")
+	format := flags.String("format", "json", "output format: json or csv")
+	if err := flags.Parse(args); err != nil {
+		return errors.New(statementUsage)
+	}
+
+	if err := service.ValidatePeriod(*from, *to); err != nil {
+		return fmt.Errorf("%w\n%s", err, statementUsage)
+	}
+	currency, ok := util.LookupCurrency(*currencyName)
+	if !ok {
+		return fmt.Errorf("unknown currency %q\n%s", *currencyName, statementUsage)
+	}
+	if *format != "json" && *format != "csv" {
+		return errors.New(statementUsage)
+	}
+
+	statement, err := builder.Build(ctx, *from, *to, currency, policy)
+	if err != nil {
+		return err
+	}
+
+	if *format == "csv" {
+		return writeStatementCSV(out, statement)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(statement)
+}
+
+// writeStatementCSV writes the lines of the statement and its totals as CSV.
+func writeStatementCSV(out io.Writer, statement *service.Statement) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"transaction_id", "transaction_date", "description", "usd_amount", "exchange_rate", "converted_amount", "rate_effective_date", "rate_match", "error"})
+	for _, line := range statement.Lines {
+		var exchangeRate, convertedAmount string
+		if line.ConvertedAmount != nil {
+			exchangeRate = strconv.FormatFloat(line.ExchangeRate, 'f', -1, 64)
+			convertedAmount = line.ConvertedAmount.String()
+		}
+		w.Write([]string{strconv.Itoa(line.TransactionID), line.TransactionDate, line.Description, line.USDAmount.String(),
+			exchangeRate, convertedAmount, line.RateEffectiveDate, string(line.RateMatch), line.Error})
+	}
+	w.Write([]string{"total", "", "", statement.TotalUSD.String(), "", statement.TotalConverted.String(), "", "", ""})
+	w.Flush()
+	return w.Error()
+}
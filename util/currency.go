@@ -0,0 +1,195 @@
+package util
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Currency describes a currency of the catalogue and how the Treasury Reporting
+// Rates of Exchange dataset names it.
+type Currency struct {
+	// Code is the ISO 4217 code, e.g. "CAD".
+	Code string `json:"code"`
+	// Name is the English name of the currency.
+	Name string `json:"name"`
+	// Country is the Treasury country name, e.g. "Canada".
+	Country string `json:"country"`
+	// CountryCurrencyDesc is the Treasury country_currency_desc, e.g. "Canada-Dollar".
+	CountryCurrencyDesc string `json:"country_currency_desc"`
+	// MinorUnits is the number of decimals of the currency.
+	MinorUnits int `json:"minor_units"`
+	// Aliases are other common names of the country or currency area.
+	Aliases []string `json:"-"`
+}
+
+// maxCurrencySuggestions is the number of close matches returned by SuggestCurrencies.
+const maxCurrencySuggestions = 5
+
+// currencies is the catalogue of the currencies that can be referred to by ISO
+// code. It covers the most used currencies of the Treasury dataset; other Treasury
+// countries cannot be converted to, as the minor unit of their currency is unknown.
+var currencies = []Currency{
+	{Code: "AED", Name: "UAE Dirham", Country: "United Arab Emirates", CountryCurrencyDesc: "United Arab Emirates-Dirham", MinorUnits: 2, Aliases: []string{"UAE", "Emirates"}},
+	{Code: "AFN", Name: "Afghani", Country: "Afghanistan", CountryCurrencyDesc: "Afghanistan-Afghani", MinorUnits: 2},
+	{Code: "ARS", Name: "Argentine Peso", Country: "Argentina", CountryCurrencyDesc: "Argentina-Peso", MinorUnits: 2},
+	{Code: "AUD", Name: "Australian Dollar", Country: "Australia", CountryCurrencyDesc: "Australia-Dollar", MinorUnits: 2},
+	{Code: "BAM", Name: "Convertible Mark", Country: "Bosnia-Herzegovina", CountryCurrencyDesc: "Bosnia-Herzegovina-Marka", MinorUnits: 2, Aliases: []string{"Bosnia and Herzegovina", "Bosnia"}},
+	{Code: "BHD", Name: "Bahraini Dinar", Country: "Bahrain", CountryCurrencyDesc: "Bahrain-Dinar", MinorUnits: 3},
+	{Code: "BRL", Name: "Brazilian Real", Country: "Brazil", CountryCurrencyDesc: "Brazil-Real", MinorUnits: 2},
+	{Code: "CAD", Name: "Canadian Dollar", Country: "Canada", CountryCurrencyDesc: "Canada-Dollar", MinorUnits: 2},
+	{Code: "CHF", Name: "Swiss Franc", Country: "Switzerland", CountryCurrencyDesc: "Switzerland-Franc", MinorUnits: 2},
+	{Code: "CLP", Name: "Chilean Peso", Country: "Chile", CountryCurrencyDesc: "Chile-Peso", MinorUnits: 0},
+	{Code: "CNY", Name: "Yuan Renminbi", Country: "China", CountryCurrencyDesc: "China-Renminbi", MinorUnits: 2},
+	{Code: "COP", Name: "Colombian Peso", Country: "Colombia", CountryCurrencyDesc: "Colombia-Peso", MinorUnits: 2},
+	{Code: "CZK", Name: "Czech Koruna", Country: "Czech Republic", CountryCurrencyDesc: "Czech Republic-Koruna", MinorUnits: 2, Aliases: []string{"Czechia"}},
+	{Code: "DKK", Name: "Danish Krone", Country: "Denmark", CountryCurrencyDesc: "Denmark-Krone", MinorUnits: 2},
+	{Code: "EGP", Name: "Egyptian Pound", Country: "Egypt", CountryCurrencyDesc: "Egypt-Pound", MinorUnits: 2},
+	{Code: "EUR", Name: "Euro", Country: "Euro Zone", CountryCurrencyDesc: "Euro Zone-Euro", MinorUnits: 2, Aliases: []string{"Eurozone", "Euro Area", "Europe"}},
+	{Code: "GBP", Name: "Pound Sterling", Country: "United Kingdom", CountryCurrencyDesc: "United Kingdom-Pound", MinorUnits: 2, Aliases: []string{"UK", "Great Britain", "Britain", "England"}},
+	{Code: "HKD", Name: "Hong Kong Dollar", Country: "Hong Kong", CountryCurrencyDesc: "Hong Kong-Dollar", MinorUnits: 2},
+	{Code: "HUF", Name: "Forint", Country: "Hungary", CountryCurrencyDesc: "Hungary-Forint", MinorUnits: 2},
+	{Code: "IDR", Name: "Rupiah", Country: "Indonesia", CountryCurrencyDesc: "Indonesia-Rupiah", MinorUnits: 2},
+	{Code: "ILS", Name: "New Israeli Sheqel", Country: "Israel", CountryCurrencyDesc: "Israel-Shekel", MinorUnits: 2},
+	{Code: "INR", Name: "Indian Rupee", Country: "India", CountryCurrencyDesc: "India-Rupee", MinorUnits: 2},
+	{Code: "ISK", Name: "Iceland Krona", Country: "Iceland", CountryCurrencyDesc: "Iceland-Krona", MinorUnits: 0},
+	{Code: "JOD", Name: "Jordanian Dinar", Country: "Jordan", CountryCurrencyDesc: "Jordan-Dinar", MinorUnits: 3},
+	{Code: "JPY", Name: "Yen", Country: "Japan", CountryCurrencyDesc: "Japan-Yen", MinorUnits: 0},
+	{Code: "KES", Name: "Kenyan Shilling", Country: "Kenya", CountryCurrencyDesc: "Kenya-Shilling", MinorUnits: 2},
+	{Code: "KRW", Name: "Won", Country: "Korea", CountryCurrencyDesc: "Korea-Won", MinorUnits: 0, Aliases: []string{"South Korea", "Republic of Korea"}},
+	{Code: "KWD", Name: "Kuwaiti Dinar", Country: "Kuwait", CountryCurrencyDesc: "Kuwait-Dinar", MinorUnits: 3},
+	{Code: "MXN", Name: "Mexican Peso", Country: "Mexico", CountryCurrencyDesc: "Mexico-Peso", MinorUnits: 2},
+	{Code: "MYR", Name: "Malaysian Ringgit", Country: "Malaysia", CountryCurrencyDesc: "Malaysia-Ringgit", MinorUnits: 2},
+	{Code: "NGN", Name: "Naira", Country: "Nigeria", CountryCurrencyDesc: "Nigeria-Naira", MinorUnits: 2},
+	{Code: "NOK", Name: "Norwegian Krone", Country: "Norway", CountryCurrencyDesc: "Norway-Krone", MinorUnits: 2},
+	{Code: "NZD", Name: "New Zealand Dollar", Country: "New Zealand", CountryCurrencyDesc: "New Zealand-Dollar", MinorUnits: 2},
+	{Code: "OMR", Name: "Rial Omani", Country: "Oman", CountryCurrencyDesc: "Oman-Rial", MinorUnits: 3},
+	{Code: "PEN", Name: "Sol", Country: "Peru", CountryCurrencyDesc: "Peru-Sol", MinorUnits: 2},
+	{Code: "PHP", Name: "Philippine Peso", Country: "Philippines", CountryCurrencyDesc: "Philippines-Peso", MinorUnits: 2},
+	{Code: "PKR", Name: "Pakistan Rupee", Country: "Pakistan", CountryCurrencyDesc: "Pakistan-Rupee", MinorUnits: 2},
+	{Code: "PLN", Name: "Zloty", Country: "Poland", CountryCurrencyDesc: "Poland-Zloty", MinorUnits: 2},
+	{Code: "RUB", Name: "Russian Ruble", Country: "Russia", CountryCurrencyDesc: "Russia-Ruble", MinorUnits: 2},
+	{Code: "SAR", Name: "Saudi Riyal", Country: "Saudi Arabia", CountryCurrencyDesc: "Saudi Arabia-Riyal", MinorUnits: 2},
+	{Code: "SEK", Name: "Swedish Krona", Country: "Sweden", CountryCurrencyDesc: "Sweden-Krona", MinorUnits: 2},
+	{Code: "SGD", Name: "Singapore Dollar", Country: "Singapore", CountryCurrencyDesc: "Singapore-Dollar", MinorUnits: 2},
+	{Code: "THB", Name: "Baht", Country: "Thailand", CountryCurrencyDesc: "Thailand-Baht", MinorUnits: 2},
+	{Code: "TRY", Name: "Turkish Lira", Country: "Turkey", CountryCurrencyDesc: "Turkey-New Lira", MinorUnits: 2, Aliases: []string{"Turkiye", "Türkiye"}},
+	{Code: "TWD", Name: "New Taiwan Dollar", Country: "Taiwan", CountryCurrencyDesc: "Taiwan-Dollar", MinorUnits: 2},
+	{Code: "UAH", Name: "Hryvnia", Country: "Ukraine", CountryCurrencyDesc: "Ukraine-Hryvnia", MinorUnits: 2},
+	{Code: USDCurrency, Name: "US Dollar", Country: "United States", MinorUnits: 2, Aliases: []string{"USA", "US", "United States of America"}},
+	{Code: "VND", Name: "Dong", Country: "Vietnam", CountryCurrencyDesc: "Vietnam-Dong", MinorUnits: 0, Aliases: []string{"Viet Nam"}},
+	{Code: "ZAR", Name: "Rand", Country: "South Africa", CountryCurrencyDesc: "South Africa-Rand", MinorUnits: 2},
+}
+
+// currencyIndex maps every lower-cased name of a currency (code, country,
+// country_currency_desc and aliases) to its position in the catalogue.
+var currencyIndex = func() map[string]int {
+	index := map[string]int{}
+	for i, currency := range currencies {
+		for _, name := range currencyNames(currency) {
+			index[strings.ToLower(name)] = i
+		}
+	}
+	return index
+}()
+
+// currencyNames returns every name a currency can be looked up by.
+func currencyNames(currency Currency) []string {
+	names := append([]string{currency.Code, currency.Country}, currency.Aliases...)
+	if currency.CountryCurrencyDesc != "" {
+		names = append(names, currency.CountryCurrencyDesc)
+	}
+	return names
+}
+
+// Currencies returns the catalogue, ordered by ISO code.
+func Currencies() []Currency {
+	return slices.Clone(currencies)
+}
+
+// LookupCurrency finds a currency by ISO code, Treasury country name, Treasury
+// country_currency_desc or alias, ignoring case and surrounding spaces.
+func LookupCurrency(name string) (Currency, bool) {
+	i, ok := currencyIndex[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Currency{}, false
+	}
+	return currencies[i], true
+}
+
+// MinorUnits returns the number of decimals of the currency with the given ISO
+// code. It returns false for currencies missing from the catalogue, whose
+// decimals are unknown.
+func MinorUnits(code string) (int, bool) {
+	if currency, ok := LookupCurrency(code); ok && currency.Code == strings.ToUpper(code) {
+		return currency.MinorUnits, true
+	}
+	return 0, false
+}
+
+// SuggestCurrencies returns up to five currencies whose names are close to the
+// given (misspelled) name, closest first, formatted as "CODE (Country)".
+func SuggestCurrencies(name string) []string {
+	query := strings.ToLower(strings.TrimSpace(name))
+	if query == "" {
+		return nil
+	}
+
+	type suggestion struct {
+		currency Currency
+		distance int
+	}
+	var suggestions []suggestion
+	for _, currency := range currencies {
+		best := -1
+		for _, candidate := range currencyNames(currency) {
+			candidate = strings.ToLower(candidate)
+			distance := levenshtein(query, candidate)
+			// Typos are at most a third of the name; a prefix of at least three letters also matches.
+			if distance > max(1, len(candidate)/3) && !(len(query) >= 3 && strings.HasPrefix(candidate, query)) {
+				continue
+			}
+			if best < 0 || distance < best {
+				best = distance
+			}
+		}
+		if best >= 0 {
+			suggestions = append(suggestions, suggestion{currency: currency, distance: best})
+		}
+	}
+
+	slices.SortStableFunc(suggestions, func(a, b suggestion) int {
+		return cmp.Compare(a.distance, b.distance)
+	})
+
+	var result []string
+	for _, s := range suggestions[:min(len(suggestions), maxCurrencySuggestions)] {
+		result = append(result, fmt.Sprintf("%s (%s)", s.currency.Code, s.currency.Country))
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCurrency(t *testing.T) {
+	var lookupTests = []struct {
+		name         string
+		expectedCode string
+	}{
+		{"EUR", "EUR"},
+		{"eur", "EUR"},
+		{"Euro Zone", "EUR"},
+		{"  euro zone ", "EUR"},
+		{"Euro Zone-Euro", "EUR"},
+		{"eurozone", "EUR"},
+		{"canada", "CAD"},
+		{"UK", "GBP"},
+		{"Bosnia-Herzegovina", "BAM"},
+		{"usd", "USD"},
+	}
+
+	for _, tt := range lookupTests {
+		currency, ok := LookupCurrency(tt.name)
+		assert.True(t, ok, tt.name)
+		assert.Equal(t, tt.expectedCode, currency.Code, tt.name)
+	}
+
+	_, ok := LookupCurrency("Atlantis")
+	assert.False(t, ok)
+}
+
+func TestCurrencyCatalogue(t *testing.T) {
+	seen := map[string]bool{}
+	for _, currency := range Currencies() {
+		for _, name := range currencyNames(currency) {
+			found, ok := LookupCurrency(name)
+			assert.True(t, ok, name)
+			assert.Equal(t, currency.Code, found.Code, "%s is ambiguous", name)
+		}
+		assert.False(t, seen[currency.Code], "%s is duplicated", currency.Code)
+		seen[currency.Code] = true
+	}
+}
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected int
+		ok       bool
+	}{
+		{"USD", 2, true},
+		{"jpy", 0, true},
+		{"KWD", 3, true},
+		{"XYZ", 0, false},
+		// Country names are not currency codes
+		{"Japan", 0, false},
+	}
+
+	for _, tt := range tests {
+		units, ok := MinorUnits(tt.code)
+		assert.Equal(t, tt.ok, ok, tt.code)
+		assert.Equal(t, tt.expected, units, tt.code)
+	}
+}
+
+func TestSuggestCurrencies(t *testing.T) {
+	assert.Equal(t, []string{"CAD (Canada)"}, SuggestCurrencies("Canda"))
+	assert.Equal(t, []string{"EUR (Euro Zone)"}, SuggestCurrencies("Euro Zon"))
+	assert.Contains(t, SuggestCurrencies("Swizerland"), "CHF (Switzerland)")
+	assert.Contains(t, SuggestCurrencies("EUT"), "EUR (Euro Zone)")
+	assert.Empty(t, SuggestCurrencies("Atlantis"))
+	assert.Empty(t, SuggestCurrencies(""))
+}
@@ -1,8 +1,284 @@
 package util
 
-import "math"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
 
-// roundToCents rounds a float64 value to the nearest cent.
-func RoundToCents(value float64) float64 {
-	return math.Round(value*100) / 100
+// USDCurrency is the ISO 4217 code of the currency purchases are stored in.
+const USDCurrency = "USD"
+
+// RoundingMode is how amounts are rounded to the minor unit of their currency.
+type RoundingMode string
+
+// Rounding modes selectable with the conversion.rounding setting.
+const (
+	// RoundHalfUp rounds to the nearest minor unit, ties away from zero.
+	RoundHalfUp RoundingMode = "half_up"
+	// RoundHalfEven rounds to the nearest minor unit, ties to the even one (banker's rounding).
+	RoundHalfEven RoundingMode = "half_even"
+	// RoundDown truncates towards zero.
+	RoundDown RoundingMode = "down"
+)
+
+// ParseRoundingMode parses a rounding mode name. An empty name means RoundHalfUp.
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	switch mode := RoundingMode(strings.ToLower(strings.TrimSpace(name))); mode {
+	case "":
+		return RoundHalfUp, nil
+	case RoundHalfUp, RoundHalfEven, RoundDown:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown rounding mode %q, expected half_up, half_even or down", name)
+	}
+}
+
+// Money is an exact monetary amount expressed in minor units (e.g. cents) of an
+// ISO 4217 currency. It never goes through floating point arithmetic.
+//
+// The number of minor units of the currency (its scale) comes from the currency
+// catalogue, e.g. 0 for JPY and 3 for KWD. Amounts are never made in currencies
+// missing from the catalogue, but they can have no currency, like the amounts
+// converted at the rate of a Treasury country missing from it, which are in
+// hundredths (see currencyScale).
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// USD returns a Money value holding the given number of US cents.
+func USD(cents int64) Money {
+	return Money{Amount: cents, Currency: USDCurrency}
+}
+
+// Errors returned when parsing monetary amounts, wrapped with the offending value.
+var (
+	ErrInvalidAmount   = errors.New("invalid monetary amount")
+	ErrUnknownCurrency = errors.New("currency missing from the catalogue")
+	ErrNotFinite       = errors.New("monetary amount is not a finite number")
+	ErrTooManyDecimals = errors.New("monetary amount has too many decimals")
+)
+
+// ParseMoney parses a decimal amount (e.g. "12.34", "1e2") in the given currency.
+// The amount is rounded half away from zero to the nearest minor unit.
+func ParseMoney(value string, currency string) (Money, error) {
+	rat, err := parseAmount(value)
+	if err != nil {
+		return Money{}, err
+	}
+
+	scale, err := currencyScale(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	amount, err := ratToMinorUnits(rat, scale, RoundHalfUp)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// ParseExactMoney parses a decimal amount like ParseMoney, but refuses amounts
+// with more decimals than the minor unit of the currency instead of rounding them.
+func ParseExactMoney(value string, currency string) (Money, error) {
+	rat, err := parseAmount(value)
+	if err != nil {
+		return Money{}, err
+	}
+
+	scale, err := currencyScale(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	if !new(big.Rat).Mul(rat, new(big.Rat).SetInt(pow10(scale))).IsInt() {
+		return Money{}, fmt.Errorf("%w: %q has more than %d decimals", ErrTooManyDecimals, value, scale)
+	}
+
+	amount, err := ratToMinorUnits(rat, scale, RoundDown)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// parseAmount parses a finite decimal amount. NaN, infinities and numbers too
+// large for a float64 are refused.
+func parseAmount(value string) (*big.Rat, error) {
+	value = strings.TrimSpace(value)
+	if f, _ := strconv.ParseFloat(value, 64); math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFinite, value)
+	}
+
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrInvalidAmount, value)
+	}
+	return rat, nil
+}
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// Scale returns the number of decimals of the amount, the minor units of its currency.
+func (m Money) Scale() int {
+	if scale, err := currencyScale(m.Currency); err == nil {
+		return scale
+	}
+	// Only reached by amounts built by hand: the constructors refuse unknown currencies.
+	return unknownCurrencyScale
+}
+
+// unknownCurrencyScale is the scale of the amounts without a currency.
+const unknownCurrencyScale = 2
+
+// currencyScale returns the minor units of the currency with the given ISO code,
+// or unknownCurrencyScale without a code. Currencies missing from the catalogue
+// are refused with ErrUnknownCurrency rather than given a scale that may be wrong.
+func currencyScale(code string) (int, error) {
+	if code == "" {
+		return unknownCurrencyScale, nil
+	}
+	scale, ok := MinorUnits(code)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return scale, nil
+}
+
+// Mul multiplies the amount by the given exchange rate and returns the result in
+// the target currency, rounded to its minor unit with the given mode.
+//
+// The rate is taken at its shortest decimal representation (e.g. 70.35), so the
+// product is exact before rounding.
+func (m Money) Mul(rate float64, currency string, mode RoundingMode) (Money, error) {
+	return m.Convert(1, rate, currency, mode)
+}
+
+// Convert converts the amount between two currencies quoted against a common
+// one, e.g. the US dollar: fromRate is the rate of the currency of the amount
+// and toRate the rate of the target currency, in units per common currency. The
+// result, amount * toRate / fromRate, is rounded once to the minor unit of the
+// target currency with the given mode.
+//
+// Like in Mul, the rates are taken at their shortest decimal representation.
+func (m Money) Convert(fromRate float64, toRate float64, currency string, mode RoundingMode) (Money, error) {
+	from, ok := new(big.Rat).SetString(strconv.FormatFloat(fromRate, 'f', -1, 64))
+	if !ok || from.Sign() <= 0 {
+		return Money{}, fmt.Errorf("invalid exchange rate %v", fromRate)
+	}
+	to, ok := new(big.Rat).SetString(strconv.FormatFloat(toRate, 'f', -1, 64))
+	if !ok {
+		return Money{}, fmt.Errorf("invalid exchange rate %v", toRate)
+	}
+
+	scale, err := currencyScale(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	product := new(big.Rat).Mul(new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(m.Scale())), to)
+	amount, err := ratToMinorUnits(product.Quo(product, from), scale, mode)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// String formats the amount as a plain decimal number with the scale of its
+// currency, e.g. "12.34" for USD and "1234" for JPY.
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	scale := m.Scale()
+	if scale == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+
+	factor := pow10(scale).Int64()
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/factor, scale, amount%factor)
+}
+
+// MarshalJSON encodes the amount as a JSON number with the scale of its currency.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number (or a numeric string) into US cents.
+// Amounts with more than two decimals are refused rather than rounded.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	value := string(data)
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	parsed, err := ParseExactMoney(value, USDCurrency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// ratToMinorUnits converts a major-unit rational amount to minor units of a
+// currency with the given scale, rounding with the given mode.
+func ratToMinorUnits(rat *big.Rat, scale int, mode RoundingMode) (int64, error) {
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt(pow10(scale)))
+
+	// QuoRem truncates towards zero; the remainder decides the rounding.
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// Compare 2*|rem| with the denominator to locate the half.
+		half := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom())
+
+		var awayFromZero bool
+		switch mode {
+		case RoundHalfUp:
+			awayFromZero = half >= 0
+		case RoundHalfEven:
+			awayFromZero = half > 0 || (half == 0 && quo.Bit(0) == 1)
+		case RoundDown:
+			awayFromZero = false
+		default:
+			return 0, fmt.Errorf("unknown rounding mode %q", mode)
+		}
+
+		if awayFromZero {
+			if rem.Sign() < 0 {
+				quo.Sub(quo, big.NewInt(1))
+			} else {
+				quo.Add(quo, big.NewInt(1))
+			}
+		}
+	}
+
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("monetary amount out of range")
+	}
+
+	return quo.Int64(), nil
+}
+
+// pow10 returns 10 to the given power.
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
 }
@@ -1,36 +1,286 @@
 package util
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
 
-func TestRoundToCents(t *testing.T) {
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
 	tests := []struct {
 		name           string
-		amount         float64
-		expectedResult float64
+		amount         string
+		expectedResult int64
+		expectedError  bool
 	}{
 		{
 			name:           "Round up",
-			amount:         1.006,
-			expectedResult: 1.01,
+			amount:         "1.006",
+			expectedResult: 101,
+		},
+		{
+			name:           "Round half up",
+			amount:         "1.005",
+			expectedResult: 101,
 		},
 		{
 			name:           "Round down",
-			amount:         1.005,
-			expectedResult: 1.00,
+			amount:         "1.004",
+			expectedResult: 100,
 		},
 		{
 			name:           "No rounding needed",
-			amount:         1.00,
-			expectedResult: 1.00,
+			amount:         "1.00",
+			expectedResult: 100,
+		},
+		{
+			name:           "Large amount keeps every cent",
+			amount:         "92233720368547.75",
+			expectedResult: 9223372036854775,
+		},
+		{
+			name:           "Exponent notation",
+			amount:         "1e2",
+			expectedResult: 10000,
+		},
+		{
+			name:          "Not a number",
+			amount:        "abc",
+			expectedError: true,
+		},
+		{
+			name:          "Not finite",
+			amount:        "1e400",
+			expectedError: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := RoundToCents(tt.amount)
-			if got != tt.expectedResult {
-				t.Errorf("roundToCents() = %v, want %v", got, tt.expectedResult)
+			got, err := ParseMoney(tt.amount, USDCurrency)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, USD(tt.expectedResult), got)
+		})
+	}
+}
+
+func TestParseExactMoney(t *testing.T) {
+	tests := []struct {
+		name           string
+		amount         string
+		currency       string
+		expectedResult Money
+		expectedError  error
+	}{
+		{name: "Two decimals", amount: "12.34", currency: USDCurrency, expectedResult: USD(1234)},
+		{name: "Trailing zeros", amount: "12.3400", currency: USDCurrency, expectedResult: USD(1234)},
+		{name: "Exponent notation", amount: "1234e-2", currency: USDCurrency, expectedResult: USD(1234)},
+		{name: "Scale of the currency", amount: "1.234", currency: "KWD", expectedResult: Money{Amount: 1234, Currency: "KWD"}},
+		{name: "Too many decimals", amount: "12.345", currency: USDCurrency, expectedError: ErrTooManyDecimals},
+		{name: "Decimals of a currency without minor unit", amount: "1.5", currency: "JPY", expectedError: ErrTooManyDecimals},
+		{name: "NaN", amount: "NaN", currency: USDCurrency, expectedError: ErrNotFinite},
+		{name: "Infinity", amount: "-Infinity", currency: USDCurrency, expectedError: ErrNotFinite},
+		{name: "Overflows a float", amount: "1e309", currency: USDCurrency, expectedError: ErrNotFinite},
+		{name: "Not a number", amount: "abc", currency: USDCurrency, expectedError: ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExactMoney(tt.amount, tt.currency)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
 			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, got)
 		})
 	}
 }
+
+func TestMoneyMul(t *testing.T) {
+	tests := []struct {
+		name           string
+		amount         Money
+		rate           float64
+		currency       string
+		mode           RoundingMode
+		expectedResult int64
+	}{
+		{
+			name:           "Identity rate",
+			amount:         USD(1234),
+			rate:           1.0,
+			currency:       "EUR",
+			mode:           RoundHalfUp,
+			expectedResult: 1234,
+		},
+		{
+			name:           "Exact decimal rate",
+			amount:         USD(1005),
+			rate:           70.35,
+			currency:       "EUR",
+			mode:           RoundHalfUp,
+			expectedResult: 70702, // 10.05 * 70.35 = 707.0175
+		},
+		{
+			name:           "Half cent rounds up",
+			amount:         USD(1),
+			rate:           0.5,
+			currency:       "EUR",
+			mode:           RoundHalfUp,
+			expectedResult: 1,
+		},
+		{
+			name:           "Half cent rounds to even",
+			amount:         USD(1),
+			rate:           0.5,
+			currency:       "EUR",
+			mode:           RoundHalfEven,
+			expectedResult: 0,
+		},
+		{
+			name:           "Half cent rounds to even upwards",
+			amount:         USD(3),
+			rate:           0.5,
+			currency:       "EUR",
+			mode:           RoundHalfEven,
+			expectedResult: 2,
+		},
+		{
+			name:           "Down truncates",
+			amount:         USD(1005),
+			rate:           70.35,
+			currency:       "EUR",
+			mode:           RoundDown,
+			expectedResult: 70701,
+		},
+		{
+			name:           "Zero decimal currency",
+			amount:         USD(1234),
+			rate:           149.55,
+			currency:       "JPY",
+			mode:           RoundHalfUp,
+			expectedResult: 1845, // 12.34 * 149.55 = 1845.447
+		},
+		{
+			name:           "Three decimal currency",
+			amount:         USD(1234),
+			rate:           0.30765,
+			currency:       "KWD",
+			mode:           RoundHalfUp,
+			expectedResult: 3796, // 12.34 * 0.30765 = 3.796401
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Mul(tt.rate, tt.currency, tt.mode)
+			assert.NoError(t, err)
+			assert.Equal(t, Money{Amount: tt.expectedResult, Currency: tt.currency}, got)
+		})
+	}
+
+	_, err := USD(1).Mul(0.5, "EUR", "ceiling")
+	assert.ErrorContains(t, err, `unknown rounding mode "ceiling"`)
+
+	// Currencies missing from the catalogue have no known minor unit
+	_, err = USD(1).Mul(0.5, "XYZ", RoundHalfUp)
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+	_, err = ParseMoney("1", "XYZ")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name           string
+		amount         Money
+		fromRate       float64
+		toRate         float64
+		currency       string
+		mode           RoundingMode
+		expectedResult int64
+	}{
+		{
+			name:           "Foreign to US dollar",
+			amount:         Money{Amount: 12000, Currency: "EUR"},
+			fromRate:       0.9,
+			toRate:         1,
+			currency:       USDCurrency,
+			mode:           RoundHalfUp,
+			expectedResult: 13333, // 120 / 0.9 = 133.333...
+		},
+		{
+			name:           "Cross rate rounded once",
+			amount:         Money{Amount: 12000, Currency: "EUR"},
+			fromRate:       0.9,
+			toRate:         1.35,
+			currency:       "CAD",
+			mode:           RoundHalfUp,
+			expectedResult: 18000, // 120 * 1.35 / 0.9 = 180, not 133.33 * 1.35 = 179.9955
+		},
+		{
+			name:           "Zero decimal source currency",
+			amount:         Money{Amount: 1000, Currency: "JPY"},
+			fromRate:       149.55,
+			toRate:         0.96,
+			currency:       "EUR",
+			mode:           RoundDown,
+			expectedResult: 641, // 1000 * 0.96 / 149.55 = 6.4193...
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Convert(tt.fromRate, tt.toRate, tt.currency, tt.mode)
+			assert.NoError(t, err)
+			assert.Equal(t, Money{Amount: tt.expectedResult, Currency: tt.currency}, got)
+		})
+	}
+
+	_, err := USD(1).Convert(0, 1, "EUR", RoundHalfUp)
+	assert.ErrorContains(t, err, "invalid exchange rate 0")
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	for name, expected := range map[string]RoundingMode{"": RoundHalfUp, "half_up": RoundHalfUp, "HALF_EVEN": RoundHalfEven, "down": RoundDown} {
+		mode, err := ParseRoundingMode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	_, err := ParseRoundingMode("up")
+	assert.Error(t, err)
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "12.34", USD(1234).String())
+	assert.Equal(t, "-0.05", USD(-5).String())
+	assert.Equal(t, "1845", Money{Amount: 1845, Currency: "JPY"}.String())
+	assert.Equal(t, "3.796", Money{Amount: 3796, Currency: "KWD"}.String())
+	assert.Equal(t, "0.012", Money{Amount: 12, Currency: "KWD"}.String())
+	assert.Equal(t, "1.00", Money{Amount: 100}.String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var m Money
+	assert.NoError(t, json.Unmarshal([]byte("12.34"), &m))
+	assert.Equal(t, USD(1234), m)
+
+	assert.NoError(t, json.Unmarshal([]byte(`"0.1"`), &m))
+	assert.Equal(t, USD(10), m)
+
+	assert.Error(t, json.Unmarshal([]byte(`"ten"`), &m))
+	assert.ErrorIs(t, json.Unmarshal([]byte("1.005"), &m), ErrTooManyDecimals)
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"NaN"`), &m), ErrNotFinite)
+
+	data, err := json.Marshal(USD(-105))
+	assert.NoError(t, err)
+	assert.Equal(t, "-1.05", string(data))
+}